@@ -0,0 +1,455 @@
+package anon
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+// Options stores the optional settings of a Processor
+type Options struct {
+	// Number of records anonymised in parallel, 1 if not set
+	Workers int
+	// If not nil, the rejected records are written to it as a csv
+	Rejects io.Writer
+	// If true, the raw values of the rejected records are written to
+	// Rejects hashed with a random salt, otherwise they are omitted
+	HashRejectedValues bool
+	// If not nil, the statistics of the run are collected in it
+	Stats *Stats
+}
+
+// Processor anonymises csv, JSON Lines or Parquet files according to a config
+type Processor struct {
+	conf  *Config
+	anons []Anonymisation
+	opts  Options
+	// Only set when the format is JSON Lines
+	schema *jsonlSchema
+	// Anonymisations that depend on the context of
+	// the record, by the position of their action
+	byContext map[int]contextAnonymisation
+}
+
+// NewProcessor creates the anonymisations defined in the
+// config and returns a Processor that applies them.
+func NewProcessor(conf *Config, opts Options) (*Processor, error) {
+	schema, err := formatSchema(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.withoutHeader() {
+		if _, err := headerlessColumns(conf); err != nil {
+			return nil, err
+		}
+	}
+	anons, err := anonymisations(conf.Actions)
+	if len(conf.Output) > 0 {
+		anons, err = outputAnonymisations(conf.Output)
+	}
+	if err != nil {
+		return nil, err
+	}
+	byContext, err := configContextAnonymisations(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Processor{conf: conf, anons: anons, opts: opts, schema: schema, byContext: byContext}, nil
+}
+
+// Checks the format of the config, returning the schema
+// of the records if it is JSON Lines
+func formatSchema(conf *Config) (*jsonlSchema, error) {
+	if err := checkOutput(conf); err != nil {
+		return nil, err
+	} else if err := checkKAnonymity(conf); err != nil {
+		return nil, err
+	}
+	switch conf.Format {
+	case "", FormatCsv:
+		return nil, conf.Csv.check()
+	case FormatJSONL:
+		return newJSONLSchema(conf)
+	case FormatParquet:
+		return nil, checkParquetConfig(conf)
+	}
+	return nil, fmt.Errorf("invalid format %s, it must be one of '%s', '%s' or '%s'", conf.Format, FormatCsv, FormatJSONL, FormatParquet)
+}
+
+// Process reads a csv (or JSON Lines or Parquet) file from in, samples and anonymises its records
+// and writes them to out. The records that can't be read or anonymised
+// are skipped and written to the rejects writer if there is one. If an
+// action computes its bins from the data, the input is read twice: in is
+// seeked back to its start if it's seekable, or else copied to a temporary
+// file as it's read the first time.
+func (p *Processor) Process(in io.Reader, out io.Writer) error {
+	open := func() (io.Reader, error) { return in, nil }
+	if needsFirstPass(p.conf) {
+		if s, ok := in.(io.ReadSeeker); ok && seekable(s) {
+			start, _ := s.Seek(0, io.SeekCurrent)
+			open = func() (io.Reader, error) {
+				_, err := s.Seek(start, io.SeekStart)
+				return in, err
+			}
+		} else {
+			f, err := ioutil.TempFile("", "anon")
+			if err != nil {
+				return err
+			}
+			copied := tempFile{f}
+			defer copied.Close()
+			read := false
+			open = func() (io.Reader, error) {
+				if !read {
+					read = true
+					return io.TeeReader(in, f), nil
+				}
+				// copies the rest of the input, if the first pass didn't read it all
+				if _, err := io.Copy(f, in); err != nil {
+					return nil, err
+				}
+				_, err := f.Seek(0, io.SeekStart)
+				return copied, err
+			}
+		}
+	}
+	return p.passes(open, out)
+}
+
+// ProcessFrom is like Process, but it calls open to read the input from
+// its start in each pass instead of keeping a copy of it (e.g. to
+// decompress a file again). The readers returned are closed when the
+// input has been processed.
+func (p *Processor) ProcessFrom(open func() (io.ReadCloser, error), out io.Writer) error {
+	var opened []io.Closer
+	defer func() {
+		for _, c := range opened {
+			c.Close()
+		}
+	}()
+	return p.passes(func() (io.Reader, error) {
+		in, err := open()
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, in)
+		return in, nil
+	}, out)
+}
+
+// Processes the input returned by open. If the actions need a first pass,
+// it reads the whole input without writing it, so the anonymisations
+// collect the values they need before anonymising any record (e.g. to
+// compute the quantiles of a column), and then opens it again.
+func (p *Processor) passes(open func() (io.Reader, error), out io.Writer) error {
+	opts := processOptions{stats: p.opts.Stats, workers: p.opts.Workers, byContext: p.byContext}
+	opts.stats.spent(privacyBudgets(p.conf))
+	if p.conf.KAnonymity.K > 1 {
+		opts.kAnonymity, opts.quasiIdentifiers = newKAnonymity(p.conf.KAnonymity), quasiIdentifiers(p.conf)
+	}
+	if needsFirstPass(p.conf) {
+		// The anonymisations keep the values collected in the
+		// first pass, so they are created again for each input
+		var err error
+		if opts.byContext, err = configContextAnonymisations(p.conf); err != nil {
+			return err
+		}
+		in, err := open()
+		if err != nil {
+			return err
+		}
+		first := processOptions{workers: opts.workers, byContext: opts.byContext, kAnonymity: opts.kAnonymity, quasiIdentifiers: opts.quasiIdentifiers, firstPass: true}
+		if err := p.process(in, ioutil.Discard, first); err != nil {
+			return err
+		}
+	}
+	if p.opts.Rejects != nil {
+		var hashValue Anonymisation
+		if p.opts.HashRejectedValues {
+			hashValue = hash(strconv.Itoa(rand.Int()))
+		}
+		opts.rejects = newRejectsWriter(p.opts.Rejects, hashValue)
+	}
+	in, err := open()
+	if err != nil {
+		return err
+	}
+	return p.process(in, out, opts)
+}
+
+// Returns if the input can be seeked, which fails for pipes even if they
+// are files
+func seekable(s io.Seeker) bool {
+	_, err := s.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+// Returns if the actions (or the output columns) need a first pass over
+// the input to collect their values before anonymising any record, or
+// k-anonymity is enforced
+func needsFirstPass(conf *Config) bool {
+	if conf.KAnonymity.K > 1 {
+		return true
+	}
+	for _, column := range conf.Output {
+		if anyQuantiles(column.Actions) {
+			return true
+		}
+	}
+	return anyQuantiles(conf.Actions)
+}
+
+// tempFile is a temporary file that is removed when it's closed
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// Reads the input in the format of the config, anonymises it and writes it to out
+func (p *Processor) process(in io.Reader, out io.Writer, opts processOptions) error {
+	if p.schema != nil {
+		return processRecords(newJSONLReader(in, p.conf.Sampling, p.schema), newJSONLWriter(out), p.anons, opts)
+	} else if p.conf.Format == FormatParquet {
+		return processParquet(in, out, p.conf, p.anons, opts)
+	}
+	return process(newReader(in, p.conf.Csv), newWriter(out, p.conf.Csv), p.conf, &p.anons, opts)
+}
+
+// Reveal reads a csv (or JSON Lines or Parquet) file previously anonymised with the same config (and keys)
+// from in, reverses its encrypted columns and writes it to out. It fails
+// with ErrAuthentication if a value wasn't encrypted with the same key.
+func Reveal(conf *Config, in io.Reader, out io.Writer) error {
+	schema, err := formatSchema(conf)
+	if err != nil {
+		return err
+	}
+	if conf.withoutHeader() {
+		if _, err := headerlessColumns(conf); err != nil {
+			return err
+		}
+	}
+	revs, err := Reversals(conf.Actions)
+	if len(conf.Output) > 0 {
+		revs, err = outputReversals(conf.Output)
+	}
+	if err != nil {
+		return err
+	}
+	if schema != nil {
+		return reveal(newJSONLReader(in, SamplingConfig{}, &jsonlSchema{paths: schema.paths, keepTypes: schema.keepTypes}), newJSONLWriter(out), revs)
+	} else if conf.Format == FormatParquet {
+		return revealParquet(in, out, conf, revs)
+	}
+	if conf.Csv.DropHeader {
+		return errors.New("can't reveal a csv anonymised without its header")
+	}
+	r, w := &csvReader{r: newReader(in, conf.Csv)}, newWriter(out, conf.Csv)
+	revsByColumn, _, err := readHeader(r, w, conf, &revs, true)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return reveal(r, &csvWriter{w}, *revsByColumn)
+}
+
+// processOptions stores the optional settings of process
+type processOptions struct {
+	// Where the rejected records are reported to, can be nil
+	rejects *rejectsWriter
+	// Where the statistics of the run are collected, can be nil
+	stats *Stats
+	// Number of goroutines anonymising records in parallel
+	workers int
+	// Anonymisations that depend on the context of the
+	// record, by the position of the column they apply to
+	byContext map[int]contextAnonymisation
+	// If set, the k-anonymity enforced on the quasi-identifiers,
+	// by the position of their columns
+	kAnonymity       *kAnonymity
+	quasiIdentifiers []int
+	// If true, it's the first pass over the input, whose
+	// output is discarded, to collect the values of the records
+	firstPass bool
+}
+
+// Reads, samples and anonymises every record of a csv. The records that
+// can't be read or anonymised are skipped and reported to the rejects writer.
+func process(r *csv.Reader, w *csv.Writer, conf *Config, anons *[]Anonymisation, opts processOptions) error {
+	cr := &csvReader{r: r, sampling: conf.Sampling}
+	anons, idColumn, err := readHeader(cr, w, conf, anons, false)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	cr.idColumn = idColumn
+	if cr.header != nil && len(conf.Output) == 0 {
+		opts.byContext = contextByColumn(conf.Actions, opts.byContext, cr.header)
+		opts.quasiIdentifiers = columnPositions(conf.Actions, opts.quasiIdentifiers, cr.header)
+	}
+	return processRecords(cr, &csvWriter{w}, *anons, opts)
+}
+
+// If the csv has a header (or it's detected), it reads it, writes it to the
+// output with the columns renamed (unless it's dropped) and resolves the
+// anonymisations and the id column against it. Otherwise returns the
+// anonymisations and the id column as configured. When revealing, the
+// header is the one written, so the renamed columns are resolved by their
+// new names and it's written unchanged.
+func readHeader(r *csvReader, w *csv.Writer, conf *Config, anons *[]Anonymisation, revealing bool) (*[]Anonymisation, uint32, error) {
+	hasHeader := conf.Csv.Header
+	if conf.Csv.DetectHeader {
+		var err error
+		if hasHeader, err = r.detectHeader(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if !hasHeader {
+		if _, err := headerlessColumns(conf); err != nil {
+			return nil, 0, err
+		}
+		if len(conf.Csv.Rename) > 0 {
+			return nil, 0, errors.New("the columns can't be renamed, the csv doesn't have a header")
+		}
+		if references := referencedColumns(conf); len(references) > 0 && !revealing {
+			return nil, 0, fmt.Errorf("the columns %v are referenced by name, but the csv doesn't have a header", references)
+		}
+		if len(conf.Output) > 0 && !revealing {
+			var err error
+			if r.columns, _, err = outputColumns(conf.Output, nil); err != nil {
+				return nil, 0, err
+			}
+		}
+		return anons, conf.Sampling.IDColumn, nil
+	}
+	row := r.next()
+	if row.err != nil {
+		return nil, 0, row.err
+	}
+	if !revealing {
+		columns, err := headerIndices(row.values)
+		if err != nil {
+			return nil, 0, err
+		}
+		if r.references, err = resolveReferences(referencedColumns(conf), columns); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(conf.Output) > 0 {
+		return readOutputHeader(r, w, row.values, conf, anons, revealing)
+	}
+	header, renamed := row.values, renamedHeader(row.values, conf.Csv.Rename)
+	if revealing {
+		header, renamed = originalHeader(header, conf.Csv.Rename), header
+	}
+	anons, idColumn, err := resolveHeader(header, conf, *anons)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.header, _ = headerIndices(header)
+	if err := checkRename(header, renamed, conf.Csv.Rename); err != nil {
+		return nil, 0, err
+	}
+	if !conf.Csv.DropHeader {
+		w.Write(renamed)
+	}
+	return anons, idColumn, nil
+}
+
+// Returns the header with the columns renamed
+func renamedHeader(header []string, rename map[string]string) []string {
+	res := make([]string, len(header))
+	for i, name := range header {
+		res[i] = name
+		if newName, ok := rename[name]; ok {
+			res[i] = newName
+		}
+	}
+	return res
+}
+
+// Returns the header with the renamed columns back to their original names
+func originalHeader(header []string, rename map[string]string) []string {
+	original := make(map[string]string, len(rename))
+	for name, newName := range rename {
+		original[newName] = name
+	}
+	return renamedHeader(header, original)
+}
+
+// Checks that all the renamed columns are in the header and
+// that the renamed header doesn't have duplicated names
+func checkRename(header []string, renamed []string, rename map[string]string) error {
+	columns, err := headerIndices(header)
+	if err != nil {
+		return err
+	}
+	for name := range rename {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("column %s to rename not found in the header", name)
+		}
+	}
+	if _, err := headerIndices(renamed); err != nil {
+		return fmt.Errorf("invalid renamed header: %v", err)
+	}
+	return nil
+}
+
+// Given the header of the csv, returns the anonymisations sorted
+// by the position of the column they apply to and the index of the
+// id column.
+func resolveHeader(header []string, conf *Config, anons []Anonymisation) (*[]Anonymisation, uint32, error) {
+	columns, err := headerIndices(header)
+	if err != nil {
+		return nil, 0, err
+	}
+	sorted, err := byColumn(conf.Actions, anons, columns)
+	if err != nil {
+		return nil, 0, err
+	}
+	idColumn, err := conf.Sampling.idColumnIndex(columns)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &sorted, idColumn, nil
+}
+
+// Returns if the record with the given id has to be included in
+// the sample. If mod is not set, all the records are included.
+func sample(s string, conf SamplingConfig) bool {
+	if conf.Mod == 0 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()%conf.Mod == 0
+}
+
+// Anonymise applies each anonymisation to the column in the same
+// position of the record. Columns without an anonymisation are left
+// unchanged.
+func Anonymise(record []string, anons []Anonymisation) ([]string, error) {
+	var err error
+	for i := range record {
+		// TODO decide if we fail if not enough anonmisations are defined
+		// or we just skip the column (i.e. we apply identity)
+		if i < len(anons) {
+			value := record[i]
+			if record[i], err = anons[i](value); err != nil {
+				return nil, columnError{i, value, err}
+			}
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,341 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReader(t *testing.T) {
+	t.Run("with the default config", func(t *testing.T) {
+		record, err := newReader(strings.NewReader("a,b"), defaultCsvConfig).Read()
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, []string{"a", "b"}, record, "should return a csv reader that reads from the input")
+	})
+	t.Run("with a delimiter", func(t *testing.T) {
+		record, err := newReader(strings.NewReader("a|b"), CsvConfig{Delimiter: "|"}).Read()
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, []string{"a", "b"}, record, "should use the delimiter")
+	})
+}
+
+func TestNewWriter(t *testing.T) {
+	t.Run("with the default config", func(t *testing.T) {
+		var out bytes.Buffer
+		w := newWriter(&out, defaultCsvConfig)
+		err := w.Write([]string{"csv", "content"})
+		w.Flush()
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "csv,content\n", out.String(), "should return a csv writer that writes to the output")
+	})
+	t.Run("with a delimiter", func(t *testing.T) {
+		var out bytes.Buffer
+		w := newWriter(&out, CsvConfig{Delimiter: "|"})
+		err := w.Write([]string{"csv", "content"})
+		w.Flush()
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "csv|content\n", out.String(), "should use the delimiter")
+	})
+}
+
+func TestProcessorProcess(t *testing.T) {
+	conf := &Config{
+		Csv: CsvConfig{Delimiter: "|"},
+		Actions: []ActionConfig{
+			ActionConfig{Name: "nothing"},
+			ActionConfig{Name: "year", DateConfig: DateConfig{Format: "20060102"}},
+		},
+	}
+	t.Run("with an invalid config", func(t *testing.T) {
+		p, err := NewProcessor(&Config{Actions: []ActionConfig{ActionConfig{Name: "invalid"}}}, Options{})
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, p)
+	})
+	t.Run("with a valid config", func(t *testing.T) {
+		var out, rejects bytes.Buffer
+		st := NewStats()
+		p, err := NewProcessor(conf, Options{Rejects: &rejects, HashRejectedValues: true, Stats: st, Workers: 2})
+		require.NoError(t, err)
+		err = p.Process(strings.NewReader("a|20020202\nb|fail\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "a|2002\n", out.String(), "should anonymise the input")
+		assert.Regexp(t, "^line,column,action,reason,value\n2,1,year,can't parse the date with the format 20060102,[0-9a-f]{40}\n$", rejects.String(), "should write the rejected records with their values hashed")
+		assert.Equal(t, int64(2), st.Read, "should collect the statistics")
+	})
+}
+
+func TestAnonymise(t *testing.T) {
+	record := []string{"a", "b", "c"}
+	actions := []Anonymisation{identity, hash(""), identity}
+	output := []string{"a", "e9d71f5ee7c92d6dc9e92ffdad17b8bd49418f98", "c"}
+	res, err := Anonymise(record, actions)
+	assert.NoError(t, err)
+	assert.Equal(t, output, res, "should apply anonymisation functions to each column in the record")
+}
+
+func TestSample(t *testing.T) {
+	conf := SamplingConfig{
+		Mod: 2,
+	}
+	assert.True(t, sample("a", conf))
+	assert.False(t, sample("b", conf))
+}
+
+func TestProcess(t *testing.T) {
+	config := func(mod uint32, idColumn uint32) *Config {
+		return &Config{Sampling: SamplingConfig{Mod: mod, IDColumn: idColumn}}
+	}
+	anons := &[]Anonymisation{identity, outcode}
+	createReaderAndWriter := func(in string) (*csv.Reader, *csv.Writer, *bytes.Buffer) {
+		var out bytes.Buffer
+		r := csv.NewReader(strings.NewReader(in))
+
+		w := csv.NewWriter(&out)
+		return r, w, &out
+	}
+	t.Run("when the id column is out of range", func(t *testing.T) {
+		r, w, out := createReaderAndWriter("a,b c\nd,e f\n")
+
+		err := process(r, w, config(1, 100), anons, processOptions{})
+		assert.Error(t, err, "should return an error")
+		assert.Equal(t, "", out.String(), "shouldn't write any output")
+	})
+	t.Run("when the columns are referenced by name without a header", func(t *testing.T) {
+		for _, conf := range []*Config{
+			&Config{Actions: []ActionConfig{ActionConfig{Name: "outcode", Column: "postcode"}, ActionConfig{Name: "nothing", Column: "id"}}},
+			&Config{Sampling: SamplingConfig{Mod: 1, IDColumnName: "id"}},
+		} {
+			r, w, out := createReaderAndWriter("id,postcode\n1,W1W 8BE\n")
+			err := process(r, w, conf, anons, processOptions{})
+			assert.Error(t, err, "should return an error")
+			assert.Equal(t, "", out.String(), "shouldn't write any output")
+			_, err = NewProcessor(conf, Options{})
+			assert.Error(t, err, "should fail to create the processor")
+		}
+	})
+	t.Run("when there is an error writing the output", func(t *testing.T) {
+		var out bytes.Buffer
+		f, _ := os.Open("non existing file")
+		r := csv.NewReader(f)
+
+		w := csv.NewWriter(&out)
+		err := process(r, w, config(1, 0), anons, processOptions{})
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("when there is an error processing one of the rows", func(t *testing.T) {
+		r, w, out := createReaderAndWriter("20020202\nfail\n10010101")
+
+		y, _ := year("20060102")
+		err := process(r, w, config(1, 0), &[]Anonymisation{y}, processOptions{})
+		assert.NoError(t, err, "should not return an error")
+		assert.Equal(t, "2002\n1001\n", out.String(), "should skip that row")
+	})
+	t.Run("when there are rejected rows", func(t *testing.T) {
+		r, w, out := createReaderAndWriter("20020202,a\nfail,b\n10010101,c\nd\n")
+		var rejectsOut bytes.Buffer
+		rejects := newRejectsWriter(&rejectsOut, nil)
+
+		y, _ := year("20060102")
+		r.FieldsPerRecord = 2
+		err := process(r, w, config(1, 1), &[]Anonymisation{named("year", y)}, processOptions{rejects: rejects})
+		assert.NoError(t, err, "should not return an error")
+		assert.Equal(t, "2002,a\n1001,c\n", out.String(), "should skip the rows")
+		assert.Equal(t, "line,column,action,reason,value\n2,0,year,can't parse the date with the format 20060102,\n4,,,wrong number of fields,\n", rejectsOut.String(), "should write the rejected rows")
+	})
+	t.Run("when collecting statistics", func(t *testing.T) {
+		r, w, _ := createReaderAndWriter("20020202,a\nfail,a\n10010101,b\n20020202,b\n")
+		st := NewStats()
+
+		y, _ := year("20060102")
+		err := process(r, w, config(2, 1), &[]Anonymisation{named("year", y)}, processOptions{stats: st})
+		assert.NoError(t, err, "should not return an error")
+		assert.Equal(t, int64(4), st.Read, "should count the records read")
+		assert.Equal(t, int64(2), st.SampledOut, "should count the records sampled out")
+		assert.Equal(t, int64(1), st.Written, "should count the records written")
+		assert.Equal(t, int64(1), st.Rejected, "should count the records rejected")
+		assert.Equal(t, map[string]int64{"year": 1}, st.Columns[0].Errors, "should count the errors by action")
+	})
+	t.Run("when collecting statistics of the errors handled by the policies", func(t *testing.T) {
+		r, w, out := createReaderAndWriter("20020202,a\nfail,b\nfail,c\n")
+		st := NewStats()
+
+		y, _ := year("20060102")
+		ac := ActionConfig{Name: "year", OnError: "null"}
+		y, _ = ac.withErrorPolicy(named("year", y))
+		err := process(r, w, config(1, 1), &[]Anonymisation{y}, processOptions{stats: st, workers: 2})
+		assert.NoError(t, err, "should not return an error")
+		assert.Equal(t, "2002,a\n,b\n,c\n", out.String(), "should write the values replaced by the policy")
+		assert.Equal(t, int64(3), st.Written, "should count the records written")
+		assert.Equal(t, int64(0), st.Rejected, "shouldn't reject any record")
+		assert.Equal(t, map[string]int64{"year": 2}, st.Columns[0].Errors, "should count the errors handled")
+	})
+	t.Run("when there is an error processing a column that has to fail", func(t *testing.T) {
+		r, w, _ := createReaderAndWriter("20020202\nfail\n10010101")
+
+		y, _ := year("20060102")
+		ac := ActionConfig{Name: "year", OnError: "fail"}
+		y, _ = ac.withErrorPolicy(y)
+		err := process(r, w, config(1, 0), &[]Anonymisation{y}, processOptions{})
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("when sampling is defined", func(t *testing.T) {
+		r, w, out := createReaderAndWriter("a,b c\nd,e f\ng,h i\nj,k l\n")
+
+		err := process(r, w, config(2, 0), anons, processOptions{})
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "a,b\ng,h\n", out.String(), "should process some rows")
+	})
+	t.Run("when all the rows are valid", func(t *testing.T) {
+		r, w, out := createReaderAndWriter("a,b c\nd,e f\n")
+
+		err := process(r, w, config(1, 0), anons, processOptions{})
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "a,b\nd,e\n", out.String(), "should process all rows")
+	})
+	t.Run("when the csv has a header", func(t *testing.T) {
+		headerConfig := func(idColumnName string, actions ...ActionConfig) *Config {
+			return &Config{
+				Csv:      CsvConfig{Header: true},
+				Sampling: SamplingConfig{Mod: 2, IDColumnName: idColumnName},
+				Actions:  actions,
+			}
+		}
+		actions := []ActionConfig{ActionConfig{Name: "outcode", Column: "postcode"}, ActionConfig{Name: "nothing", Column: "id"}}
+		t.Run("and the columns are found", func(t *testing.T) {
+			r, w, out := createReaderAndWriter("postcode,id\nb c,a\ne f,b\nh i,g\n")
+
+			err := process(r, w, headerConfig("id", actions...), &[]Anonymisation{outcode, identity}, processOptions{})
+			assert.NoError(t, err, "should return no error")
+			assert.Equal(t, "postcode,id\nb,a\nh,g\n", out.String(), "should apply the actions by column name and write the header")
+		})
+		t.Run("and an action column is missing", func(t *testing.T) {
+			r, w, out := createReaderAndWriter("id,other\na,b c\n")
+
+			err := process(r, w, headerConfig("id", actions...), &[]Anonymisation{outcode, identity}, processOptions{})
+			assert.Error(t, err, "should return an error")
+			assert.Equal(t, "", out.String(), "shouldn't write any output")
+		})
+		t.Run("and the id column is missing", func(t *testing.T) {
+			r, w, out := createReaderAndWriter("postcode,id\nb c,a\n")
+
+			err := process(r, w, headerConfig("other", actions...), &[]Anonymisation{outcode, identity}, processOptions{})
+			assert.Error(t, err, "should return an error")
+			assert.Equal(t, "", out.String(), "shouldn't write any output")
+		})
+		t.Run("and the columns are renamed", func(t *testing.T) {
+			r, w, out := createReaderAndWriter("postcode,id\nb c,a\n")
+			conf := headerConfig("id", actions...)
+			conf.Csv.Rename = map[string]string{"postcode": "outcode"}
+
+			err := process(r, w, conf, &[]Anonymisation{outcode, identity}, processOptions{})
+			assert.NoError(t, err, "should return no error")
+			assert.Equal(t, "outcode,id\nb,a\n", out.String(), "should write the header with the new names")
+		})
+		t.Run("and a renamed column is missing", func(t *testing.T) {
+			r, w, _ := createReaderAndWriter("postcode,id\nb c,a\n")
+			conf := headerConfig("id", actions...)
+			conf.Csv.Rename = map[string]string{"other": "outcode"}
+
+			err := process(r, w, conf, &[]Anonymisation{outcode, identity}, processOptions{})
+			assert.Error(t, err, "should return an error")
+		})
+		t.Run("and the renamed header has duplicated names", func(t *testing.T) {
+			r, w, _ := createReaderAndWriter("postcode,id\nb c,a\n")
+			conf := headerConfig("id", actions...)
+			conf.Csv.Rename = map[string]string{"postcode": "id"}
+
+			err := process(r, w, conf, &[]Anonymisation{outcode, identity}, processOptions{})
+			assert.Error(t, err, "should return an error")
+		})
+		t.Run("and the header is dropped", func(t *testing.T) {
+			r, w, out := createReaderAndWriter("postcode,id\nb c,a\n")
+			conf := headerConfig("id", actions...)
+			conf.Csv.DropHeader = true
+
+			err := process(r, w, conf, &[]Anonymisation{outcode, identity}, processOptions{})
+			assert.NoError(t, err, "should return no error")
+			assert.Equal(t, "b,a\n", out.String(), "should not write the header")
+		})
+		t.Run("and a column name is duplicated", func(t *testing.T) {
+			r, w, out := createReaderAndWriter("id,id\na,b\n")
+
+			err := process(r, w, headerConfig("id"), &[]Anonymisation{}, processOptions{})
+			assert.Error(t, err, "should return an error")
+			assert.Equal(t, "", out.String(), "shouldn't write any output")
+		})
+	})
+}
+
+func TestProcessDetectHeader(t *testing.T) {
+	conf := &Config{Csv: CsvConfig{DetectHeader: true}, Sampling: SamplingConfig{Mod: 1}, Actions: []ActionConfig{ActionConfig{Name: "year"}}}
+	y, _ := year("20060102")
+	t.Run("when the csv has a header", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{Csv: CsvConfig{DetectHeader: true}, Actions: []ActionConfig{ActionConfig{Name: "year", Column: "date"}}}
+		st := NewStats()
+		err := process(csv.NewReader(strings.NewReader("date,id\n20020202,1\n20030303,2\n")), csv.NewWriter(&out), conf, &[]Anonymisation{y}, processOptions{stats: st})
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "date,id\n2002,1\n2003,2\n", out.String(), "should write the header unchanged")
+		assert.Equal(t, int64(2), st.Read, "should not process the header as a record")
+	})
+	t.Run("when the csv doesn't have a header", func(t *testing.T) {
+		var out bytes.Buffer
+		err := process(csv.NewReader(strings.NewReader("20020202,1\n20030303,2\n")), csv.NewWriter(&out), conf, &[]Anonymisation{y}, processOptions{})
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "2002,1\n2003,2\n", out.String(), "should process the first row")
+	})
+	t.Run("when the actions reference columns and there isn't a header", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{Csv: CsvConfig{DetectHeader: true}, Actions: []ActionConfig{ActionConfig{Name: "year", Column: "date"}}}
+		err := process(csv.NewReader(strings.NewReader("20020202,1\n")), csv.NewWriter(&out), conf, &[]Anonymisation{y}, processOptions{})
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestReveal(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", sivKey)
+	defer os.Unsetenv("ANON_TEST_KEY")
+	enc, _ := encrypt(EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}})
+	dec, _ := decrypt(EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}})
+	a, _ := enc("a")
+	d, _ := enc("d")
+	t.Run("with a config", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{Actions: []ActionConfig{ActionConfig{Name: "encrypt", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}}}}
+		err := Reveal(conf, strings.NewReader(a+",b\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "a,b\n", out.String(), "should decrypt the encrypted columns")
+	})
+	t.Run("with renamed columns", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{
+			Csv:     CsvConfig{Header: true, Rename: map[string]string{"email": "email_encrypted"}},
+			Actions: []ActionConfig{ActionConfig{Name: "encrypt", Column: "email", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}}},
+		}
+		err := Reveal(conf, strings.NewReader("email_encrypted,id\n"+a+",b\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "email_encrypted,id\na,b\n", out.String(), "should resolve the columns by their new names")
+	})
+	t.Run("when all the values can be decrypted", func(t *testing.T) {
+		var out bytes.Buffer
+		r := csv.NewReader(strings.NewReader(a + ",b\n" + d + ",e\n"))
+		w := csv.NewWriter(&out)
+
+		err := reveal(&csvReader{r: r}, &csvWriter{w}, []Anonymisation{dec, identity})
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "a,b\nd,e\n", out.String(), "should decrypt the encrypted columns")
+	})
+	t.Run("when a value can't be decrypted", func(t *testing.T) {
+		var out bytes.Buffer
+		r := csv.NewReader(strings.NewReader(a + ",b\n" + "ZmFrZQ,e\n"))
+		w := csv.NewWriter(&out)
+
+		err := reveal(&csvReader{r: r}, &csvWriter{w}, []Anonymisation{dec, identity})
+		assert.Error(t, err, "should return an error")
+	})
+}
@@ -1,11 +1,20 @@
-package main
+package anon
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -14,9 +23,62 @@ import (
 // Anonymisation is a function that transforms a string into another one
 type Anonymisation func(string) (string, error)
 
+// abortError wraps the error of an anonymisation whose
+// error policy is to fail the whole process
+type abortError struct {
+	err error
+}
+
+func (e abortError) Error() string {
+	return e.err.Error()
+}
+
+func (e abortError) Unwrap() error {
+	return e.err
+}
+
+// handledError wraps the error of an anonymisation that has been handled
+// by its error policy. It's returned with the value the policy replaced
+// the input with, so the record is still written but the error is counted.
+type handledError struct {
+	err error
+	// If true, the value has been left unchanged
+	passThrough bool
+}
+
+func (e handledError) Error() string {
+	return e.err.Error()
+}
+
+func (e handledError) Unwrap() error {
+	return e.err
+}
+
+// Returns the anonymisation without the errors handled by the
+// error policies, returning the values they replaced the input with
+func withoutHandled(anon Anonymisation) Anonymisation {
+	return func(s string) (string, error) {
+		res, err := anon(s)
+		if errors.As(err, &handledError{}) {
+			return res, nil
+		}
+		return res, err
+	}
+}
+
 // DateConfig stores the format (layout) of an input date
+// and the period it's generalised to
 type DateConfig struct {
 	Format string
+	// Other formats the input dates can have,
+	// tried in order after Format
+	Formats []string
+	// Period the dates are generalised to: day (default),
+	// week (ISO week), month, quarter, year or decade
+	Granularity string
+	// Layout of the output, used to format the first day of the
+	// period. If not set, it depends on the granularity
+	Output string
 }
 
 // RangeConfig stores configuration to define a range of values
@@ -28,20 +90,331 @@ type RangeConfig struct {
 	Output *string
 }
 
+// BinConfig stores the configuration of the bin action, that generalises
+// numbers into bins. The values below Bottom or at or above Top are coded
+// with a label, and the rest are put into bins of the width of Step or
+// into Quantiles bins computed from the data, or left unchanged.
+type BinConfig struct {
+	// Values below Bottom are written as BottomOutput, <Bottom by default
+	Bottom       *float64
+	BottomOutput string
+	// Values at or above Top are written as TopOutput, Top+ by default
+	Top       *float64
+	TopOutput string
+	// Width of the bins, starting at Origin (0 by default)
+	Step   float64
+	Origin float64
+	// Number of bins with (about) the same number of values. Their
+	// boundaries are computed in a first pass over the input.
+	Quantiles int
+}
+
+// NoiseConfig stores the configuration of the noise action, that adds
+// random noise to numbers calibrated for differential privacy
+type NoiseConfig struct {
+	// laplace (default) or gaussian
+	Mechanism string
+	// Privacy parameters of each value. Delta is only used
+	// (and required) by the gaussian mechanism
+	Epsilon float64
+	Delta   float64
+	// Maximum difference a single record can make to the value
+	Sensitivity float64
+	// Optional bounds the values are clamped to,
+	// before and after adding the noise
+	Min *float64
+	Max *float64
+	// Number of decimals the values (and the noise) are rounded to. If
+	// not set, they're rounded to about a hundredth of the sensitivity
+	Decimals *int
+}
+
+// KeyConfig stores where to read a secret key from, so
+// it doesn't need to be stored in the config.
+type KeyConfig struct {
+	// Path of the file containing the key. A trailing
+	// newline in the file is not part of the key
+	KeyFile string
+	// Name of the environment variable containing the key
+	KeyEnv string
+}
+
+// HmacConfig stores the configuration of a keyed hash (HMAC).
+type HmacConfig struct {
+	KeyConfig
+	// sha256 (default) or sha512
+	Algorithm string
+	// hex (default) or base64url
+	Encoding string
+	// If greater than 0, the output is truncated to this number of characters
+	Length int
+}
+
+// FpeConfig stores the configuration of a format-preserving
+// encryption (FF1). The key must be hex encoded and 16, 24
+// or 32 bytes long.
+type FpeConfig struct {
+	KeyConfig
+	// Characters that the input can contain, defaults to the digits
+	Alphabet string
+	// Optional tweak used in the encryption
+	Tweak string
+	// If true, decrypts instead of encrypting
+	Decrypt bool
+}
+
+// EncryptConfig stores the configuration of a deterministic
+// authenticated encryption (AES-SIV). The key must be hex
+// encoded and 32, 48 or 64 bytes long.
+type EncryptConfig struct {
+	KeyConfig
+}
+
+// DateShiftConfig stores the configuration of the dateShift action.
+// The format of the dates is the one in DateConfig.
+type DateShiftConfig struct {
+	// Secret the offset of each subject is derived from
+	KeyConfig
+	// The dates are shifted backward or forward by a
+	// number of days between MinDays and MaxDays
+	MinDays int
+	MaxDays int
+}
+
+// AgeConfig stores the configuration of the age action. The format of
+// the dates of birth is the one in DateConfig, and the ages can be
+// summarised with the ranges in RangeConfig.
+type AgeConfig struct {
+	// Date the age is computed at, with the format 2006-01-02
+	ReferenceDate string
+	// Name of the column with the date the age is computed at,
+	// with the same format as the date of birth
+	ReferenceColumn string
+}
+
 // ActionConfig stores the config of an anonymisation action
 type ActionConfig struct {
-	Name        string
-	Salt        *string
-	DateConfig  DateConfig
-	RangeConfig []RangeConfig
+	Name string
+	// Name of the column the action applies to, only
+	// used when the csv has a header
+	Column string
+	// What to do when the action fails: dropRow (default), fail,
+	// null, replace:<constant> or passThrough
+	OnError       string
+	Salt          *string
+	DateConfig    DateConfig
+	RangeConfig   []RangeConfig
+	HmacConfig    HmacConfig
+	FpeConfig     FpeConfig
+	EncryptConfig EncryptConfig
+	// Config of the dateShift action
+	DateShiftConfig DateShiftConfig
+	// Config of the age action
+	AgeConfig AgeConfig
+	// Config of the bin action
+	BinConfig BinConfig
+	// Config of the noise action
+	NoiseConfig NoiseConfig
+	// Config of the actions registered with RegisterAction,
+	// it can be decoded with DecodeConfig
+	Config json.RawMessage
+	// Actions applied in order by the pipeline action
+	Pipeline []ActionConfig
+	// If true, the column (once anonymised) is a quasi-identifier
+	// the k-anonymity of the records is enforced on
+	QuasiIdentifier bool
+	// Free text about the action, e.g. the annotations
+	// of anon scan. It's ignored when anonymising
+	Comment string
+}
+
+// Anonymisations returns the anonymisation of each action
+// config, applying its error policy.
+// The actions that depend on the context of the records (e.g. dateShift)
+// return an error, they can only be applied with a Processor.
+func Anonymisations(configs []ActionConfig) ([]Anonymisation, error) {
+	var err error
+	res := make([]Anonymisation, len(configs))
+	for i, config := range configs {
+		if res[i], err = config.anonymisation(config.Name); err != nil {
+			return nil, err
+		}
+		res[i] = withoutHandled(res[i])
+	}
+	return res, nil
+}
+
+// Returns the anonymisation of each action config, applying its error
+// policy. The errors handled by the policies are returned as handledError.
+// The actions that depend on the context of the records are created for
+// each record instead (see contextAnonymisations), so they fail if they
+// are applied without it.
+func anonymisations(configs []ActionConfig) ([]Anonymisation, error) {
+	var err error
+	res := make([]Anonymisation, len(configs))
+	for i, config := range configs {
+		if config.byContext() {
+			res[i] = withoutContext(config.Name)
+			continue
+		}
+		if res[i], err = config.anonymisation(config.Name); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Creates the anonymisation of the action, applying its error
+// policy and attributing its errors to the action with that name
+func (ac *ActionConfig) anonymisation(name string) (Anonymisation, error) {
+	anon, err := ac.create()
+	if err != nil {
+		return nil, err
+	}
+	return ac.withErrorPolicy(named(name, anon))
+}
+
+// Wraps the errors of the anonymisation in an actionError so they can
+// be attributed to the action. Errors already attributed to an action
+// (i.e. to a step of a pipeline) are returned unchanged.
+func named(action string, anon Anonymisation) Anonymisation {
+	return func(s string) (string, error) {
+		res, err := anon(s)
+		if err != nil && !errors.As(err, &actionError{}) {
+			return res, actionError{action, err}
+		}
+		return res, err
+	}
+}
+
+// Returns an anonymisation that applies the actions in order. The errors
+// are attributed to the step that failed by its position and name (e.g.
+// pipeline[1].hash), and each step can have its own error policy.
+func pipeline(configs []ActionConfig) (Anonymisation, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("a pipeline needs at least one action")
+	}
+	anons := make([]Anonymisation, len(configs))
+	for i, config := range configs {
+		if config.Column != "" {
+			return nil, fmt.Errorf("step %d of the pipeline (%s) can't have a column, it applies to the column of the pipeline", i, config.Name)
+		}
+		var err error
+		if anons[i], err = config.anonymisation(fmt.Sprintf("pipeline[%d].%s", i, config.Name)); err != nil {
+			return nil, fmt.Errorf("step %d of the pipeline: %v", i, err)
+		}
+	}
+	return chain(anons), nil
+}
+
+// Returns the reversal of a pipeline, that reverses its
+// encrypted steps in the opposite order they were applied
+func pipelineReversal(configs []ActionConfig) (Anonymisation, error) {
+	revs, err := Reversals(configs)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(revs)/2; i++ {
+		revs[i], revs[len(revs)-1-i] = revs[len(revs)-1-i], revs[i]
+	}
+	return chain(revs), nil
 }
 
-// Returns an array of anonymisations according to the config
-func anonymisations(configs *[]ActionConfig) ([]Anonymisation, error) {
+// Wraps the anonymisation so its errors are handled according
+// to the configured policy:
+//   - dropRow: the error is returned, so the row is skipped
+//   - fail: the error is returned as an abortError, so the whole process fails
+//   - null: the value is replaced with an empty value
+//   - replace:<constant>: the value is replaced with the constant
+//   - passThrough: the value is left unchanged (it may leak sensitive data),
+//     every value passed through is logged without the value
+//
+// The errors replaced by the policies are returned as handledError, so they
+// can be counted. The errors already handled (i.e. by the policy of a step
+// of a pipeline) are returned unchanged.
+func (ac *ActionConfig) withErrorPolicy(anon Anonymisation) (Anonymisation, error) {
+	switch {
+	case ac.OnError == "" || ac.OnError == "dropRow":
+		return anon, nil
+	case ac.OnError == "fail":
+		return func(s string) (string, error) {
+			res, err := anon(s)
+			if err != nil && !errors.As(err, &handledError{}) {
+				return res, abortError{err}
+			}
+			return res, err
+		}, nil
+	case ac.OnError == "null":
+		return replaceOnError(anon, false, func(string) string { return "" }), nil
+	case strings.HasPrefix(ac.OnError, "replace:"):
+		constant := strings.TrimPrefix(ac.OnError, "replace:")
+		return replaceOnError(anon, false, func(string) string { return constant }), nil
+	case ac.OnError == "passThrough":
+		return replaceOnError(anon, true, func(s string) string { return s }), nil
+	}
+	return nil, fmt.Errorf("unknown error policy %s", ac.OnError)
+}
+
+// Returns an anonymisation that, if the original one fails, returns
+// the result of calling replace with the input and the error handled.
+// If passThrough is true, replace returns the input unchanged.
+func replaceOnError(anon Anonymisation, passThrough bool, replace func(string) string) Anonymisation {
+	return func(s string) (string, error) {
+		res, err := anon(s)
+		if errors.As(err, &handledError{}) {
+			return res, err
+		} else if err != nil {
+			return replace(s), handledError{err, passThrough}
+		}
+		return res, nil
+	}
+}
+
+// Given the actions config, their anonymisations and the index of each
+// column in the header, returns the anonymisations sorted by the position
+// of their column. Columns without an action are left unchanged.
+func byColumn(configs []ActionConfig, anons []Anonymisation, columns map[string]int) ([]Anonymisation, error) {
+	res := make([]Anonymisation, len(columns))
+	for i := range res {
+		res[i] = identity
+	}
+	seen := make(map[string]bool, len(configs))
+	for i, config := range configs {
+		if config.Column == "" {
+			return nil, fmt.Errorf("action %d (%s) needs a column name when the csv has a header", i, config.Name)
+		}
+		if seen[config.Column] {
+			return nil, fmt.Errorf("column %s has more than one action defined", config.Column)
+		}
+		seen[config.Column] = true
+		j, ok := columns[config.Column]
+		if !ok {
+			return nil, fmt.Errorf("column %s not found in the header", config.Column)
+		}
+		res[j] = anons[i]
+	}
+	return res, nil
+}
+
+// Reversals returns the anonymisations that reverse the encrypted columns
+// (the ones with an encrypt or fpe action, also as a step of a pipeline),
+// leaving the rest unchanged.
+func Reversals(configs []ActionConfig) ([]Anonymisation, error) {
 	var err error
-	res := make([]Anonymisation, len(*configs))
-	for i, config := range *configs {
-		if res[i], err = config.create(); err != nil {
+	res := make([]Anonymisation, len(configs))
+	for i, config := range configs {
+		switch config.Name {
+		case "encrypt":
+			res[i], err = decrypt(config.EncryptConfig)
+		case "fpe":
+			config.FpeConfig.Decrypt = !config.FpeConfig.Decrypt
+			res[i], err = fpe(config.FpeConfig)
+		case "pipeline":
+			res[i], err = pipelineReversal(config.Pipeline)
+		default:
+			res[i] = identity
+		}
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -57,20 +430,80 @@ func (ac *ActionConfig) saltOrRandom() string {
 	return strconv.Itoa(rand.Int())
 }
 
+// Create returns the anonymisation defined by the action config,
+// using the factory registered with its name
+func (ac *ActionConfig) Create() (Anonymisation, error) {
+	anon, err := ac.create()
+	if err != nil {
+		return nil, err
+	}
+	return withoutHandled(anon), nil
+}
+
+// Creates the anonymisation of the action, returning the errors
+// handled by the policies of its steps (if any) as handledError
 func (ac *ActionConfig) create() (Anonymisation, error) {
+	factory, ok := lookupAction(ac.Name)
+	if !ok {
+		return nil, fmt.Errorf("can't create an action with name %s", ac.Name)
+	}
+	return factory(ac)
+}
+
+// valueKind is the kind of the values written by an action, so the formats
+// with typed values (e.g. JSON Lines) write all the values of a column
+// with the same type, whatever the values are
+type valueKind int
+
+const (
+	// Any string, e.g. a hash or the output of a range
+	kindString valueKind = iota
+	// The same type as the input, e.g. a number for a number
+	kindInput
+	// An integer, e.g. a year
+	kindInteger
+	// A number with decimals
+	kindNumber
+)
+
+// Returns the kind of the values written by the action. The actions
+// registered with RegisterAction are assumed to write any string.
+func (ac *ActionConfig) outputKind() valueKind {
 	switch ac.Name {
-	case "nothing":
-		return identity, nil
-	case "outcode":
-		return outcode, nil
-	case "hash":
-		return hash(ac.saltOrRandom()), nil
+	case "nothing", "dateShift":
+		return kindInput
 	case "year":
-		return year(ac.DateConfig.Format)
-	case "ranges":
-		return ranges(ac.RangeConfig)
+		return kindInteger
+	case "date":
+		if ac.DateConfig.Output == "" && ac.DateConfig.Granularity == "year" {
+			return kindInteger
+		}
+	case "age":
+		if len(ac.RangeConfig) == 0 {
+			return kindInteger
+		}
+	case "noise":
+		return kindNumber
+	case "pipeline":
+		return chainKind(ac.Pipeline)
 	}
-	return nil, fmt.Errorf("can't create an action with name %s", ac.Name)
+	return kindString
+}
+
+// Returns the kind of the values written by the actions applied in order
+func chainKind(configs []ActionConfig) valueKind {
+	kind := kindInput
+	for _, config := range configs {
+		if k := config.outputKind(); k != kindInput {
+			kind = k
+		}
+	}
+	return kind
+}
+
+// Returns if the kind of values is a number when the input is a number
+func (k valueKind) keepsNumbers() bool {
+	return k != kindString
 }
 
 // The no-op, returns the input unchanged.
@@ -88,6 +521,143 @@ func hash(salt string) Anonymisation {
 	}
 }
 
+// Returns the key read either from the configured
+// file or environment variable.
+func (kc *KeyConfig) key() ([]byte, error) {
+	var key []byte
+	switch {
+	case kc.KeyFile != "" && kc.KeyEnv != "":
+		return nil, errors.New("you can only specify one of keyFile and keyEnv")
+	case kc.KeyFile != "":
+		content, err := ioutil.ReadFile(kc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = bytes.TrimSuffix(bytes.TrimSuffix(content, []byte("\n")), []byte("\r"))
+	case kc.KeyEnv != "":
+		key = []byte(os.Getenv(kc.KeyEnv))
+	default:
+		return nil, errors.New("you need to specify one of keyFile and keyEnv")
+	}
+	if len(key) == 0 {
+		return nil, errors.New("the key can't be empty")
+	}
+	return key, nil
+}
+
+// Returns the hex decoded key
+func (kc *KeyConfig) hexKey() ([]byte, error) {
+	key, err := kc.key()
+	if err != nil {
+		return nil, err
+	}
+	if key, err = hex.DecodeString(string(key)); err != nil {
+		return nil, fmt.Errorf("the key must be hex encoded: %v", err)
+	}
+	return key, nil
+}
+
+// Hashes the input with an HMAC using the configured algorithm
+// (SHA256 or SHA512) and key, and encodes the result in hex or
+// base64url, optionally truncated.
+func hmacHash(conf HmacConfig) (Anonymisation, error) {
+	newHash := sha256.New
+	switch conf.Algorithm {
+	case "", "sha256":
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("unknown hmac algorithm %s", conf.Algorithm)
+	}
+	var encode func([]byte) string
+	switch conf.Encoding {
+	case "", "hex":
+		encode = hex.EncodeToString
+	case "base64url":
+		encode = base64.RawURLEncoding.EncodeToString
+	default:
+		return nil, fmt.Errorf("unknown hmac encoding %s", conf.Encoding)
+	}
+	if max := len(encode(make([]byte, newHash().Size()))); conf.Length < 0 || conf.Length > max {
+		return nil, fmt.Errorf("hmac length must be between 0 and %d", max)
+	}
+	key, err := conf.key()
+	if err != nil {
+		return nil, err
+	}
+	return func(s string) (string, error) {
+		h := hmac.New(newHash, key)
+		io.WriteString(h, s)
+		res := encode(h.Sum(nil))
+		if conf.Length > 0 {
+			res = res[:conf.Length]
+		}
+		return res, nil
+	}, nil
+}
+
+// Encrypts (or decrypts) the input with FF1, so the output has the
+// same length and alphabet as the input.
+func fpe(conf FpeConfig) (Anonymisation, error) {
+	key, err := conf.hexKey()
+	if err != nil {
+		return nil, err
+	}
+	alphabet := conf.Alphabet
+	if alphabet == "" {
+		alphabet = "0123456789"
+	}
+	f, err := newFF1(key, []byte(conf.Tweak), alphabet)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Decrypt {
+		return f.decrypt, nil
+	}
+	return f.encrypt, nil
+}
+
+// Encrypts the input with AES-SIV and encodes it in base64url.
+// The same input always produces the same output, so the
+// encrypted column can still be joined or grouped by.
+func encrypt(conf EncryptConfig) (Anonymisation, error) {
+	key, err := conf.hexKey()
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	return func(s string) (string, error) {
+		return base64.RawURLEncoding.EncodeToString(c.seal([]byte(s))), nil
+	}, nil
+}
+
+// Reverses encrypt, failing if the input wasn't
+// encrypted with the same key.
+func decrypt(conf EncryptConfig) (Anonymisation, error) {
+	key, err := conf.hexKey()
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	return func(s string) (string, error) {
+		ciphertext, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return s, fmt.Errorf("not an encrypted value: %v", err)
+		}
+		plaintext, err := c.open(ciphertext)
+		if err != nil {
+			return s, err
+		}
+		return string(plaintext), nil
+	}, nil
+}
+
 // Takes a UK format postcode (eg. W1W 8BE) and just keeps
 // the outcode (eg. W1W).
 // i.e. returns the prefix of the input until it finds a space
@@ -101,18 +671,117 @@ func outcode(s string) (string, error) {
 // match that format, it will return an error and
 // the input unchanged
 func year(format string) (Anonymisation, error) {
-	if _, err := time.Parse(format, format); err != nil {
+	return date(DateConfig{Format: format, Granularity: "year"})
+}
+
+// granularity is a period a date can be generalised to
+type granularity struct {
+	// Returns the first day of the period of the date
+	truncate func(t time.Time) time.Time
+	// Formats the first day of the period when there isn't an output layout
+	label func(t time.Time) string
+}
+
+var granularities = map[string]granularity{
+	"day": {
+		func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()) },
+		func(t time.Time) string { return t.Format("2006-01-02") },
+	},
+	"week": {
+		func(t time.Time) time.Time {
+			day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			return day.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+		},
+		func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%04d-W%02d", year, week)
+		},
+	},
+	"month": {
+		func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()) },
+		func(t time.Time) string { return t.Format("2006-01") },
+	},
+	"quarter": {
+		func(t time.Time) time.Time {
+			return time.Date(t.Year(), (t.Month()-1)/3*3+1, 1, 0, 0, 0, 0, t.Location())
+		},
+		func(t time.Time) string { return fmt.Sprintf("%04d-Q%d", t.Year(), (t.Month()-1)/3+1) },
+	},
+	"year": {
+		func(t time.Time) time.Time { return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location()) },
+		func(t time.Time) string { return strconv.Itoa(t.Year()) },
+	},
+	"decade": {
+		func(t time.Time) time.Time { return time.Date(t.Year()-t.Year()%10, 1, 1, 0, 0, 0, 0, t.Location()) },
+		func(t time.Time) string { return fmt.Sprintf("%ds", t.Year()) },
+	},
+}
+
+// Given the date config, it returns a function that given a date in any
+// of its formats, generalises it to the first day of the period of the
+// configured granularity (e.g. 2012-03-04 to 2012-Q1 by quarter). If the
+// date doesn't match any of the formats, it will return an error and the
+// input unchanged.
+func date(conf DateConfig) (Anonymisation, error) {
+	layouts := conf.layouts()
+	if err := checkLayouts(layouts); err != nil {
 		return nil, err
 	}
+	if conf.Granularity == "" {
+		conf.Granularity = "day"
+	}
+	g, ok := granularities[conf.Granularity]
+	if !ok {
+		return nil, fmt.Errorf("unknown granularity %s, it must be one of day, week, month, quarter, year or decade", conf.Granularity)
+	}
 	return func(s string) (string, error) {
-		t, err := time.Parse(format, s)
+		t, _, err := parseDate(s, layouts)
 		if err != nil {
 			return s, err
 		}
-		return strconv.Itoa(t.Year()), nil
+		t = g.truncate(t)
+		if conf.Output != "" {
+			return t.Format(conf.Output), nil
+		}
+		return g.label(t), nil
 	}, nil
 }
 
+// Returns the formats the dates can have
+func (dc *DateConfig) layouts() []string {
+	if dc.Format == "" {
+		return dc.Formats
+	}
+	return append([]string{dc.Format}, dc.Formats...)
+}
+
+// Parses the date with the first of the layouts that
+// matches it, returning the date and that layout
+func parseDate(s string, layouts []string) (time.Time, string, error) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, layout, nil
+		}
+	}
+	if len(layouts) == 1 {
+		return time.Time{}, "", fmt.Errorf("can't parse the date with the format %s", layouts[0])
+	}
+	return time.Time{}, "", fmt.Errorf("can't parse the date with any of the formats %s", strings.Join(layouts, ", "))
+}
+
+// Checks that there is at least one layout and all of them are valid
+func checkLayouts(layouts []string) error {
+	if len(layouts) == 0 {
+		return errors.New("you need to specify the format of the dates")
+	}
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, layout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Given a list of ranges, it will summarise numeric
 // values into groups of values, each group defined
 // by a range and an output
@@ -129,7 +798,7 @@ func ranges(ranges []RangeConfig) (Anonymisation, error) {
 	return func(s string) (string, error) {
 		v, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return s, err
+			return s, errors.New("value is not a number")
 		}
 		for _, rang := range ranges {
 			if rang.contains(v) {
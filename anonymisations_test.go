@@ -1,8 +1,12 @@
-package main
+package anon
 
 import (
+	"errors"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/leanovate/gopter"
 	"github.com/leanovate/gopter/gen"
@@ -13,6 +17,8 @@ import (
 
 var salt = "jump"
 
+const sivKey = "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"
+
 const seed = int64(1)
 
 //this is the first random salt with the seed above
@@ -32,7 +38,7 @@ func assertAnonymisationFunction(t *testing.T, expected Anonymisation, actual An
 
 func TestAnonymisations(t *testing.T) {
 	t.Run("a valid configuration", func(t *testing.T) {
-		conf := &[]ActionConfig{
+		conf := []ActionConfig{
 			ActionConfig{
 				Name: "nothing",
 			},
@@ -41,19 +47,181 @@ func TestAnonymisations(t *testing.T) {
 				Salt: &salt,
 			},
 		}
-		anons, err := anonymisations(conf)
+		anons, err := Anonymisations(conf)
 		assert.NoError(t, err)
 		assertAnonymisationFunction(t, identity, anons[0], "a")
 		assertAnonymisationFunction(t, hash(salt), anons[1], "a")
 	})
-	t.Run("an invalid configuration", func(t *testing.T) {
-		conf := &[]ActionConfig{ActionConfig{Name: "year", DateConfig: DateConfig{Format: "3333"}}}
+	t.Run("with an error policy", func(t *testing.T) {
+		conf := []ActionConfig{ActionConfig{Name: "year", DateConfig: DateConfig{Format: "20060102"}, OnError: "null"}}
+		anons, err := Anonymisations(conf)
+		require.NoError(t, err)
+		res, err := anons[0]("a")
+		assert.NoError(t, err, "shouldn't return the errors handled by the policy")
+		assert.Equal(t, "", res)
+	})
+	t.Run("with an action that depends on the context", func(t *testing.T) {
+		conf := []ActionConfig{ActionConfig{Name: "nothing"}, ActionConfig{Name: "bin", BinConfig: BinConfig{Quantiles: 2}}}
+		_, err := Anonymisations(conf)
+		assert.EqualError(t, err, "bin needs a row context, use Processor", "should return an error")
 		anons, err := anonymisations(conf)
+		require.NoError(t, err, "should leave it to the Processor")
+		_, err = anons[1]("1")
+		assert.True(t, errors.As(err, &abortError{}), "should abort the process if it's applied without the context")
+	})
+	t.Run("an invalid configuration", func(t *testing.T) {
+		conf := []ActionConfig{ActionConfig{Name: "year", DateConfig: DateConfig{Format: "3333"}}}
+		anons, err := Anonymisations(conf)
 		assert.Error(t, err, "should return an error")
 		assert.Nil(t, anons)
 	})
 }
 
+func TestPipeline(t *testing.T) {
+	t.Run("with valid steps", func(t *testing.T) {
+		anon, err := pipeline([]ActionConfig{ActionConfig{Name: "outcode"}, ActionConfig{Name: "hash", Salt: &salt}})
+		require.NoError(t, err)
+		expected, _ := hash(salt)("a1")
+		assertAnonymisationFunction(t, func(string) (string, error) { return expected, nil }, anon, "a1 b2")
+	})
+	t.Run("when a step fails", func(t *testing.T) {
+		anon, err := pipeline([]ActionConfig{ActionConfig{Name: "outcode"}, ActionConfig{Name: "year", DateConfig: DateConfig{Format: "20060102"}}})
+		require.NoError(t, err)
+		_, err = anon("a1 b2")
+		var ae actionError
+		require.True(t, errors.As(err, &ae), "should return an actionError")
+		assert.Equal(t, "pipeline[1].year", ae.action, "should attribute the error to the step")
+	})
+	t.Run("when a step has an error policy", func(t *testing.T) {
+		anon, err := pipeline([]ActionConfig{ActionConfig{Name: "year", DateConfig: DateConfig{Format: "20060102"}, OnError: "replace:unknown"}, ActionConfig{Name: "outcode"}})
+		require.NoError(t, err)
+		res, err := anon("fail")
+		var ae actionError
+		assert.True(t, errors.As(err, &handledError{}), "should return the error as handled")
+		require.True(t, errors.As(err, &ae), "should return an actionError")
+		assert.Equal(t, "pipeline[0].year", ae.action, "should attribute the error to the step")
+		assert.Equal(t, "unknown", res, "should apply the policy of the step")
+	})
+	t.Run("when it's nested", func(t *testing.T) {
+		conf := ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{
+			ActionConfig{Name: "nothing"},
+			ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{ActionConfig{Name: "ranges", RangeConfig: []RangeConfig{}}}},
+		}}
+		anons, err := Anonymisations([]ActionConfig{conf})
+		require.NoError(t, err)
+		_, err = anons[0]("a")
+		var ae actionError
+		require.True(t, errors.As(err, &ae), "should return an actionError")
+		assert.Equal(t, "pipeline[0].ranges", ae.action, "should attribute the error to the innermost step")
+	})
+	t.Run("with invalid steps", func(t *testing.T) {
+		for _, configs := range [][]ActionConfig{
+			{},
+			{ActionConfig{Name: "invalid"}},
+			{ActionConfig{Name: "hash", Column: "email"}},
+		} {
+			_, err := pipeline(configs)
+			assert.Error(t, err, "should return an error for %v", configs)
+		}
+	})
+}
+
+func TestActionConfigWithErrorPolicy(t *testing.T) {
+	failing := func(s string) (string, error) { return s, errors.New("failed") }
+	withPolicy := func(policy string) Anonymisation {
+		ac := ActionConfig{Name: "year", OnError: policy}
+		res, err := ac.withErrorPolicy(failing)
+		require.NoError(t, err)
+		return res
+	}
+	t.Run("dropRow", func(t *testing.T) {
+		res, err := withPolicy("dropRow")("a")
+		assert.Error(t, err, "should return the error")
+		assert.Equal(t, "a", res)
+		_, isAbort := err.(abortError)
+		assert.False(t, isAbort, "shouldn't abort the process")
+	})
+	t.Run("default", func(t *testing.T) {
+		assertAnonymisationFunction(t, withPolicy("dropRow"), withPolicy(""), "a")
+	})
+	t.Run("fail", func(t *testing.T) {
+		_, err := withPolicy("fail")("a")
+		assert.Equal(t, abortError{errors.New("failed")}, err, "should abort the process")
+	})
+	t.Run("null", func(t *testing.T) {
+		res, err := withPolicy("null")("a")
+		assert.Equal(t, handledError{err: errors.New("failed")}, err, "should return the error as handled")
+		assert.Equal(t, "", res, "should return an empty value")
+	})
+	t.Run("replace", func(t *testing.T) {
+		res, err := withPolicy("replace:unknown")("a")
+		assert.Equal(t, handledError{err: errors.New("failed")}, err, "should return the error as handled")
+		assert.Equal(t, "unknown", res, "should return the constant")
+	})
+	t.Run("passThrough", func(t *testing.T) {
+		res, err := withPolicy("passThrough")("a")
+		assert.Equal(t, handledError{errors.New("failed"), true}, err, "should return the error as passed through")
+		assert.Equal(t, "a", res, "should return the input unchanged")
+	})
+	t.Run("with an error already handled", func(t *testing.T) {
+		handled := func(s string) (string, error) { return "", handledError{err: errors.New("failed")} }
+		for _, policy := range []string{"dropRow", "fail", "null", "replace:unknown", "passThrough"} {
+			ac := ActionConfig{Name: "pipeline", OnError: policy}
+			anon, err := ac.withErrorPolicy(handled)
+			require.NoError(t, err)
+			res, err := anon("a")
+			assert.Equal(t, handledError{err: errors.New("failed")}, err, "should return it unchanged with %s", policy)
+			assert.Equal(t, "", res)
+		}
+	})
+	t.Run("when the anonymisation doesn't fail", func(t *testing.T) {
+		for _, policy := range []string{"dropRow", "fail", "null", "replace:unknown", "passThrough"} {
+			ac := ActionConfig{Name: "outcode", OnError: policy}
+			res, err := ac.withErrorPolicy(outcode)
+			require.NoError(t, err)
+			assertAnonymisationFunction(t, outcode, res, "a b")
+		}
+	})
+	t.Run("unknown policy", func(t *testing.T) {
+		ac := ActionConfig{Name: "year", OnError: "ignore"}
+		res, err := ac.withErrorPolicy(failing)
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, res)
+	})
+}
+
+func TestByColumn(t *testing.T) {
+	columns := map[string]int{"a": 0, "b": 1, "c": 2}
+	anons := []Anonymisation{hash(salt), outcode}
+	t.Run("with all the columns in the header", func(t *testing.T) {
+		conf := []ActionConfig{ActionConfig{Name: "hash", Column: "c"}, ActionConfig{Name: "outcode", Column: "a"}}
+		res, err := byColumn(conf, anons, columns)
+		require.NoError(t, err)
+		require.Len(t, res, 3, "should return an anonymisation per column")
+		assertAnonymisationFunction(t, outcode, res[0], "a b")
+		assertAnonymisationFunction(t, identity, res[1], "a b")
+		assertAnonymisationFunction(t, hash(salt), res[2], "a b")
+	})
+	t.Run("with a column not in the header", func(t *testing.T) {
+		conf := []ActionConfig{ActionConfig{Name: "hash", Column: "d"}, ActionConfig{Name: "outcode", Column: "a"}}
+		res, err := byColumn(conf, anons, columns)
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, res)
+	})
+	t.Run("with a column without name", func(t *testing.T) {
+		conf := []ActionConfig{ActionConfig{Name: "hash"}, ActionConfig{Name: "outcode", Column: "a"}}
+		res, err := byColumn(conf, anons, columns)
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, res)
+	})
+	t.Run("with a column with more than one action", func(t *testing.T) {
+		conf := []ActionConfig{ActionConfig{Name: "hash", Column: "a"}, ActionConfig{Name: "outcode", Column: "a"}}
+		res, err := byColumn(conf, anons, columns)
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, res)
+	})
+}
+
 func TestActionConfigSaltOrRandom(t *testing.T) {
 	t.Run("if salt is not specified", func(t *testing.T) {
 		rand.Seed(seed)
@@ -70,22 +238,43 @@ func TestActionConfigSaltOrRandom(t *testing.T) {
 	})
 }
 
+func TestActionConfigOutputKind(t *testing.T) {
+	for _, test := range []struct {
+		ac   ActionConfig
+		kind valueKind
+	}{
+		{ActionConfig{Name: "nothing"}, kindInput},
+		{ActionConfig{Name: "hash"}, kindString},
+		{ActionConfig{Name: "year"}, kindInteger},
+		{ActionConfig{Name: "date", DateConfig: DateConfig{Granularity: "year"}}, kindInteger},
+		{ActionConfig{Name: "date", DateConfig: DateConfig{Granularity: "month"}}, kindString},
+		{ActionConfig{Name: "age"}, kindInteger},
+		{ActionConfig{Name: "age", RangeConfig: []RangeConfig{RangeConfig{}}}, kindString},
+		{ActionConfig{Name: "noise"}, kindNumber},
+		{ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{ActionConfig{Name: "noise"}, ActionConfig{Name: "nothing"}}}, kindNumber},
+		{ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{ActionConfig{Name: "year"}, ActionConfig{Name: "ranges"}}}, kindString},
+		{ActionConfig{Name: "test-custom"}, kindString},
+	} {
+		assert.Equal(t, test.kind, test.ac.outputKind(), "should return the kind of the output of %v", test.ac)
+	}
+}
+
 func TestActionConfigCreate(t *testing.T) {
 	t.Run("invalid name", func(t *testing.T) {
 		ac := ActionConfig{Name: "invalid name"}
-		res, err := ac.create()
+		res, err := ac.Create()
 		assert.Error(t, err)
 		assert.Nil(t, res)
 	})
 	t.Run("identity", func(t *testing.T) {
 		ac := ActionConfig{Name: "nothing"}
-		res, err := ac.create()
+		res, err := ac.Create()
 		assert.NoError(t, err)
 		assertAnonymisationFunction(t, identity, res, "a")
 	})
 	t.Run("outcode", func(t *testing.T) {
 		ac := ActionConfig{Name: "outcode"}
-		res, err := ac.create()
+		res, err := ac.Create()
 		assert.NoError(t, err)
 		assertAnonymisationFunction(t, outcode, res, "a")
 	})
@@ -93,27 +282,93 @@ func TestActionConfigCreate(t *testing.T) {
 		t.Run("if salt is not specified uses a random salt", func(t *testing.T) {
 			rand.Seed(1)
 			ac := ActionConfig{Name: "hash"}
-			res, err := ac.create()
+			res, err := ac.Create()
 			assert.NoError(t, err)
 			assertAnonymisationFunction(t, hash(firstSalt), res, "a")
 		})
 		t.Run("if salt is specified uses it", func(t *testing.T) {
 			ac := ActionConfig{Name: "hash", Salt: &salt}
-			res, err := ac.create()
+			res, err := ac.Create()
 			assert.NoError(t, err)
 			assertAnonymisationFunction(t, hash(salt), res, "a")
 		})
 	})
+	t.Run("hmac", func(t *testing.T) {
+		t.Run("without a key", func(t *testing.T) {
+			ac := ActionConfig{Name: "hmac"}
+			res, err := ac.Create()
+			assert.Error(t, err, "should fail")
+			assert.Nil(t, res)
+		})
+		t.Run("with a key", func(t *testing.T) {
+			os.Setenv("ANON_TEST_KEY", "key")
+			defer os.Unsetenv("ANON_TEST_KEY")
+			ac := ActionConfig{Name: "hmac", HmacConfig: HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}}}
+			res, err := ac.Create()
+			assert.NoError(t, err, "should not fail")
+			expected, err := hmacHash(HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}})
+			assert.NoError(t, err)
+			assertAnonymisationFunction(t, expected, res, "a")
+		})
+	})
+	t.Run("fpe", func(t *testing.T) {
+		os.Setenv("ANON_TEST_KEY", "2B7E151628AED2A6ABF7158809CF4F3C")
+		defer os.Unsetenv("ANON_TEST_KEY")
+		t.Run("with a key that is not hex encoded", func(t *testing.T) {
+			os.Setenv("ANON_TEST_NOT_HEX_KEY", "not hex")
+			defer os.Unsetenv("ANON_TEST_NOT_HEX_KEY")
+			ac := ActionConfig{Name: "fpe", FpeConfig: FpeConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_NOT_HEX_KEY"}}}
+			res, err := ac.Create()
+			assert.Error(t, err, "should fail")
+			assert.Nil(t, res)
+		})
+		t.Run("with the default alphabet", func(t *testing.T) {
+			ac := ActionConfig{Name: "fpe", FpeConfig: FpeConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}}}
+			res, err := ac.Create()
+			require.NoError(t, err, "should not fail")
+			out, err := res("0123456789")
+			assert.NoError(t, err)
+			assert.Equal(t, "2433477484", out, "should encrypt digits")
+		})
+		t.Run("to decrypt", func(t *testing.T) {
+			ac := ActionConfig{Name: "fpe", FpeConfig: FpeConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, Decrypt: true}}
+			res, err := ac.Create()
+			require.NoError(t, err, "should not fail")
+			out, err := res("2433477484")
+			assert.NoError(t, err)
+			assert.Equal(t, "0123456789", out, "should decrypt the value")
+		})
+	})
+	t.Run("encrypt", func(t *testing.T) {
+		t.Run("with an invalid key size", func(t *testing.T) {
+			os.Setenv("ANON_TEST_KEY", "2B7E151628AED2A6ABF7158809CF4F3C")
+			defer os.Unsetenv("ANON_TEST_KEY")
+			ac := ActionConfig{Name: "encrypt", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}}
+			res, err := ac.Create()
+			assert.Error(t, err, "should fail")
+			assert.Nil(t, res)
+		})
+		t.Run("with a valid key", func(t *testing.T) {
+			os.Setenv("ANON_TEST_KEY", sivKey)
+			defer os.Unsetenv("ANON_TEST_KEY")
+			ac := ActionConfig{Name: "encrypt", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}}
+			res, err := ac.Create()
+			assert.NoError(t, err, "should not fail")
+			expected, err := encrypt(EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}})
+			assert.NoError(t, err)
+			assertAnonymisationFunction(t, expected, res, "a")
+		})
+	})
 	t.Run("year", func(t *testing.T) {
 		t.Run("with an invalid format", func(t *testing.T) {
 			ac := ActionConfig{Name: "year", DateConfig: DateConfig{Format: "11112233"}}
-			res, err := ac.create()
+			res, err := ac.Create()
 			assert.Error(t, err, "should fail")
 			assert.Nil(t, res)
 		})
 		t.Run("with a valid format", func(t *testing.T) {
 			ac := ActionConfig{Name: "year", DateConfig: DateConfig{Format: "20060102"}}
-			res, err := ac.create()
+			res, err := ac.Create()
 			assert.NoError(t, err, "should not fail")
 			y, err := year("20060102")
 			assert.NoError(t, err)
@@ -128,7 +383,7 @@ func TestActionConfigCreate(t *testing.T) {
 				Name:        "ranges",
 				RangeConfig: []RangeConfig{RangeConfig{Output: &output}},
 			}
-			r, err := ac.create()
+			r, err := ac.Create()
 			assert.Error(t, err, "if not should return an error")
 			assert.Nil(t, r)
 		})
@@ -137,7 +392,7 @@ func TestActionConfigCreate(t *testing.T) {
 				Name:        "ranges",
 				RangeConfig: []RangeConfig{RangeConfig{Lt: &num, Lte: &num, Output: &output}},
 			}
-			r, err := ac.create()
+			r, err := ac.Create()
 			assert.Error(t, err, "if not should return an error")
 			assert.Nil(t, r)
 		})
@@ -146,7 +401,7 @@ func TestActionConfigCreate(t *testing.T) {
 				Name:        "ranges",
 				RangeConfig: []RangeConfig{RangeConfig{Gt: &num, Gte: &num, Output: &output}},
 			}
-			r, err := ac.create()
+			r, err := ac.Create()
 			assert.Error(t, err, "if not should return an error")
 			assert.Nil(t, r)
 		})
@@ -155,7 +410,7 @@ func TestActionConfigCreate(t *testing.T) {
 				Name:        "ranges",
 				RangeConfig: []RangeConfig{RangeConfig{Lt: &num, Gte: &num}},
 			}
-			r, err := ac.create()
+			r, err := ac.Create()
 			assert.Error(t, err, "if not should return an error")
 			assert.Nil(t, r)
 		})
@@ -165,12 +420,31 @@ func TestActionConfigCreate(t *testing.T) {
 				Name:        "ranges",
 				RangeConfig: rangeConfigs,
 			}
-			r, err := ac.create()
+			r, err := ac.Create()
 			expected, _ := ranges(rangeConfigs)
 			assert.NoError(t, err)
 			assertAnonymisationFunction(t, expected, r, "2")
 		})
 	})
+	t.Run("with actions that depend on the context", func(t *testing.T) {
+		for _, ac := range []ActionConfig{
+			ActionConfig{Name: "dateShift", DateConfig: DateConfig{Format: "2006-01-02"}, DateShiftConfig: DateShiftConfig{MaxDays: 10}},
+			ActionConfig{Name: "age", DateConfig: DateConfig{Format: "2006-01-02"}, AgeConfig: AgeConfig{ReferenceColumn: "admission"}},
+			ActionConfig{Name: "bin", BinConfig: BinConfig{Quantiles: 4}},
+			ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{ActionConfig{Name: "bin", BinConfig: BinConfig{Quantiles: 4}}}},
+		} {
+			res, err := ac.Create()
+			assert.Error(t, err, "should return an error for %s", ac.Name)
+			assert.Contains(t, err.Error(), "needs a row context, use Processor")
+			assert.Nil(t, res)
+		}
+	})
+	t.Run("with an age at a reference date", func(t *testing.T) {
+		ac := ActionConfig{Name: "age", DateConfig: DateConfig{Format: "2006-01-02"}, AgeConfig: AgeConfig{ReferenceDate: "2020-01-01"}}
+		res, err := ac.Create()
+		require.NoError(t, err)
+		assertAnonymisationFunction(t, func(string) (string, error) { return "20", nil }, res, "1999-06-01")
+	})
 }
 
 func TestIdentity(t *testing.T) {
@@ -211,6 +485,156 @@ func TestHash(t *testing.T) {
 	})
 }
 
+func TestKeyConfigKey(t *testing.T) {
+	t.Run("from a file", func(t *testing.T) {
+		f, _ := ioutil.TempFile("", "anon-test")
+		defer os.Remove(f.Name())
+		ioutil.WriteFile(f.Name(), []byte("secret\n"), os.ModePerm)
+		kc := KeyConfig{KeyFile: f.Name()}
+		key, err := kc.key()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("secret"), key, "should read the key without the trailing newline")
+	})
+	t.Run("from a file that doesn't exist", func(t *testing.T) {
+		kc := KeyConfig{KeyFile: "non-existing-file"}
+		_, err := kc.key()
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("from an environment variable", func(t *testing.T) {
+		os.Setenv("ANON_TEST_KEY", "secret")
+		defer os.Unsetenv("ANON_TEST_KEY")
+		kc := KeyConfig{KeyEnv: "ANON_TEST_KEY"}
+		key, err := kc.key()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("secret"), key, "should read the key from the variable")
+	})
+	t.Run("from an empty environment variable", func(t *testing.T) {
+		kc := KeyConfig{KeyEnv: "ANON_TEST_UNSET_KEY"}
+		_, err := kc.key()
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("with both a file and an environment variable", func(t *testing.T) {
+		kc := KeyConfig{KeyFile: "file", KeyEnv: "ANON_TEST_KEY"}
+		_, err := kc.key()
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestHmacHash(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", "key")
+	defer os.Unsetenv("ANON_TEST_KEY")
+	input := "The quick brown fox jumps over the lazy dog"
+	t.Run("with the default configuration", func(t *testing.T) {
+		f, err := hmacHash(HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}})
+		require.NoError(t, err)
+		res, err := f(input)
+		assert.NoError(t, err)
+		assert.Equal(t, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", res, "should use sha256 and hex")
+	})
+	t.Run("with sha512", func(t *testing.T) {
+		f, err := hmacHash(HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, Algorithm: "sha512"})
+		require.NoError(t, err)
+		res, err := f(input)
+		assert.NoError(t, err)
+		assert.Equal(t, "b42af09057bac1e2d41708e48a902e09b5ff7f12ab428a4fe86653c73dd248fb82f948a549f7b791a5b41915ee4d1ec3935357e4e2317250d0372afa2ebeeb3a", res)
+	})
+	t.Run("with base64url encoding and a length", func(t *testing.T) {
+		f, err := hmacHash(HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, Encoding: "base64url", Length: 10})
+		require.NoError(t, err)
+		res, err := f(input)
+		assert.NoError(t, err)
+		assert.Equal(t, "97yD9DBThC", res, "should encode and truncate the output")
+	})
+	t.Run("with an unknown algorithm", func(t *testing.T) {
+		f, err := hmacHash(HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, Algorithm: "md5"})
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, f)
+	})
+	t.Run("with an unknown encoding", func(t *testing.T) {
+		f, err := hmacHash(HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, Encoding: "base32"})
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, f)
+	})
+	t.Run("with a length longer than the output", func(t *testing.T) {
+		f, err := hmacHash(HmacConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, Length: 65})
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, f)
+	})
+}
+
+func TestEncrypt(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", sivKey)
+	defer os.Unsetenv("ANON_TEST_KEY")
+	conf := EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}
+	enc, _ := encrypt(conf)
+	dec, _ := decrypt(conf)
+	t.Run("is deterministic", func(t *testing.T) {
+		res1, err1 := enc("hasselhoff")
+		res2, err2 := enc("hasselhoff")
+		assert.NoError(t, err1)
+		assert.NoError(t, err2)
+		assert.Equal(t, res1, res2, "should return the same output for the same input")
+		assert.NotEqual(t, "hasselhoff", res1)
+	})
+	t.Run("can be decrypted", func(t *testing.T) {
+		properties := gopter.NewProperties(nil)
+
+		properties.Property("decrypt(encrypt(s)) == s", prop.ForAll(
+			func(s string) bool {
+				encrypted, err1 := enc(s)
+				res, err2 := dec(encrypted)
+				return assert.NoError(t, err1) && assert.NoError(t, err2) && assert.Equal(t, s, res)
+			},
+			gen.AnyString(),
+		))
+
+		properties.TestingRun(t)
+	})
+	t.Run("can't be decrypted with another key", func(t *testing.T) {
+		os.Setenv("ANON_TEST_OTHER_KEY", "00"+sivKey[2:])
+		defer os.Unsetenv("ANON_TEST_OTHER_KEY")
+		other, _ := decrypt(EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_OTHER_KEY"}})
+		encrypted, _ := enc("hasselhoff")
+		res, err := other(encrypted)
+		assert.Equal(t, ErrAuthentication, err, "should fail to authenticate")
+		assert.Equal(t, encrypted, res, "should return the input unchanged")
+	})
+	t.Run("can't decrypt a value that is not base64url", func(t *testing.T) {
+		res, err := dec("not base64!")
+		assert.Error(t, err, "should return an error")
+		assert.Equal(t, "not base64!", res, "should return the input unchanged")
+	})
+}
+
+func TestReversals(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", sivKey)
+	defer os.Unsetenv("ANON_TEST_KEY")
+	os.Setenv("ANON_TEST_FPE_KEY", "2B7E151628AED2A6ABF7158809CF4F3C")
+	defer os.Unsetenv("ANON_TEST_FPE_KEY")
+	conf := []ActionConfig{
+		ActionConfig{Name: "hash"},
+		ActionConfig{Name: "encrypt", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}},
+		ActionConfig{Name: "fpe", FpeConfig: FpeConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_FPE_KEY"}}},
+		ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{
+			ActionConfig{Name: "outcode"},
+			ActionConfig{Name: "encrypt", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}},
+		}},
+	}
+	revs, err := Reversals(conf)
+	require.NoError(t, err)
+	dec, _ := decrypt(EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}})
+	assertAnonymisationFunction(t, identity, revs[0], "a")
+	assertAnonymisationFunction(t, dec, revs[1], "b")
+	res, err := revs[2]("2433477484")
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", res, "should decrypt the fpe columns")
+	enc, _ := encrypt(EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}})
+	encrypted, _ := enc("a1")
+	res, err = revs[3](encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", res, "should decrypt the encrypted steps of the pipelines")
+}
+
 func TestOutcode(t *testing.T) {
 	properties := gopter.NewProperties(nil)
 
@@ -239,6 +663,74 @@ func TestYear(t *testing.T) {
 		assert.Equal(t, "input", res, "should return the input unchanged")
 	})
 }
+func TestDate(t *testing.T) {
+	t.Run("with each granularity", func(t *testing.T) {
+		for granularity, expected := range map[string]string{
+			"":        "2021-01-03",
+			"day":     "2021-01-03",
+			"week":    "2020-W53",
+			"month":   "2021-01",
+			"quarter": "2021-Q1",
+			"year":    "2021",
+			"decade":  "2020s",
+		} {
+			f, err := date(DateConfig{Format: "20060102 15:04", Granularity: granularity})
+			require.NoError(t, err)
+			res, err := f("20210103 10:30")
+			assert.NoError(t, err)
+			assert.Equal(t, expected, res, "should generalise the date to the %s", granularity)
+		}
+	})
+	t.Run("with an output layout", func(t *testing.T) {
+		f, _ := date(DateConfig{Format: "20060102", Granularity: "week", Output: "02/01/2006"})
+		res, err := f("20210103")
+		assert.NoError(t, err)
+		assert.Equal(t, "28/12/2020", res, "should format the first day of the period")
+		f, _ = date(DateConfig{Format: "20060102", Granularity: "quarter", Output: "2006-01"})
+		res, _ = f("20210827")
+		assert.Equal(t, "2021-07", res, "should format the first day of the period")
+		f, _ = date(DateConfig{Format: "20060102", Granularity: "decade", Output: "2006"})
+		res, _ = f("20190827")
+		assert.Equal(t, "2010", res, "should format the first year of the decade")
+	})
+	t.Run("with several formats", func(t *testing.T) {
+		f, _ := date(DateConfig{Format: "2006-01-02", Formats: []string{"02/01/2006", "Jan 2, 2006"}, Granularity: "month"})
+		for _, s := range []string{"2021-05-17", "17/05/2021", "May 17, 2021"} {
+			res, err := f(s)
+			assert.NoError(t, err)
+			assert.Equal(t, "2021-05", res, "should parse %s with any of the formats", s)
+		}
+		res, err := f("2021.05.17")
+		assert.EqualError(t, err, "can't parse the date with any of the formats 2006-01-02, 02/01/2006, Jan 2, 2006")
+		assert.Equal(t, "2021.05.17", res, "should return the input unchanged")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []DateConfig{
+			DateConfig{},
+			DateConfig{Format: "3333"},
+			DateConfig{Format: "20060102", Formats: []string{"3333"}},
+			DateConfig{Format: "20060102", Granularity: "century"},
+		} {
+			_, err := date(conf)
+			assert.Error(t, err, "should return an error for %+v", conf)
+		}
+	})
+}
+
+func TestDateProperties(t *testing.T) {
+	properties := gopter.NewProperties(nil)
+	properties.Property("the period contains the date", prop.ForAll(
+		func(d time.Time, granularity string) bool {
+			g := granularities[granularity]
+			start := g.truncate(d)
+			return !start.After(d) && g.truncate(start).Equal(start) && start.AddDate(0, 0, 366*10).After(d)
+		},
+		gen.TimeRange(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), 200*365*24*time.Hour),
+		gen.OneConstOf("day", "week", "month", "quarter", "year", "decade"),
+	))
+	properties.TestingRun(t)
+}
+
 func TestRanges(t *testing.T) {
 	min := 0.0
 	max := 100.0
@@ -0,0 +1,178 @@
+package anon
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Checks that the bins are well defined
+func (conf *BinConfig) check() error {
+	if conf.Bottom == nil && conf.Top == nil && conf.Step == 0 && conf.Quantiles == 0 {
+		return errors.New("you need to specify at least one of bottom, top, step or quantiles")
+	} else if conf.Bottom != nil && conf.Top != nil && *conf.Bottom >= *conf.Top {
+		return errors.New("bottom must be lower than top")
+	} else if conf.Step < 0 {
+		return errors.New("step must be greater than 0")
+	} else if conf.Quantiles < 0 || conf.Quantiles == 1 {
+		return errors.New("quantiles must be at least 2")
+	} else if conf.Step > 0 && conf.Quantiles > 0 {
+		return errors.New("you can only specify one of step and quantiles")
+	}
+	return nil
+}
+
+// Returns an anonymisation that codes the values below the bottom and
+// at or above the top, and generalises the rest of numbers with middle
+func coded(conf BinConfig, middle func(v float64, s string) (string, error)) Anonymisation {
+	bottom, top := conf.BottomOutput, conf.TopOutput
+	if bottom == "" && conf.Bottom != nil {
+		bottom = "<" + formatNumber(*conf.Bottom)
+	}
+	if top == "" && conf.Top != nil {
+		top = formatNumber(*conf.Top) + "+"
+	}
+	return func(s string) (string, error) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return s, errors.New("value is not a number")
+		}
+		if conf.Bottom != nil && v < *conf.Bottom {
+			return bottom, nil
+		} else if conf.Top != nil && v >= *conf.Top {
+			return top, nil
+		}
+		return middle(v, s)
+	}
+}
+
+// Returns an anonymisation that codes the values below the bottom and at
+// or above the top, and puts the rest into bins of the width of the step
+// (e.g. [10,20) for the values from 10 up to, but not including, 20). If
+// there isn't a step, the rest of values are left unchanged.
+func bin(conf BinConfig) (Anonymisation, error) {
+	if err := conf.check(); err != nil {
+		return nil, err
+	} else if conf.Quantiles > 0 {
+		return nil, errors.New("the quantiles are computed from the data")
+	}
+	return coded(conf, func(v float64, s string) (string, error) {
+		if conf.Step == 0 {
+			return s, nil
+		}
+		lower := conf.Origin + math.Floor((v-conf.Origin)/conf.Step)*conf.Step
+		return binLabel(lower, lower+conf.Step, false), nil
+	}), nil
+}
+
+// quantiles collects the values of a column in the first pass
+// over the input and computes the boundaries of its bins from them
+type quantiles struct {
+	n      int
+	mu     sync.Mutex
+	values []float64
+	once   sync.Once
+	// Lower boundary of each bin and the maximum value
+	bounds []float64
+}
+
+func (q *quantiles) add(v float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.values = append(q.values, v)
+}
+
+// Computes the boundaries of the bins, so each one has about the same
+// number of values. Repeated boundaries are merged, so there can be
+// fewer bins than configured.
+func (q *quantiles) computeBounds() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	values := q.values
+	q.values = nil
+	if len(values) == 0 {
+		return
+	}
+	sort.Float64s(values)
+	for i := 0; i < q.n; i++ {
+		v := values[i*len(values)/q.n]
+		if len(q.bounds) == 0 || v > q.bounds[len(q.bounds)-1] {
+			q.bounds = append(q.bounds, v)
+		}
+	}
+	q.bounds = append(q.bounds, values[len(values)-1])
+}
+
+// Returns the label of the bin of the value. The first time it's called,
+// it computes the bins from the values collected. The last bin includes
+// the maximum value (e.g. [7,8]), and the values out of the bins go to
+// the closest one.
+func (q *quantiles) bin(v float64) (string, error) {
+	q.once.Do(q.computeBounds)
+	if len(q.bounds) == 0 {
+		return "", errors.New("there are no values to compute the quantiles from")
+	}
+	lower := q.bounds[:len(q.bounds)-1]
+	i := sort.Search(len(lower), func(j int) bool { return lower[j] > v }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return binLabel(q.bounds[i], q.bounds[i+1], i == len(lower)-1), nil
+}
+
+// Returns a function that, in the first pass over the input, collects the
+// values between the bottom and the top and, in the second one, puts them
+// into bins with about the same number of values. The values below the
+// bottom and at or above the top are coded in both passes.
+func quantileBins(conf BinConfig) (contextAnonymisation, error) {
+	if err := conf.check(); err != nil {
+		return nil, err
+	}
+	q := &quantiles{n: conf.Quantiles}
+	collect := coded(conf, func(v float64, s string) (string, error) {
+		q.add(v)
+		return s, nil
+	})
+	binned := coded(conf, func(v float64, _ string) (string, error) {
+		return q.bin(v)
+	})
+	return func(ctx rowContext) Anonymisation {
+		if ctx.firstPass {
+			return collect
+		}
+		return binned
+	}, nil
+}
+
+// Returns if any of the actions, or of the steps of their pipelines,
+// computes its bins from the data, so the input is read twice
+func anyQuantiles(configs []ActionConfig) bool {
+	for _, config := range configs {
+		if config.Name == "bin" && config.BinConfig.Quantiles > 0 || anyQuantiles(config.Pipeline) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the label of the bin from lower to upper in interval notation,
+// e.g. [-10,-5) or [-10,-5] if it includes the upper boundary, or the
+// boundary if both are the same
+func binLabel(lower float64, upper float64, closed bool) string {
+	if lower == upper {
+		return formatNumber(lower)
+	}
+	end := ")"
+	if closed {
+		end = "]"
+	}
+	return "[" + formatNumber(lower) + "," + formatNumber(upper) + end
+}
+
+// Formats the number without the rounding errors
+// of the arithmetic of the bins (e.g. 0.3 for 3*0.1)
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', 12, 64)
+}
@@ -0,0 +1,243 @@
+package anon
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestBin(t *testing.T) {
+	t.Run("with top and bottom coding", func(t *testing.T) {
+		f, err := bin(BinConfig{Bottom: float(18), Top: float(90)})
+		require.NoError(t, err)
+		for value, expected := range map[string]string{"17.5": "<18", "18": "18", "45": "45", "90": "90+", "104": "90+"} {
+			res, err := f(value)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, res, "should code %s", value)
+		}
+		f, _ = bin(BinConfig{Top: float(90), TopOutput: "old"})
+		res, _ := f("95")
+		assert.Equal(t, "old", res, "should use the label of the top if it's set")
+	})
+	t.Run("with a step", func(t *testing.T) {
+		f, err := bin(BinConfig{Step: 10, Top: float(90)})
+		require.NoError(t, err)
+		for value, expected := range map[string]string{"0": "[0,10)", "9.9": "[0,10)", "10": "[10,20)", "-1": "[-10,0)", "95": "90+"} {
+			res, err := f(value)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, res, "should put %s in its bin", value)
+		}
+		f, _ = bin(BinConfig{Step: 0.1, Origin: 0.05})
+		res, _ := f("0.3")
+		assert.Equal(t, "[0.25,0.35)", res, "should start the bins at the origin")
+	})
+	t.Run("with negative values", func(t *testing.T) {
+		f, err := bin(BinConfig{Step: 5, Bottom: float(-20), Top: float(5)})
+		require.NoError(t, err)
+		for value, expected := range map[string]string{"-10": "[-10,-5)", "-5.5": "[-10,-5)", "-5": "[-5,0)", "-0.1": "[-5,0)", "0": "[0,5)", "-20": "[-20,-15)", "-21": "<-20"} {
+			res, err := f(value)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, res, "should put %s in its bin", value)
+		}
+	})
+	t.Run("with a value that is not a number", func(t *testing.T) {
+		f, _ := bin(BinConfig{Step: 10})
+		for _, value := range []string{"a", "NaN", "Inf"} {
+			_, err := f(value)
+			assert.Error(t, err, "should return an error for %s", value)
+		}
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []BinConfig{
+			BinConfig{},
+			BinConfig{Bottom: float(10), Top: float(10)},
+			BinConfig{Step: -1},
+			BinConfig{Quantiles: 1},
+			BinConfig{Step: 10, Quantiles: 4},
+			BinConfig{Quantiles: 4},
+		} {
+			_, err := bin(conf)
+			assert.Error(t, err, "should return an error for %+v", conf)
+		}
+	})
+}
+
+func TestBinProperties(t *testing.T) {
+	properties := gopter.NewProperties(nil)
+	properties.Property("the bins have the width of the step", prop.ForAll(
+		func(v float64, step int) bool {
+			f, _ := bin(BinConfig{Step: float64(step)})
+			res, err := f(formatNumber(v))
+			lower := math.Floor(v/float64(step)) * float64(step)
+			return err == nil && res == binLabel(lower, lower+float64(step), false)
+		},
+		gen.Float64Range(-1e6, 1e6),
+		gen.IntRange(1, 1000),
+	))
+	properties.TestingRun(t)
+}
+
+func TestQuantileBins(t *testing.T) {
+	create, err := quantileBins(BinConfig{Quantiles: 4, Top: float(100)})
+	require.NoError(t, err)
+	collect, binned := create(rowContext{firstPass: true}), create(rowContext{})
+	for _, value := range []string{"8", "1", "2", "3", "4", "5", "6", "7", "200"} {
+		res, err := collect(value)
+		assert.NoError(t, err)
+		if value == "200" {
+			assert.Equal(t, "100+", res, "should code the values above the top in the first pass")
+		} else {
+			assert.Equal(t, value, res, "should return the values unchanged in the first pass")
+		}
+	}
+	for value, expected := range map[string]string{"1": "[1,3)", "2": "[1,3)", "3": "[3,5)", "7": "[7,8]", "8": "[7,8]", "0": "[1,3)", "200": "100+"} {
+		res, err := binned(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, res, "should put %s in its quantile", value)
+	}
+
+	t.Run("with repeated values", func(t *testing.T) {
+		create, _ := quantileBins(BinConfig{Quantiles: 4})
+		for _, value := range []string{"1", "2", "9", "9", "9", "9", "9", "9"} {
+			create(rowContext{firstPass: true})(value)
+		}
+		for value, expected := range map[string]string{"1": "[1,9)", "9": "9"} {
+			res, _ := create(rowContext{})(value)
+			assert.Equal(t, expected, res, "should merge the repeated boundaries")
+		}
+	})
+	t.Run("with negative values", func(t *testing.T) {
+		create, _ := quantileBins(BinConfig{Quantiles: 2})
+		for _, value := range []string{"-10", "-8", "-5", "-1"} {
+			create(rowContext{firstPass: true})(value)
+		}
+		for value, expected := range map[string]string{"-10": "[-10,-5)", "-8": "[-10,-5)", "-5": "[-5,-1]", "-1": "[-5,-1]"} {
+			res, _ := create(rowContext{})(value)
+			assert.Equal(t, expected, res, "should put %s in its quantile", value)
+		}
+	})
+	t.Run("without values", func(t *testing.T) {
+		create, _ := quantileBins(BinConfig{Quantiles: 4})
+		_, err := create(rowContext{})("1")
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestNeedsFirstPass(t *testing.T) {
+	quantiles := ActionConfig{Name: "bin", BinConfig: BinConfig{Quantiles: 4}}
+	assert.True(t, needsFirstPass(&Config{Actions: []ActionConfig{ActionConfig{Name: "nothing"}, quantiles}}))
+	assert.True(t, needsFirstPass(&Config{Actions: []ActionConfig{ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{quantiles}}}}), "should look into the pipelines")
+	assert.True(t, needsFirstPass(&Config{Output: []OutputColumn{OutputColumn{Actions: []ActionConfig{quantiles}}}}), "should look into the output columns")
+	assert.True(t, needsFirstPass(&Config{KAnonymity: KAnonymityConfig{K: 5}}), "should read the input twice to enforce k-anonymity")
+	assert.False(t, needsFirstPass(&Config{Actions: []ActionConfig{ActionConfig{Name: "bin", BinConfig: BinConfig{Step: 10}}}}))
+}
+
+func TestProcessorProcessQuantiles(t *testing.T) {
+	input := "id,income\n1,800\n2,100\n3,200\n4,300\n5,400\n6,500\n7,600\n8,700\n9,x\n"
+	expected := "id,income\n1,\"[700,800]\"\n2,\"[100,300)\"\n3,\"[100,300)\"\n4,\"[300,500)\"\n5,\"[300,500)\"\n6,\"[500,700)\"\n7,\"[500,700)\"\n8,\"[700,800]\"\n"
+	conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Actions: []ActionConfig{
+		ActionConfig{Name: "bin", Column: "income", BinConfig: BinConfig{Quantiles: 4}},
+	}}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	for _, workers := range []int{1, 4} {
+		var out, rejects bytes.Buffer
+		logs.Reset()
+		stats := &Stats{}
+		p, err := NewProcessor(conf, Options{Workers: workers, Rejects: &rejects, Stats: stats})
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			out.Reset()
+			require.NoError(t, p.Process(strings.NewReader(input), &out))
+			assert.Equal(t, expected, out.String(), "should compute the quantiles from the input")
+		}
+		assert.Equal(t, 2, strings.Count(rejects.String(), "not a number"), "should only reject the records in the second pass")
+		assert.Equal(t, 2, strings.Count(logs.String(), "not a number"), "should only log the rejected records in the second pass")
+		assert.EqualValues(t, 18, stats.Read, "should only collect the statistics of the second pass")
+	}
+	t.Run("in a pipeline of an output column", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{Csv: CsvConfig{Delimiter: ","}, Output: []OutputColumn{OutputColumn{SourceIndex: 1, Actions: []ActionConfig{
+			ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{ActionConfig{Name: "bin", BinConfig: BinConfig{Quantiles: 2}}}},
+		}}}}
+		p, err := NewProcessor(conf, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(strings.NewReader("a,1\nb,2\nc,3\nd,4\n"), &out))
+		assert.Equal(t, "\"[1,3)\"\n\"[1,3)\"\n\"[3,4]\"\n\"[3,4]\"\n", out.String(), "should compute the quantiles of the column")
+	})
+	t.Run("with an input that can't be seeked", func(t *testing.T) {
+		tmp := t.TempDir()
+		t.Setenv("TMPDIR", tmp)
+		var out bytes.Buffer
+		p, err := NewProcessor(conf, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(io.MultiReader(strings.NewReader(input)), &out))
+		assert.Equal(t, expected, out.String(), "should read the input again from a copy")
+		files, _ := ioutil.ReadDir(tmp)
+		assert.Empty(t, files, "should remove the copy of the input")
+	})
+	t.Run("with an input that can be seeked", func(t *testing.T) {
+		tmp := t.TempDir()
+		t.Setenv("TMPDIR", tmp)
+		var out bytes.Buffer
+		in := strings.NewReader("skipped\n" + input)
+		in.Seek(int64(len("skipped\n")), io.SeekStart)
+		p, err := NewProcessor(conf, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(in, &out))
+		assert.Equal(t, expected, out.String(), "should seek back to where the input started")
+		files, _ := ioutil.ReadDir(tmp)
+		assert.Empty(t, files, "should not copy the input")
+	})
+}
+
+func TestProcessorProcessFrom(t *testing.T) {
+	conf := &Config{Csv: CsvConfig{Delimiter: ","}, Actions: []ActionConfig{
+		ActionConfig{Name: "nothing"}, ActionConfig{Name: "bin", BinConfig: BinConfig{Quantiles: 2}},
+	}}
+	p, err := NewProcessor(conf, Options{})
+	require.NoError(t, err)
+	var opened, closed int
+	open := func() (io.ReadCloser, error) {
+		opened++
+		return readCloser{strings.NewReader("a,1\nb,2\nc,3\nd,4\n"), func() { closed++ }}, nil
+	}
+	t.Run("when the input can be opened", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, p.ProcessFrom(open, &out))
+		assert.Equal(t, "a,\"[1,3)\"\nb,\"[1,3)\"\nc,\"[3,4]\"\nd,\"[3,4]\"\n", out.String(), "should compute the quantiles from the input")
+		assert.Equal(t, 2, opened, "should open the input for each pass")
+		assert.Equal(t, 2, closed, "should close the inputs opened")
+	})
+	t.Run("when the input can't be opened", func(t *testing.T) {
+		err := p.ProcessFrom(func() (io.ReadCloser, error) { return nil, errors.New("not found") }, ioutil.Discard)
+		assert.EqualError(t, err, "not found", "should return the error")
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	close func()
+}
+
+func (r readCloser) Close() error {
+	r.close()
+	return nil
+}
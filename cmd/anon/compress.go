@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Magic bytes at the beginning of the compressed inputs
+var magics = []struct {
+	compression string
+	magic       []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// Compression of the output given the extension of the file
+var extensions = map[string]string{
+	".gz":   "gzip",
+	".bz2":  "bzip2",
+	".xz":   "xz",
+	".zst":  "zstd",
+	".zstd": "zstd",
+}
+
+// Opens the input file (stdin if filename is empty) and, if it's
+// compressed with gzip, bzip2, xz or zstd, decompresses it on the fly.
+func initReader(filename string) (io.Reader, error) {
+	return decompress(fileOr(filename, os.Stdin, os.Open))
+}
+
+// Opens the input file and decompresses it like initReader. Closing the
+// reader closes the file, so it can be opened again for each pass over
+// the input instead of copying the decompressed content to disk.
+func openInput(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if r == io.Reader(f) {
+		return f, nil
+	}
+	return decompressedFile{r, f}, nil
+}
+
+// decompressedFile is a reader of a compressed file that closes the file
+// (and the decompressor, if it can be closed) when it's closed
+type decompressedFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (d decompressedFile) Close() error {
+	if c, ok := d.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return d.f.Close()
+}
+
+// Returns a reader that decompresses the input if it starts with
+// the magic bytes of a known compression. Inputs that can be seeked
+// (i.e. files) are returned unchanged if they are not compressed.
+func decompress(in io.Reader) (io.Reader, error) {
+	r := in
+	var head []byte
+	if s, ok := in.(io.ReadSeeker); ok && seekable(s) {
+		head = make([]byte, 6)
+		n, _ := io.ReadFull(s, head)
+		head = head[:n]
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else {
+		br := bufio.NewReader(in)
+		head, _ = br.Peek(6)
+		r = br
+	}
+	for _, m := range magics {
+		if !bytes.HasPrefix(head, m.magic) {
+			continue
+		}
+		switch m.compression {
+		case "gzip":
+			return gzip.NewReader(r)
+		case "bzip2":
+			return bzip2.NewReader(r), nil
+		case "xz":
+			return xz.NewReader(r)
+		case "zstd":
+			d, err := zstd.NewReader(r, zstd.WithDecoderLowmem(true))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}
+	}
+	return r, nil
+}
+
+func seekable(s io.Seeker) bool {
+	_, err := s.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+// Creates the output file (stdout if filename is empty) compressed
+// with compression, that can be none, gzip, xz or zstd. If compression
+// is empty, it's chosen by the extension of the file.
+func initWriter(filename string, compression string) (io.WriteCloser, error) {
+	if compression == "" {
+		compression = extensions[strings.ToLower(filepath.Ext(filename))]
+	}
+	if err := checkCompression(compression); err != nil {
+		return nil, err
+	}
+	return compress(fileOr(filename, os.Stdout, os.Create), compression)
+}
+
+func checkCompression(compression string) error {
+	switch compression {
+	case "", "none", "gzip", "xz", "zstd":
+		return nil
+	case "bzip2":
+		return errors.New("bzip2 is only supported to decompress the input")
+	}
+	return fmt.Errorf("invalid compression %s, it must be one of none, gzip, xz or zstd", compression)
+}
+
+// Returns a writer that compresses what is written to out. Closing
+// it flushes the compressed data, but out is left open.
+func compress(out io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(out), nil
+	case "xz":
+		return xz.NewWriter(out)
+	case "zstd":
+		return zstd.NewWriter(out)
+	}
+	return nopCloser{out}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
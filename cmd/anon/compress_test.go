@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// "a,b\n" compressed with bzip2
+const bzip2Content = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x03\x43\x3a\xe0\x00\x00\x01\x51\x00\x00\x10\x00\x04\x30\x00\x20\x00\x21\x9a\x68\x33\x4d\x17\x3c\x5d\xc9\x14\xe1\x42\x40\x0d\x0c\xeb\x80"
+
+func TestDecompress(t *testing.T) {
+	for _, compression := range []string{"none", "gzip", "xz", "zstd"} {
+		t.Run("with "+compression, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := compress(&buf, compression)
+			require.NoError(t, err)
+			w.Write([]byte("a,b\n1,2\n"))
+			require.NoError(t, w.Close())
+
+			r, err := decompress(&buf)
+			assert.NoError(t, err)
+			content, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, "a,b\n1,2\n", string(content), "should decompress the input")
+		})
+	}
+	t.Run("with bzip2", func(t *testing.T) {
+		r, err := decompress(strings.NewReader(bzip2Content))
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "a,b\n", string(content), "should decompress the input")
+	})
+	t.Run("with an uncompressed file", func(t *testing.T) {
+		tmpfile := tmpFile("a,b\n")
+		defer os.Remove(tmpfile.Name()) // clean up
+
+		r, err := decompress(tmpfile)
+		assert.NoError(t, err)
+		assert.Equal(t, tmpfile, r, "should return the file so it can be read at random")
+		content, _ := ioutil.ReadAll(r)
+		assert.Equal(t, "a,b\n", string(content), "should read it from the beginning")
+	})
+	t.Run("with an empty input", func(t *testing.T) {
+		r, err := decompress(strings.NewReader(""))
+		assert.NoError(t, err)
+		content, _ := ioutil.ReadAll(r)
+		assert.Empty(t, content)
+	})
+	t.Run("with an invalid compressed input", func(t *testing.T) {
+		_, err := decompress(strings.NewReader("\x1f\x8bnot gzip"))
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestOpenInput(t *testing.T) {
+	dir := t.TempDir()
+	for _, compression := range []string{"none", "gzip"} {
+		t.Run("with "+compression, func(t *testing.T) {
+			filename := filepath.Join(dir, "in."+compression)
+			w, err := initWriter(filename, compression)
+			require.NoError(t, err)
+			w.Write([]byte("a,b\n"))
+			require.NoError(t, w.Close())
+
+			for i := 0; i < 2; i++ {
+				r, err := openInput(filename)
+				require.NoError(t, err)
+				content, _ := io.ReadAll(r)
+				assert.Equal(t, "a,b\n", string(content), "should read the whole file each time it's opened")
+				assert.NoError(t, r.Close(), "should close the file")
+			}
+		})
+	}
+	t.Run("when the file doesn't exist", func(t *testing.T) {
+		_, err := openInput(filepath.Join(dir, "missing.csv"))
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("with an invalid compressed file", func(t *testing.T) {
+		filename := filepath.Join(dir, "invalid.gz")
+		require.NoError(t, ioutil.WriteFile(filename, []byte("\x1f\x8bnot gzip"), 0600))
+		_, err := openInput(filename)
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestInitWriter(t *testing.T) {
+	dir := t.TempDir()
+	t.Run("by the extension of the file", func(t *testing.T) {
+		filename := filepath.Join(dir, "out.csv.ZST")
+		w, err := initWriter(filename, "")
+		require.NoError(t, err)
+		w.Write([]byte("a,b\n"))
+		require.NoError(t, w.Close())
+
+		r, err := initReader(filename)
+		require.NoError(t, err)
+		content, _ := io.ReadAll(r)
+		assert.Equal(t, "a,b\n", string(content), "should compress the output")
+		head, _ := ioutil.ReadFile(filename)
+		assert.True(t, bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}), "should use zstd")
+	})
+	t.Run("with an explicit compression", func(t *testing.T) {
+		filename := filepath.Join(dir, "out.csv.gz")
+		w, err := initWriter(filename, "none")
+		require.NoError(t, err)
+		w.Write([]byte("a,b\n"))
+		require.NoError(t, w.Close())
+		content, _ := ioutil.ReadFile(filename)
+		assert.Equal(t, "a,b\n", string(content), "should take precedence over the extension")
+	})
+	t.Run("with an invalid compression", func(t *testing.T) {
+		for _, compression := range []string{"bzip2", "lz4"} {
+			_, err := initWriter(filepath.Join(dir, "out.csv"), compression)
+			assert.Error(t, err, "should return an error for %s", compression)
+		}
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/intenthq/anon"
+)
+
+func main() {
+	rand.Seed(time.Now().UTC().UnixNano())
+	if len(os.Args) > 1 && os.Args[1] == "reveal" {
+		revealCommand(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "risk" {
+		riskCommand(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "scan" {
+		scanCommand(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "validate" {
+		validateCommand(os.Args[2:])
+		return
+	}
+	//TODO move args parsing to a function
+	configFile := flag.String("config", "config.json", "Configuration of the data to be anonymised. Default is 'config.json'")
+	outputFile := flag.String("output", "", "Output file. Default is stdout.")
+	compression := flag.String("compress", "", "Compression of the output, either 'none', 'gzip', 'xz' or 'zstd'. Default is by the extension of the output file.")
+	rejectsFile := flag.String("rejects", "", "File where the rejected records are written to. Default is none.")
+	rejectsValues := flag.String("rejects-values", "omit", "What to do with the raw values in the rejects file, either 'omit' or 'hash'. Default is 'omit'.")
+	statsFile := flag.String("stats", "", "File where a JSON report with the statistics of the run is written to. Default is none.")
+	workers := flag.Int("workers", 1, "Number of records anonymised in parallel. Default is 1.")
+	flag.Parse()
+	log.Printf("Using configuration in file %s\n", *configFile)
+	conf, err := anon.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts, err := initOptions(*rejectsFile, *rejectsValues, *statsFile != "", *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := anon.NewProcessor(conf, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := initWriter(*outputFile, *compression)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if filename := flag.Arg(0); filename != "" {
+		// the file is opened (and decompressed) again if it's read twice
+		err = p.ProcessFrom(func() (io.ReadCloser, error) { return openInput(filename) }, out)
+	} else {
+		var in io.Reader
+		if in, err = initReader(""); err != nil {
+			log.Fatal(err)
+		}
+		err = p.Process(in, out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Stats != nil {
+		if err := opts.Stats.WriteJSON(fileOr(*statsFile, os.Stdout, os.Create)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Reverses the encrypted columns of a file previously anonymised
+// with the same config and keys.
+func revealCommand(args []string) {
+	flags := flag.NewFlagSet("reveal", flag.ExitOnError)
+	configFile := flags.String("config", "config.json", "Configuration used to anonymise the data. Default is 'config.json'")
+	outputFile := flags.String("output", "", "Output file. Default is stdout.")
+	compression := flags.String("compress", "", "Compression of the output, either 'none', 'gzip', 'xz' or 'zstd'. Default is by the extension of the output file.")
+	flags.Parse(args)
+	log.Printf("Using configuration in file %s\n", *configFile)
+	conf, err := anon.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in, err := initReader(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := initWriter(*outputFile, *compression)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := anon.Reveal(conf, in, out); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Reports the re-identification risk of a csv, usually already anonymised
+func riskCommand(args []string) {
+	flags := flag.NewFlagSet("risk", flag.ExitOnError)
+	quasi := flags.String("quasi", "", "Comma separated list of the quasi-identifier columns, by name (or position if the csv doesn't have a header).")
+	sensitive := flags.String("sensitive", "", "Comma separated list of the sensitive columns the l-diversity is reported for. Default is none.")
+	delimiter := flags.String("delimiter", ",", "Delimiter of the csv. Default is ','.")
+	header := flags.Bool("header", true, "If the csv has a header. Default is true.")
+	fraction := flags.Float64("sampling-fraction", 1, "Fraction of the population the csv is a sample of, to estimate the journalist risk. Default is 1.")
+	format := flags.String("format", "text", "Format of the report, either 'text' or 'json'. Default is 'text'.")
+	outputFile := flags.String("output", "", "Output file. Default is stdout.")
+	flags.Parse(args)
+	if *format != "text" && *format != "json" {
+		log.Fatalf("invalid format %s, it must be either 'text' or 'json'", *format)
+	}
+	in, err := initReader(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := anon.AssessRisk(in, anon.RiskConfig{
+		Csv:              anon.CsvConfig{Delimiter: *delimiter, Header: *header},
+		QuasiIdentifiers: splitColumns(*quasi),
+		Sensitive:        splitColumns(*sensitive),
+		SamplingFraction: *fraction,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := fileOr(*outputFile, os.Stdout, os.Create)
+	if *format == "json" {
+		err = report.WriteJSON(out)
+	} else {
+		err = report.WriteText(out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Detects the personal data in the first records of a csv
+// and writes a draft config to anonymise it
+func scanCommand(args []string) {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	delimiter := flags.String("delimiter", ",", "Delimiter of the csv. Default is ','.")
+	header := flags.String("header", "detect", "If the csv has a header, either 'true', 'false' or 'detect'. Default is 'detect'.")
+	rows := flags.Int("rows", 1000, "Number of records read from the start of the csv. Default is 1000.")
+	outputFile := flags.String("output", "", "File where the draft config is written to. Default is stdout.")
+	flags.Parse(args)
+	csvConf, err := scanCsvConfig(*delimiter, *header)
+	if err != nil {
+		log.Fatal(err)
+	}
+	in, err := initReader(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := anon.Scan(in, anon.ScanConfig{Csv: csvConf, Rows: *rows})
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := fileOr(*outputFile, os.Stdout, os.Create)
+	if err := report.WriteConfig(out); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Checks a config without anonymising any data, writing the problems
+// found. It exits with an error if any of them isn't a warning.
+func validateCommand(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := flags.String("config", "config.json", "Configuration to validate. Default is 'config.json'")
+	flags.Parse(args)
+	f, err := os.Open(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	problems, err := anon.ValidateConfig(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !reportProblems(os.Stdout, *configFile, problems) {
+		os.Exit(1)
+	}
+}
+
+// Writes the problems found in the config, one per line prefixed by the
+// name of its file, and returns if it's valid (i.e. there are only warnings)
+func reportProblems(w io.Writer, filename string, problems []anon.Problem) bool {
+	valid := true
+	for _, p := range problems {
+		// the position is written as file:line:column
+		separator := ": "
+		if p.Line > 0 {
+			separator = ":"
+		}
+		fmt.Fprintf(w, "%s%s%s\n", filename, separator, p)
+		valid = valid && p.Warning
+	}
+	if valid {
+		fmt.Fprintf(w, "%s is valid\n", filename)
+	}
+	return valid
+}
+
+// Returns the config of the csv scanned given the command line flags
+func scanCsvConfig(delimiter string, header string) (anon.CsvConfig, error) {
+	conf := anon.CsvConfig{Delimiter: delimiter}
+	switch header {
+	case "true":
+		conf.Header = true
+	case "false":
+	case "detect":
+		conf.DetectHeader = true
+	default:
+		return conf, fmt.Errorf("invalid header %s, it must be either 'true', 'false' or 'detect'", header)
+	}
+	return conf, nil
+}
+
+// Returns the names of the columns in a comma separated list
+func splitColumns(list string) []string {
+	var res []string
+	for _, column := range strings.Split(list, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			res = append(res, column)
+		}
+	}
+	return res
+}
+
+// Returns the options of the processor given the command line flags.
+// The raw values in the rejects file are hashed if values is 'hash'.
+func initOptions(rejectsFile string, values string, stats bool, workers int) (anon.Options, error) {
+	opts := anon.Options{Workers: workers}
+	switch values {
+	case "omit":
+	case "hash":
+		opts.HashRejectedValues = true
+	default:
+		return opts, fmt.Errorf("invalid rejects-values %s, it must be either 'omit' or 'hash'", values)
+	}
+	if rejectsFile != "" {
+		opts.Rejects = fileOr(rejectsFile, os.Stdout, os.Create)
+	}
+	if stats {
+		opts.Stats = anon.NewStats()
+	}
+	return opts, nil
+}
+
+// If filename is empty, will return `def`, if it's not, will return the
+// result of the function `action` after passing `filename` ot it.
+func fileOr(filename string, def *os.File, action func(string) (*os.File, error)) *os.File {
+	if filename == "" {
+		return def
+	}
+	f, err := action(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
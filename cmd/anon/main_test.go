@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/intenthq/anon"
+	"github.com/stretchr/testify/assert"
+)
+
+func tmpFile(content string) *os.File {
+	tmpfile, err := ioutil.TempFile("", "anon-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	ioutil.WriteFile(tmpfile.Name(), []byte(content), os.ModePerm)
+	return tmpfile
+}
+
+func TestInitOptions(t *testing.T) {
+	t.Run("with the default flags", func(t *testing.T) {
+		opts, err := initOptions("", "omit", false, 1)
+		assert.NoError(t, err)
+		assert.Nil(t, opts.Rejects, "should not write the rejected records")
+		assert.Nil(t, opts.Stats, "should not collect statistics")
+		assert.Equal(t, 1, opts.Workers)
+	})
+	t.Run("with invalid values", func(t *testing.T) {
+		_, err := initOptions("", "keep", false, 1)
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("with a rejects file and statistics", func(t *testing.T) {
+		tmpfile := tmpFile("")
+		defer os.Remove(tmpfile.Name()) // clean up
+
+		opts, err := initOptions(tmpfile.Name(), "hash", true, 4)
+		assert.NoError(t, err)
+		assert.NotNil(t, opts.Rejects, "should write the rejected records")
+		assert.True(t, opts.HashRejectedValues, "should hash the values")
+		assert.NotNil(t, opts.Stats, "should collect statistics")
+		assert.Equal(t, 4, opts.Workers)
+	})
+}
+
+func TestSplitColumns(t *testing.T) {
+	assert.Equal(t, []string{"postcode", "year"}, splitColumns("postcode, year"), "should split the list by commas")
+	assert.Nil(t, splitColumns(""), "should return no columns for an empty list")
+	assert.Equal(t, []string{"0", "2"}, splitColumns("0,,2,"), "should skip the empty names")
+}
+
+func TestScanCsvConfig(t *testing.T) {
+	conf, err := scanCsvConfig(";", "detect")
+	assert.NoError(t, err)
+	assert.Equal(t, anon.CsvConfig{Delimiter: ";", DetectHeader: true}, conf, "should detect the header")
+	conf, _ = scanCsvConfig(",", "true")
+	assert.True(t, conf.Header, "should read the header")
+	_, err = scanCsvConfig(",", "yes")
+	assert.Error(t, err, "should return an error for an invalid header")
+}
+
+func TestReportProblems(t *testing.T) {
+	var out bytes.Buffer
+	warning := anon.Problem{Path: "sampling.idColumn", Line: 3, Column: 5, Warning: true, Message: "out of range"}
+	assert.True(t, reportProblems(&out, "config.json", []anon.Problem{warning}), "should be valid with only warnings")
+	assert.Equal(t, "config.json:3:5: sampling.idColumn: warning: out of range\nconfig.json is valid\n", out.String())
+
+	out.Reset()
+	assert.False(t, reportProblems(&out, "config.json", []anon.Problem{warning, anon.Problem{Message: "invalid"}}), "should not be valid with errors")
+	assert.Equal(t, "config.json:3:5: sampling.idColumn: warning: out of range\nconfig.json: invalid\n", out.String())
+}
+
+func TestFileOr(t *testing.T) {
+	assert.Equal(t, fileOr("", os.Stdin, stdOutOk), os.Stdin, "with an empty filename returns the default value")
+	assert.Equal(t, fileOr("something", os.Stdin, stdOutOk), os.Stdout, "with non empty filename returns the value returned by the action")
+	t.Run("with a valid filename", func(t *testing.T) {
+		tmpfile := tmpFile("content")
+		defer os.Remove(tmpfile.Name()) // clean up
+
+		f := fileOr(tmpfile.Name(), os.Stdin, os.Open)
+		content, err := ioutil.ReadAll(f)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "content", string(content), "should return the file")
+	})
+}
+
+func stdOutOk(s string) (*os.File, error) {
+	return os.Stdout, nil
+}
@@ -1,26 +1,79 @@
-package main
+package anon
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 )
 
 // CsvConfig stores the config to read and write the csv file
 type CsvConfig struct {
 	Delimiter string
+	// If true, the first row is read as a header and
+	// the columns can be referenced by name
+	Header bool
+	// If true, the first row is read as a header only
+	// if it looks like one. Can't be used with Header
+	DetectHeader bool
+	// New names of the columns in the output header,
+	// by their name in the input header
+	Rename map[string]string
+	// If true, the header is read but not written to the output
+	DropHeader bool
+}
+
+// Checks that the header options are consistent
+func (cc *CsvConfig) check() error {
+	if cc.Header && cc.DetectHeader {
+		return errors.New("only one of header and detectHeader can be set")
+	}
+	if !cc.Header && !cc.DetectHeader && (len(cc.Rename) > 0 || cc.DropHeader) {
+		return errors.New("the header can only be renamed or dropped if the csv has one")
+	}
+	return nil
 }
 
 // SamplingConfig stores the config to know how to sample the file
 type SamplingConfig struct {
 	Mod      uint32
 	IDColumn uint32
+	// Name of the id column, only used when the csv has a header.
+	// Takes precedence over IDColumn
+	IDColumnName string
 }
 
+// JSONLConfig stores the config to read and write JSON Lines files
+type JSONLConfig struct {
+	// What to do with the fields without an action, either
+	// keep them unchanged (default) or drop them from the output
+	Untouched string
+}
+
+// Formats of the files that can be anonymised
+const (
+	FormatCsv     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
 // Config stores all the configuration
 type Config struct {
+	// Format of the input and output files, csv if not set
+	Format   string
 	Csv      CsvConfig
+	JSONL    JSONLConfig
+	Parquet  ParquetConfig
 	Sampling SamplingConfig
 	Actions  []ActionConfig
+	// If set, the columns written to the output, in order, instead
+	// of the input ones. The input columns not used aren't written
+	Output []OutputColumn
+	// If K is set, the records whose combination of values of the
+	// quasi-identifiers is written less than K times are suppressed
+	// or generalised
+	KAnonymity KAnonymityConfig
 }
 
 var defaultCsvConfig = CsvConfig{
@@ -34,21 +87,79 @@ var defaultSamplingConfig = SamplingConfig{
 
 var defaultActionsConfig = []ActionConfig{}
 
-func loadConfig(filename string) (*Config, error) {
+// LoadConfig reads the config from a JSON file, filling
+// the values that are not defined with the defaults.
+func LoadConfig(filename string) (*Config, error) {
 	file, err := os.Open(filename)
-	defer file.Close()
 	if err != nil {
 		return nil, err
 	}
-	decoder := json.NewDecoder(file)
+	defer file.Close()
+	return ReadConfig(file)
+}
+
+// ReadConfig decodes the config from JSON, filling
+// the values that are not defined with the defaults.
+func ReadConfig(r io.Reader) (*Config, error) {
+	decoder := json.NewDecoder(r)
 	conf := Config{
 		Csv:      defaultCsvConfig,
 		Sampling: defaultSamplingConfig,
 		Actions:  defaultActionsConfig,
 	}
-	err = decoder.Decode(&conf)
-	if err != nil {
+	if err := decoder.Decode(&conf); err != nil {
 		return nil, err
 	}
-	return &conf, err
+	return &conf, nil
+}
+
+// Returns the index of each column in the header, failing
+// if any of the names is empty or duplicated
+func headerIndices(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		if name == "" {
+			return nil, fmt.Errorf("column %d has an empty name in the header", i)
+		}
+		if j, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column name %s is duplicated in the header (columns %d and %d)", name, j, i)
+		}
+		columns[name] = i
+	}
+	return columns, nil
+}
+
+// Returns if the config is of a csv that doesn't have a header
+// (and it isn't detected), so the columns are only known by position
+func (conf *Config) withoutHeader() bool {
+	csv := conf.Format == "" || conf.Format == FormatCsv
+	return csv && !conf.Csv.Header && !conf.Csv.DetectHeader
+}
+
+// Returns an error, and its path in the config, if an action or the id
+// column references a column by name when the csv doesn't have a header,
+// as the actions would otherwise be applied to the columns by position
+func headerlessColumns(conf *Config) (string, error) {
+	for i, ac := range conf.Actions {
+		if ac.Column != "" {
+			return fmt.Sprintf("actions[%d].column", i), fmt.Errorf("action %d (%s) references column %s by name, but the csv doesn't have a header", i, ac.Name, ac.Column)
+		}
+	}
+	if conf.Sampling.IDColumnName != "" {
+		return "sampling.idColumnName", fmt.Errorf("the id column %s is referenced by name, but the csv doesn't have a header", conf.Sampling.IDColumnName)
+	}
+	return "", nil
+}
+
+// Returns the index of the id column, resolving its name
+// against the header columns if it has been configured
+func (sc *SamplingConfig) idColumnIndex(columns map[string]int) (uint32, error) {
+	if sc.IDColumnName == "" {
+		return sc.IDColumn, nil
+	}
+	i, ok := columns[sc.IDColumnName]
+	if !ok {
+		return 0, fmt.Errorf("id column %s not found in the header", sc.IDColumnName)
+	}
+	return uint32(i), nil
 }
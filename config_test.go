@@ -1,4 +1,4 @@
-package main
+package anon
 
 import (
 	"testing"
@@ -9,17 +9,17 @@ import (
 
 func TestLoadConfig(t *testing.T) {
 	t.Run("if the file doesn't exist", func(t *testing.T) {
-		conf, err := loadConfig("non-existing-file")
+		conf, err := LoadConfig("non-existing-file")
 		assert.Nil(t, conf, "should return nil if the file doesn't exist")
 		assert.Error(t, err, "should return the error if the file doesn't exist")
 	})
 	t.Run("if the json can't be decoded", func(t *testing.T) {
-		conf, err := loadConfig("config_invalid_test.json")
+		conf, err := LoadConfig("config_invalid_test.json")
 		assert.Nil(t, conf, "should return nil if the json can't be decoded")
 		assert.Error(t, err, "should return the error if the json can't be decoded")
 	})
 	t.Run("default config values", func(t *testing.T) {
-		conf, err := loadConfig("config_defaults_test.json")
+		conf, err := LoadConfig("config_defaults_test.json")
 		require.NoError(t, err, "should return no error if the config can be loaded")
 		assert.Equal(t, Config{
 			Csv: CsvConfig{
@@ -36,7 +36,7 @@ func TestLoadConfig(t *testing.T) {
 		gte := 0.0
 		lt := 100.0
 		output := "0-100"
-		conf, err := loadConfig("config_test.json")
+		conf, err := LoadConfig("config_test.json")
 		require.NoError(t, err, "should return no error if the config can be loaded")
 		assert.Equal(t, Config{
 			Csv: CsvConfig{
@@ -76,3 +76,63 @@ func TestLoadConfig(t *testing.T) {
 		}, *conf, "should return the config properly decoded")
 	})
 }
+
+func TestHeaderIndices(t *testing.T) {
+	t.Run("with unique names", func(t *testing.T) {
+		columns, err := headerIndices([]string{"a", "b"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"a": 0, "b": 1}, columns, "should return the index of each column")
+	})
+	t.Run("with a duplicated name", func(t *testing.T) {
+		columns, err := headerIndices([]string{"a", "b", "a"})
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, columns)
+	})
+	t.Run("with an empty name", func(t *testing.T) {
+		columns, err := headerIndices([]string{"a", ""})
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, columns)
+	})
+}
+
+func TestSamplingConfigIDColumnIndex(t *testing.T) {
+	columns := map[string]int{"a": 0, "b": 1}
+	t.Run("if the name is not specified", func(t *testing.T) {
+		sc := SamplingConfig{IDColumn: 3}
+		i, err := sc.idColumnIndex(columns)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), i, "should return the configured index")
+	})
+	t.Run("if the name is in the header", func(t *testing.T) {
+		sc := SamplingConfig{IDColumn: 3, IDColumnName: "b"}
+		i, err := sc.idColumnIndex(columns)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), i, "should return the index of the column")
+	})
+	t.Run("if the name is not in the header", func(t *testing.T) {
+		sc := SamplingConfig{IDColumnName: "c"}
+		_, err := sc.idColumnIndex(columns)
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestCsvConfigCheck(t *testing.T) {
+	t.Run("with valid options", func(t *testing.T) {
+		for _, cc := range []CsvConfig{
+			CsvConfig{},
+			CsvConfig{Header: true, Rename: map[string]string{"a": "b"}, DropHeader: true},
+			CsvConfig{DetectHeader: true, Rename: map[string]string{"a": "b"}},
+		} {
+			assert.NoError(t, cc.check())
+		}
+	})
+	t.Run("with invalid options", func(t *testing.T) {
+		for _, cc := range []CsvConfig{
+			CsvConfig{Header: true, DetectHeader: true},
+			CsvConfig{Rename: map[string]string{"a": "b"}},
+			CsvConfig{DropHeader: true},
+		} {
+			assert.Error(t, cc.check(), "should return an error for %+v", cc)
+		}
+	})
+}
@@ -0,0 +1,351 @@
+package anon
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// rowContext is what the anonymisations of the values of
+// a record can depend on, besides the values themselves
+type rowContext struct {
+	// Value of the id column
+	subject string
+	// Values of the columns referenced by the actions, by their name
+	references map[string]string
+	// If true, it's the first pass over the input, where the values are
+	// collected (e.g. to compute the quantiles) instead of anonymised
+	firstPass bool
+}
+
+// contextAnonymisation creates the anonymisation of the values of a
+// record given its context, e.g. dateShift shifts all the dates of a
+// subject by the same offset or age computes the age at the date of
+// another column.
+type contextAnonymisation func(ctx rowContext) Anonymisation
+
+// Returns the error of the actions that depend on the context of the
+// records when they are created without it, e.g. with Anonymisations
+func errNeedsContext(name string) error {
+	return fmt.Errorf("%s needs a row context, use Processor", name)
+}
+
+// Returns an anonymisation that fails as it's applied without the context
+// of the record, instead of the one created for it
+func withoutContext(name string) Anonymisation {
+	return func(s string) (string, error) {
+		return s, abortError{errNeedsContext(name)}
+	}
+}
+
+// Returns if the anonymisation of the action depends on the context
+// of the record, either because of the action or of a step of its pipeline
+func (ac *ActionConfig) byContext() bool {
+	switch ac.Name {
+	case "dateShift":
+		return true
+	case "age":
+		return ac.AgeConfig.ReferenceColumn != ""
+	case "bin":
+		return ac.BinConfig.Quantiles > 0
+	case "pipeline":
+		return anyByContext(ac.Pipeline)
+	}
+	return false
+}
+
+func anyByContext(configs []ActionConfig) bool {
+	for _, config := range configs {
+		if config.byContext() {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns if any of the actions, or of the steps of their pipelines, has the name
+func usesAction(configs []ActionConfig, name string) bool {
+	for _, config := range configs {
+		if config.Name == name || config.Name == "pipeline" && usesAction(config.Pipeline, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names of the columns referenced by the actions (or the actions
+// of the output columns) whose values are needed in the context of the records
+func referencedColumns(conf *Config) []string {
+	seen := map[string]bool{}
+	var add func(configs []ActionConfig)
+	add = func(configs []ActionConfig) {
+		for _, config := range configs {
+			if config.Name == "age" && config.AgeConfig.ReferenceColumn != "" {
+				seen[config.AgeConfig.ReferenceColumn] = true
+			}
+			add(config.Pipeline)
+		}
+	}
+	add(conf.Actions)
+	for _, column := range conf.Output {
+		add(column.Actions)
+	}
+	res := make([]string, 0, len(seen))
+	for name := range seen {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Returns the position of each referenced column given
+// the index of each column by its name (e.g. in the header)
+func resolveReferences(names []string, columns map[string]int) (map[string]int, error) {
+	res := make(map[string]int, len(names))
+	for _, name := range names {
+		i, ok := columns[name]
+		if !ok {
+			return nil, fmt.Errorf("reference column %s not found", name)
+		}
+		res[name] = i
+	}
+	return res, nil
+}
+
+// Creates the anonymisation of the action for each context, applying its
+// error policy and attributing its errors to the action with that name
+func (ac *ActionConfig) contextAnonymisation(name string) (contextAnonymisation, error) {
+	if _, err := ac.withErrorPolicy(identity); err != nil {
+		return nil, err
+	}
+	var create contextAnonymisation
+	var err error
+	switch ac.Name {
+	case "dateShift":
+		create, err = dateShift(ac.DateConfig, ac.DateShiftConfig)
+	case "age":
+		create, err = age(ac.DateConfig, ac.AgeConfig, ac.RangeConfig)
+	case "bin":
+		create, err = quantileBins(ac.BinConfig)
+	default:
+		create, err = contextChain(ac.Pipeline, func(i int, step ActionConfig) string {
+			return fmt.Sprintf("pipeline[%d].%s", i, step.Name)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx rowContext) Anonymisation {
+		anon, _ := ac.withErrorPolicy(named(name, create(ctx)))
+		return anon
+	}, nil
+}
+
+// Creates, for each context, an anonymisation that applies the actions in
+// order. Only the actions that depend on the context are created for each
+// context, the errors are attributed to the actions by the given names.
+func contextChain(configs []ActionConfig, name func(i int, config ActionConfig) string) (contextAnonymisation, error) {
+	steps := make([]contextAnonymisation, len(configs))
+	for i, config := range configs {
+		if config.byContext() {
+			var err error
+			if steps[i], err = config.contextAnonymisation(name(i, config)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		anon, err := config.anonymisation(name(i, config))
+		if err != nil {
+			return nil, err
+		}
+		steps[i] = func(rowContext) Anonymisation { return anon }
+	}
+	return func(ctx rowContext) Anonymisation {
+		anons := make([]Anonymisation, len(steps))
+		for i, step := range steps {
+			anons[i] = step(ctx)
+		}
+		return chain(anons)
+	}, nil
+}
+
+// Returns the anonymisations that depend on the context of
+// the record, of either the output columns or the actions
+func configContextAnonymisations(conf *Config) (map[int]contextAnonymisation, error) {
+	if len(conf.Output) > 0 {
+		return outputContextAnonymisations(conf.Output)
+	}
+	return contextAnonymisations(conf.Actions)
+}
+
+// Returns the anonymisations of the actions that depend on
+// the context of the record, by the position of the action
+func contextAnonymisations(configs []ActionConfig) (map[int]contextAnonymisation, error) {
+	res := map[int]contextAnonymisation{}
+	for i, config := range configs {
+		if !config.byContext() {
+			continue
+		}
+		var err error
+		if res[i], err = config.contextAnonymisation(config.Name); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Returns the anonymisations of the output columns that depend
+// on the context of the record, by the position of the column
+func outputContextAnonymisations(output []OutputColumn) (map[int]contextAnonymisation, error) {
+	res := map[int]contextAnonymisation{}
+	for i, column := range output {
+		if !anyByContext(column.Actions) {
+			continue
+		}
+		var err error
+		if res[i], err = contextChain(column.Actions, func(_ int, config ActionConfig) string { return config.Name }); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Given the actions config and the index of each column in the header,
+// returns the anonymisations that depend on the context by the position
+// of their column. The columns must have been checked with byColumn.
+func contextByColumn(configs []ActionConfig, byContext map[int]contextAnonymisation, columns map[string]int) map[int]contextAnonymisation {
+	res := make(map[int]contextAnonymisation, len(byContext))
+	for i, create := range byContext {
+		res[columns[configs[i].Column]] = create
+	}
+	return res
+}
+
+// Returns the anonymisations with the ones that
+// depend on the context created for that context
+func forContext(anons []Anonymisation, byContext map[int]contextAnonymisation, ctx rowContext) []Anonymisation {
+	if len(byContext) == 0 {
+		return anons
+	}
+	res := make([]Anonymisation, len(anons))
+	copy(res, anons)
+	for i, create := range byContext {
+		if i < len(res) {
+			res[i] = create(ctx)
+		}
+	}
+	return res
+}
+
+// Given the format of the dates and the window of days, returns a function
+// that, for each subject, shifts its dates by the same number of days, derived
+// from the subject and the key. The intervals between the dates of a subject
+// are kept, and the shifted dates are written with the format they were read.
+func dateShift(dc DateConfig, conf DateShiftConfig) (contextAnonymisation, error) {
+	layouts := dc.layouts()
+	if err := checkLayouts(layouts); err != nil {
+		return nil, err
+	}
+	if conf.MinDays < 0 || conf.MaxDays <= 0 || conf.MinDays > conf.MaxDays {
+		return nil, errors.New("the window of days must have 0 <= minDays <= maxDays and maxDays > 0")
+	}
+	key, err := conf.key()
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx rowContext) Anonymisation {
+		days := shiftDays(key, ctx.subject, conf.MinDays, conf.MaxDays)
+		return func(s string) (string, error) {
+			t, layout, err := parseDate(s, layouts)
+			if err != nil {
+				return s, err
+			}
+			return t.AddDate(0, 0, days).Format(layout), nil
+		}
+	}, nil
+}
+
+// Returns the number of days (between min and max, backward or forward) the
+// dates of the subject are shifted by, derived from the HMAC of the subject
+func shiftDays(key []byte, subject string, min int, max int) int {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, subject)
+	n := binary.BigEndian.Uint64(mac.Sum(nil))
+	span := uint64(max - min + 1)
+	days := min + int(n%span)
+	if (n/span)%2 == 1 {
+		return -days
+	}
+	return days
+}
+
+// Layout of the reference date of the age action
+const referenceDateLayout = "2006-01-02"
+
+// Given the format of the dates of birth, returns a function that computes
+// the age in years at either a fixed reference date or the date in another
+// column of the record (with the same format). If there are ranges, the age
+// is summarised into them as in the ranges action.
+func age(dc DateConfig, conf AgeConfig, bands []RangeConfig) (contextAnonymisation, error) {
+	layouts := dc.layouts()
+	if err := checkLayouts(layouts); err != nil {
+		return nil, err
+	}
+	var reference time.Time
+	switch {
+	case conf.ReferenceDate != "" && conf.ReferenceColumn != "":
+		return nil, errors.New("you can only specify one of referenceDate and referenceColumn")
+	case conf.ReferenceDate != "":
+		var err error
+		if reference, err = time.Parse(referenceDateLayout, conf.ReferenceDate); err != nil {
+			return nil, fmt.Errorf("the reference date must have the format %s", referenceDateLayout)
+		}
+	case conf.ReferenceColumn == "":
+		return nil, errors.New("you need to specify one of referenceDate and referenceColumn")
+	}
+	var band Anonymisation = identity
+	if len(bands) > 0 {
+		var err error
+		if band, err = ranges(bands); err != nil {
+			return nil, err
+		}
+	}
+	return func(ctx rowContext) Anonymisation {
+		return func(s string) (string, error) {
+			dob, _, err := parseDate(s, layouts)
+			if err != nil {
+				return s, err
+			}
+			at := reference
+			if conf.ReferenceColumn != "" {
+				value, ok := ctx.references[conf.ReferenceColumn]
+				if at, _, err = parseDate(value, layouts); !ok || err != nil {
+					return s, fmt.Errorf("can't parse the reference date in column %s", conf.ReferenceColumn)
+				}
+			}
+			years, err := yearsBetween(dob, at)
+			if err != nil {
+				return s, err
+			}
+			return band(strconv.Itoa(years))
+		}
+	}, nil
+}
+
+// Returns the number of full years from one date to another
+func yearsBetween(from time.Time, to time.Time) (int, error) {
+	if from.After(to) {
+		return 0, errors.New("the date of birth is after the reference date")
+	}
+	years := to.Year() - from.Year()
+	if to.Month() < from.Month() || to.Month() == from.Month() && to.Day() < from.Day() {
+		years--
+	}
+	return years, nil
+}
@@ -0,0 +1,290 @@
+package anon
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDateShift(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", "secret")
+	defer os.Unsetenv("ANON_TEST_KEY")
+	shiftConf := DateShiftConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, MinDays: 1, MaxDays: 30}
+	t.Run("with the dates of a subject", func(t *testing.T) {
+		create, err := dateShift(DateConfig{Format: "2006-01-02"}, shiftConf)
+		require.NoError(t, err)
+		f := create(rowContext{subject: "patient-1"})
+		admission, err := f("2020-03-01")
+		assert.NoError(t, err)
+		discharge, _ := f("2020-03-11")
+		assert.NotEqual(t, "2020-03-01", admission, "should shift the dates")
+		a, _ := time.Parse("2006-01-02", admission)
+		d, _ := time.Parse("2006-01-02", discharge)
+		assert.Equal(t, 10*24*time.Hour, d.Sub(a), "should keep the interval between them")
+		again, _ := create(rowContext{subject: "patient-1"})("2020-03-01")
+		assert.Equal(t, admission, again, "should shift them by the same offset every time")
+	})
+	t.Run("with several formats", func(t *testing.T) {
+		create, _ := dateShift(DateConfig{Format: "2006-01-02", Formats: []string{"02/01/2006 15:04"}}, shiftConf)
+		f := create(rowContext{subject: "patient-1"})
+		shifted, _ := f("2020-03-01")
+		res, err := f("01/03/2020 10:30")
+		assert.NoError(t, err)
+		s, _ := time.Parse("2006-01-02", shifted)
+		assert.Equal(t, s.Format("02/01/2006")+" 10:30", res, "should write the date with the format it was read")
+		res, err = f("March 1st")
+		assert.Error(t, err, "should return an error if the date can't be parsed")
+		assert.Equal(t, "March 1st", res, "should return the input unchanged")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []DateShiftConfig{
+			DateShiftConfig{MinDays: 1, MaxDays: 30},
+			DateShiftConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}},
+			DateShiftConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, MinDays: 10, MaxDays: 5},
+			DateShiftConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, MinDays: -1, MaxDays: 5},
+		} {
+			_, err := dateShift(DateConfig{Format: "2006-01-02"}, conf)
+			assert.Error(t, err, "should return an error for %+v", conf)
+		}
+		_, err := dateShift(DateConfig{}, shiftConf)
+		assert.Error(t, err, "should return an error without a format")
+	})
+}
+
+func TestShiftDaysProperties(t *testing.T) {
+	properties := gopter.NewProperties(nil)
+	properties.Property("the offset is within the window", prop.ForAll(
+		func(subject string, min int, width int) bool {
+			days := shiftDays([]byte("key"), subject, min, min+width)
+			if days < 0 {
+				days = -days
+			}
+			return days >= min && days <= min+width
+		},
+		gen.AnyString(),
+		gen.IntRange(0, 1000),
+		gen.IntRange(0, 1000),
+	))
+	properties.Property("the offset only depends on the key and the subject", prop.ForAll(
+		func(subject string) bool {
+			return shiftDays([]byte("key"), subject, 1, 365) == shiftDays([]byte("key"), subject, 1, 365)
+		},
+		gen.AnyString(),
+	))
+	properties.TestingRun(t)
+}
+
+func TestContextAnonymisations(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", "secret")
+	defer os.Unsetenv("ANON_TEST_KEY")
+	shift := ActionConfig{Name: "dateShift", DateConfig: DateConfig{Format: "20060102"}, DateShiftConfig: DateShiftConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, MaxDays: 30}}
+	t.Run("with actions that depend on the context", func(t *testing.T) {
+		byContext, err := contextAnonymisations([]ActionConfig{
+			ActionConfig{Name: "nothing"},
+			shift,
+			ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{shift, ActionConfig{Name: "year", DateConfig: DateConfig{Format: "20060102"}}}},
+		})
+		require.NoError(t, err)
+		assert.Len(t, byContext, 2, "should only return the actions that depend on the context")
+		assert.NotContains(t, byContext, 0)
+		_, err = byContext[2](rowContext{subject: "a"})("fail")
+		var ae actionError
+		require.True(t, errors.As(err, &ae), "should return an actionError")
+		assert.Equal(t, "pipeline[0].dateShift", ae.action, "should attribute the error to the step")
+	})
+	t.Run("with an error policy", func(t *testing.T) {
+		withPolicy := shift
+		withPolicy.OnError = "null"
+		byContext, err := contextAnonymisations([]ActionConfig{withPolicy})
+		require.NoError(t, err)
+		res, err := byContext[0](rowContext{subject: "a"})("fail")
+		assert.True(t, errors.As(err, &handledError{}), "should return the error as handled")
+		assert.Equal(t, "", res, "should apply the policy")
+		withPolicy.OnError = "invalid"
+		_, err = contextAnonymisations([]ActionConfig{withPolicy})
+		assert.Error(t, err, "should return an error if the policy is invalid")
+	})
+}
+
+func TestForContext(t *testing.T) {
+	anons := []Anonymisation{identity, identity}
+	byContext := map[int]contextAnonymisation{1: func(ctx rowContext) Anonymisation {
+		return func(s string) (string, error) { return ctx.subject + s, nil }
+	}}
+	res := forContext(anons, byContext, rowContext{subject: "a"})
+	assertAnonymisationFunction(t, identity, res[0], "b")
+	v, _ := res[1]("b")
+	assert.Equal(t, "ab", v, "should create the anonymisation for the context")
+	assert.Len(t, forContext(anons, nil, rowContext{subject: "a"}), 2, "should return the anonymisations unchanged if none depends on the context")
+	v, _ = anons[1]("b")
+	assert.Equal(t, "b", v, "should not modify the anonymisations")
+}
+
+func TestProcessorProcessDateShift(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", "secret")
+	defer os.Unsetenv("ANON_TEST_KEY")
+	shift := ActionConfig{Name: "dateShift", Column: "date", DateConfig: DateConfig{Format: "2006-01-02"}, DateShiftConfig: DateShiftConfig{KeyConfig: KeyConfig{KeyEnv: "ANON_TEST_KEY"}, MinDays: 1, MaxDays: 1000}}
+	input := "date,patient\n2020-01-01,a\n2020-01-01,b\n2020-01-11,a\n"
+	expected := func(subject string, date string) string {
+		create, _ := dateShift(shift.DateConfig, shift.DateShiftConfig)
+		res, _ := create(rowContext{subject: subject})(date)
+		return res
+	}
+	t.Run("when the csv has a header", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Sampling: SamplingConfig{IDColumnName: "patient"}, Actions: []ActionConfig{shift}}
+		p, err := NewProcessor(conf, Options{Workers: 2})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(strings.NewReader(input), &out))
+		assert.Equal(t, "date,patient\n"+
+			expected("a", "2020-01-01")+",a\n"+
+			expected("b", "2020-01-01")+",b\n"+
+			expected("a", "2020-01-11")+",a\n", out.String(), "should shift the dates of each patient by its offset")
+	})
+	t.Run("with output columns", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{Csv: CsvConfig{Delimiter: ","}, Sampling: SamplingConfig{IDColumn: 1}, Output: []OutputColumn{
+			OutputColumn{SourceIndex: 0, Actions: []ActionConfig{ActionConfig{Name: "dateShift", DateConfig: shift.DateConfig, DateShiftConfig: shift.DateShiftConfig}}},
+		}}
+		p, err := NewProcessor(conf, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(strings.NewReader("2020-01-01,b\n"), &out))
+		assert.Equal(t, expected("b", "2020-01-01")+"\n", out.String(), "should shift the dates by the offset of the patient")
+	})
+	t.Run("with JSON Lines without an id field", func(t *testing.T) {
+		_, err := NewProcessor(&Config{Format: FormatJSONL, Actions: []ActionConfig{shift}}, Options{})
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestAge(t *testing.T) {
+	dc := DateConfig{Format: "2006-01-02", Formats: []string{"02/01/2006"}}
+	t.Run("with a reference date", func(t *testing.T) {
+		create, err := age(dc, AgeConfig{ReferenceDate: "2020-06-15"}, nil)
+		require.NoError(t, err)
+		f := create(rowContext{})
+		for dob, expected := range map[string]string{"1980-06-15": "40", "1980-06-16": "39", "16/06/1980": "39", "2020-06-15": "0"} {
+			res, err := f(dob)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, res, "should return the age of %s", dob)
+		}
+		_, err = f("2021-01-01")
+		assert.Error(t, err, "should return an error if the date of birth is after the reference date")
+		res, err := f("unknown")
+		assert.Error(t, err, "should return an error if the date of birth can't be parsed")
+		assert.Equal(t, "unknown", res, "should return the input unchanged")
+	})
+	t.Run("with a reference column", func(t *testing.T) {
+		create, err := age(dc, AgeConfig{ReferenceColumn: "admission"}, nil)
+		require.NoError(t, err)
+		res, err := create(rowContext{references: map[string]string{"admission": "01/01/2000"}})("1990-01-02")
+		assert.NoError(t, err)
+		assert.Equal(t, "9", res, "should return the age at the date of the column")
+		_, err = create(rowContext{references: map[string]string{"admission": "never"}})("1990-01-02")
+		assert.Error(t, err, "should return an error if the reference date can't be parsed")
+		_, err = create(rowContext{})("1990-01-02")
+		assert.Error(t, err, "should return an error if the reference date is missing")
+	})
+	t.Run("with ranges", func(t *testing.T) {
+		lt, gte, under, over := 18.0, 18.0, "<18", "18+"
+		create, err := age(dc, AgeConfig{ReferenceDate: "2020-01-01"}, []RangeConfig{RangeConfig{Lt: &lt, Output: &under}, RangeConfig{Gte: &gte, Output: &over}})
+		require.NoError(t, err)
+		res, _ := create(rowContext{})("2010-05-05")
+		assert.Equal(t, "<18", res, "should summarise the age into the ranges")
+		res, _ = create(rowContext{})("1970-05-05")
+		assert.Equal(t, "18+", res, "should summarise the age into the ranges")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []AgeConfig{
+			AgeConfig{},
+			AgeConfig{ReferenceDate: "2020-01-01", ReferenceColumn: "admission"},
+			AgeConfig{ReferenceDate: "01/01/2020"},
+		} {
+			_, err := age(dc, conf, nil)
+			assert.Error(t, err, "should return an error for %+v", conf)
+		}
+		_, err := age(dc, AgeConfig{ReferenceDate: "2020-01-01"}, []RangeConfig{RangeConfig{}})
+		assert.Error(t, err, "should return an error if the ranges are invalid")
+	})
+}
+
+func TestYearsBetweenProperties(t *testing.T) {
+	properties := gopter.NewProperties(nil)
+	properties.Property("the age is the number of full years", prop.ForAll(
+		func(dob time.Time, days int) bool {
+			at := dob.AddDate(0, 0, days)
+			years, err := yearsBetween(dob, at)
+			return err == nil && !dob.AddDate(years, 0, 0).After(at) && dob.AddDate(years+1, 0, 0).After(at)
+		},
+		gen.TimeRange(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), 100*365*24*time.Hour),
+		gen.IntRange(0, 120*366),
+	))
+	properties.TestingRun(t)
+}
+
+func TestReferencedColumns(t *testing.T) {
+	ref := func(column string) ActionConfig {
+		return ActionConfig{Name: "age", AgeConfig: AgeConfig{ReferenceColumn: column}}
+	}
+	conf := &Config{Actions: []ActionConfig{
+		ref("b"),
+		ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{ref("a")}},
+		ref("b"),
+		ActionConfig{Name: "age", AgeConfig: AgeConfig{ReferenceDate: "2020-01-01"}},
+	}}
+	assert.Equal(t, []string{"a", "b"}, referencedColumns(conf), "should return the columns referenced by the actions and their pipelines")
+	assert.Equal(t, []string{"c"}, referencedColumns(&Config{Output: []OutputColumn{OutputColumn{Actions: []ActionConfig{ref("c")}}}}), "should return the columns referenced by the output columns")
+	_, err := resolveReferences([]string{"d"}, map[string]int{"a": 0})
+	assert.Error(t, err, "should return an error if a column is not found")
+}
+
+func TestProcessorProcessAge(t *testing.T) {
+	ageAt := ActionConfig{Name: "age", Column: "dob", DateConfig: DateConfig{Format: "2006-01-02"}, AgeConfig: AgeConfig{ReferenceColumn: "admission"}}
+	t.Run("when the csv has a header", func(t *testing.T) {
+		var out bytes.Buffer
+		p, err := NewProcessor(&Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Actions: []ActionConfig{ageAt}}, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(strings.NewReader("admission,dob\n2020-01-01,1980-06-01\n2010-01-01,1980-06-01\n"), &out))
+		assert.Equal(t, "admission,dob\n2020-01-01,39\n2010-01-01,29\n", out.String(), "should compute the age at the date of the reference column")
+	})
+	t.Run("with output columns", func(t *testing.T) {
+		var out bytes.Buffer
+		conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Output: []OutputColumn{
+			OutputColumn{Source: "dob", Name: "age", Actions: []ActionConfig{ActionConfig{Name: "age", DateConfig: ageAt.DateConfig, AgeConfig: ageAt.AgeConfig}}},
+		}}
+		p, err := NewProcessor(conf, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(strings.NewReader("admission,dob\n2020-01-01,1980-06-01\n"), &out))
+		assert.Equal(t, "age\n39\n", out.String(), "should compute the age even if the reference column isn't written")
+	})
+	t.Run("when the reference column is not in the header", func(t *testing.T) {
+		var out bytes.Buffer
+		p, _ := NewProcessor(&Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Actions: []ActionConfig{ageAt}}, Options{})
+		assert.Error(t, p.Process(strings.NewReader("date,dob\n2020-01-01,1980-06-01\n"), &out), "should return an error")
+	})
+	t.Run("when the csv doesn't have a header", func(t *testing.T) {
+		var out bytes.Buffer
+		noColumn := ageAt
+		noColumn.Column = ""
+		p, _ := NewProcessor(&Config{Csv: CsvConfig{Delimiter: ","}, Actions: []ActionConfig{noColumn}}, Options{})
+		assert.Error(t, p.Process(strings.NewReader("1980-06-01,2020-01-01\n"), &out), "should return an error")
+	})
+	t.Run("with JSON Lines", func(t *testing.T) {
+		var out bytes.Buffer
+		ageAt := ageAt
+		ageAt.AgeConfig.ReferenceColumn = "visit.date"
+		p, err := NewProcessor(&Config{Format: FormatJSONL, Actions: []ActionConfig{ageAt}}, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(strings.NewReader(`{"dob":"1980-06-01","visit":{"date":"2020-01-01"}}`+"\n"), &out))
+		assert.Equal(t, `{"dob":"39","visit":{"date":"2020-01-01"}}`+"\n", out.String(), "should compute the age at the date of the reference field")
+	})
+}
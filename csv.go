@@ -0,0 +1,220 @@
+package anon
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// csvRecord is a record of a csv file
+type csvRecord []string
+
+func (r csvRecord) anonymise(anons []Anonymisation) error {
+	_, err := Anonymise(r, anons)
+	return err
+}
+
+func (r csvRecord) eachValue(f func(column int, value string)) {
+	for i, v := range r {
+		f(i, v)
+	}
+}
+
+// Number of rows read after the first one to detect if it's a header
+const headerDetectionRows = 20
+
+// csvReader reads the records of a csv file, sampling
+// them by the value of the id column
+type csvReader struct {
+	r        *csv.Reader
+	sampling SamplingConfig
+	idColumn uint32
+	// Index of each column in the header, nil
+	// if it doesn't have one or it's not resolved
+	header map[string]int
+	// Position of the columns referenced by the actions, by their name
+	references map[string]int
+	// Position of the input column of each output column,
+	// if nil the records are returned with all their columns
+	columns []int
+	// Rows already read from r that haven't been returned yet
+	pending []csvRow
+}
+
+// csvRow is a row read from the csv, with the error reading it
+type csvRow struct {
+	values []string
+	line   int
+	err    error
+}
+
+// Records with a wrong number of fields are returned as items with an error
+func (cr *csvReader) read() (item, error) {
+	row := cr.next()
+	if pe, ok := row.err.(*csv.ParseError); ok && pe.Err == csv.ErrFieldCount {
+		return item{line: pe.StartLine, err: row.err}, nil
+	} else if row.err != nil {
+		return item{}, row.err
+	} else if int64(cr.idColumn) >= int64(len(row.values)) {
+		return item{}, fmt.Errorf("id column (%d) out of range, record has %d columns", cr.idColumn, len(row.values))
+	}
+	rec, err := cr.project(row.values)
+	if err != nil {
+		return item{line: row.line, err: err}, nil
+	}
+	subject := row.values[cr.idColumn]
+	return item{line: row.line, record: rec, sampled: sample(subject, cr.sampling), context: cr.context(subject, row.values)}, nil
+}
+
+// Returns the context of the row, with the values of the referenced columns
+func (cr *csvReader) context(subject string, values []string) rowContext {
+	ctx := rowContext{subject: subject}
+	if len(cr.references) > 0 {
+		ctx.references = make(map[string]string, len(cr.references))
+		for name, i := range cr.references {
+			if i < len(values) {
+				ctx.references[name] = values[i]
+			}
+		}
+	}
+	return ctx
+}
+
+// Returns the record with the values of the output columns
+func (cr *csvReader) project(values []string) (csvRecord, error) {
+	if cr.columns == nil {
+		return csvRecord(values), nil
+	}
+	rec := make(csvRecord, len(cr.columns))
+	for i, j := range cr.columns {
+		if j >= len(values) {
+			return nil, fmt.Errorf("source column (%d) of output column %d out of range, record has %d columns", j, i, len(values))
+		}
+		rec[i] = values[j]
+	}
+	return rec, nil
+}
+
+// Returns the next row, either a pending one or read from the csv
+func (cr *csvReader) next() csvRow {
+	if len(cr.pending) > 0 {
+		row := cr.pending[0]
+		cr.pending = cr.pending[1:]
+		return row
+	}
+	return cr.readRow()
+}
+
+// Reads a row from the csv
+func (cr *csvReader) readRow() csvRow {
+	values, err := cr.r.Read()
+	row := csvRow{values: values, err: err}
+	if err == nil {
+		row.line, _ = cr.r.FieldPos(0)
+	}
+	return row
+}
+
+// Reads the first rows of the csv (that are still returned by next)
+// and returns if the first one looks like a header.
+func (cr *csvReader) detectHeader() (bool, error) {
+	for len(cr.pending) <= headerDetectionRows {
+		row := cr.readRow()
+		if row.err == io.EOF {
+			break
+		} else if _, ok := row.err.(*csv.ParseError); row.err != nil && !ok {
+			return false, row.err
+		}
+		cr.pending = append(cr.pending, row)
+	}
+	if len(cr.pending) == 0 {
+		return false, io.EOF
+	} else if cr.pending[0].err != nil {
+		return false, nil
+	}
+	var rows [][]string
+	for _, row := range cr.pending[1:] {
+		if row.err == nil {
+			rows = append(rows, row.values)
+		}
+	}
+	return looksLikeHeader(cr.pending[0].values, rows), nil
+}
+
+// Returns if the first row looks like the header of the rows that follow
+// it. Its values must be distinct, non empty and not numbers, and then
+// each column votes: numeric columns vote for a header, as well as columns
+// whose values have a fixed length that the first row doesn't have.
+func looksLikeHeader(first []string, rows [][]string) bool {
+	seen := map[string]bool{}
+	for _, name := range first {
+		if _, err := strconv.ParseFloat(name, 64); name == "" || seen[name] || err == nil {
+			return false
+		}
+		seen[name] = true
+	}
+	votes := 0
+	for i, name := range first {
+		numeric, length := true, -1
+		values := 0
+		for _, row := range rows {
+			if i >= len(row) {
+				continue
+			}
+			values++
+			if _, err := strconv.ParseFloat(row[i], 64); err != nil {
+				numeric = false
+			}
+			if length == -1 || length == len(row[i]) {
+				length = len(row[i])
+			} else {
+				length = -2
+			}
+		}
+		switch {
+		case values == 0:
+		case numeric:
+			votes++
+		case length >= 0 && length != len(name):
+			votes++
+		case length >= 0:
+			votes--
+		}
+	}
+	return votes > 0 || len(rows) == 0
+}
+
+// csvWriter writes the records to a csv file
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) write(rec record) error {
+	return cw.w.Write(rec.(csvRecord))
+}
+
+func (cw *csvWriter) flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) close() error {
+	return cw.flush()
+}
+
+func newReader(in io.Reader, conf CsvConfig) *csv.Reader {
+	reader := csv.NewReader(in)
+	if conf.Delimiter != "" {
+		reader.Comma = []rune(conf.Delimiter)[0]
+	}
+	return reader
+}
+
+func newWriter(out io.Writer, conf CsvConfig) *csv.Writer {
+	writer := csv.NewWriter(out)
+	if conf.Delimiter != "" {
+		writer.Comma = []rune(conf.Delimiter)[0]
+	}
+	return writer
+}
@@ -0,0 +1,49 @@
+package anon
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLooksLikeHeader(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		first    []string
+		rows     [][]string
+		expected bool
+	}{
+		{"with numeric columns", []string{"id", "amount"}, [][]string{{"1", "2.5"}, {"2", "3"}}, true},
+		{"with fixed length columns", []string{"postcode", "date"}, [][]string{{"W1W", "20020202"}, {"E1", "20030303"}}, true},
+		{"with a numeric first row", []string{"1", "2.5"}, [][]string{{"2", "3"}}, false},
+		{"with the same length as the rows", []string{"abc", "def"}, [][]string{{"ghi", "jkl"}}, false},
+		{"with an empty value", []string{"id", ""}, [][]string{{"1", "2"}}, false},
+		{"with a duplicated value", []string{"id", "id"}, [][]string{{"1", "2"}}, false},
+		{"without more rows", []string{"id", "name"}, nil, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, looksLikeHeader(c.first, c.rows))
+		})
+	}
+}
+
+func TestCsvReaderDetectHeader(t *testing.T) {
+	t.Run("keeps the rows read", func(t *testing.T) {
+		cr := &csvReader{r: csv.NewReader(strings.NewReader("id,name\n1,a\n2,b\n"))}
+		header, err := cr.detectHeader()
+		assert.NoError(t, err)
+		assert.True(t, header, "should detect the header")
+		for _, expected := range []csvRow{{[]string{"id", "name"}, 1, nil}, {[]string{"1", "a"}, 2, nil}, {[]string{"2", "b"}, 3, nil}} {
+			assert.Equal(t, expected, cr.next(), "should return the rows read")
+		}
+		assert.Equal(t, io.EOF, cr.next().err)
+	})
+	t.Run("with an empty csv", func(t *testing.T) {
+		cr := &csvReader{r: csv.NewReader(strings.NewReader(""))}
+		_, err := cr.detectHeader()
+		assert.Equal(t, io.EOF, err)
+	})
+}
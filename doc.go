@@ -0,0 +1,32 @@
+/*
+Package anon anonymises delimited files (e.g. csv) according to a config,
+so the output can be used where sensitive information can't be exposed.
+
+A Config defines how to read the file, how to sample its records and the
+action (ActionConfig) applied to each of its columns. It can be loaded from
+a JSON file with LoadConfig or built directly. A Processor applies the
+config to an input, streaming the records from an io.Reader to an
+io.Writer:
+
+	conf, err := anon.LoadConfig("config.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := anon.NewProcessor(conf, anon.Options{Workers: 4})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := p.Process(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+
+Each action is an Anonymisation, a function that transforms a value. They
+can be created from their config with ActionConfig.Create and applied to a
+single record with Anonymise. The actions that depend on the rest of the
+record or of the input (dateShift, age at a reference column and bin with
+quantiles) can only be applied by a Processor.
+
+The anon command (github.com/intenthq/anon/cmd/anon) is a thin command
+line interface on top of this package.
+*/
+package anon
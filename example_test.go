@@ -0,0 +1,90 @@
+package anon_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/intenthq/anon"
+)
+
+func ExampleProcessor() {
+	conf := &anon.Config{
+		Csv: anon.CsvConfig{Delimiter: ",", Header: true},
+		Actions: []anon.ActionConfig{
+			{Name: "outcode", Column: "postcode"},
+			{Name: "year", Column: "dob", DateConfig: anon.DateConfig{Format: "2006-01-02"}},
+		},
+	}
+	p, err := anon.NewProcessor(conf, anon.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	in := strings.NewReader("id,postcode,dob\n1,W1W 8BE,1980-05-17\n2,EC1V 9LT,1975-11-02\n")
+	if err := p.Process(in, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// id,postcode,dob
+	// 1,W1W,1980
+	// 2,EC1V,1975
+}
+
+func ExampleActionConfig_Create() {
+	ac := anon.ActionConfig{Name: "year", DateConfig: anon.DateConfig{Format: "20060102"}}
+	year, err := ac.Create()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(year("19800517"))
+	// Output: 1980 <nil>
+}
+
+func ExampleAnonymise() {
+	anons, err := anon.Anonymisations([]anon.ActionConfig{{Name: "nothing"}, {Name: "outcode"}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	record, err := anon.Anonymise([]string{"John", "W1W 8BE"}, anons)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(record)
+	// Output: [John W1W]
+}
+
+func ExampleRegisterAction() {
+	// mask replaces all the characters of the value except the last ones
+	anon.RegisterAction("mask", func(ac *anon.ActionConfig) (anon.Anonymisation, error) {
+		var conf struct {
+			Keep int
+		}
+		if err := ac.DecodeConfig(&conf); err != nil {
+			return nil, err
+		}
+		return func(s string) (string, error) {
+			if len(s) <= conf.Keep {
+				return s, nil
+			}
+			return strings.Repeat("*", len(s)-conf.Keep) + s[len(s)-conf.Keep:], nil
+		}, nil
+	})
+
+	conf, err := anon.ReadConfig(strings.NewReader(`{
+		"actions": [{"name": "mask", "config": {"keep": 4}}]
+	}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	anons, err := anon.Anonymisations(conf.Actions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	record, err := anon.Anonymise([]string{"4111111111111111"}, anons)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(record)
+	// Output: [************1111]
+}
@@ -0,0 +1,196 @@
+package anon
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Minimum number of possible values of an input (radix^length),
+// as required by NIST SP 800-38G
+const ff1MinDomain = 1000000
+
+// ff1 implements the FF1 format-preserving encryption mode
+// described in NIST SP 800-38G, using AES as the block cipher.
+type ff1 struct {
+	block    cipher.Block
+	tweak    []byte
+	alphabet []rune
+	indices  map[rune]int
+	radix    *big.Int
+}
+
+// Creates a new FF1 cipher given an AES key (16, 24 or 32 bytes),
+// a tweak and the alphabet of the values that will be encrypted.
+func newFF1(key []byte, tweak []byte, alphabet string) (*ff1, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(alphabet)
+	if len(runes) < 2 || len(runes) > 1<<16 {
+		return nil, errors.New("the fpe alphabet must have between 2 and 65536 characters")
+	}
+	indices := make(map[rune]int, len(runes))
+	for i, r := range runes {
+		if _, ok := indices[r]; ok {
+			return nil, fmt.Errorf("character %q is duplicated in the fpe alphabet", r)
+		}
+		indices[r] = i
+	}
+	return &ff1{
+		block:    block,
+		tweak:    tweak,
+		alphabet: runes,
+		indices:  indices,
+		radix:    big.NewInt(int64(len(runes))),
+	}, nil
+}
+
+func (f *ff1) encrypt(s string) (string, error) {
+	return f.cipher(s, true)
+}
+
+func (f *ff1) decrypt(s string) (string, error) {
+	return f.cipher(s, false)
+}
+
+// Converts the string into its numerals in the alphabet
+func (f *ff1) numerals(s string) ([]int, error) {
+	runes := []rune(s)
+	res := make([]int, len(runes))
+	for i, r := range runes {
+		n, ok := f.indices[r]
+		if !ok {
+			return nil, errors.New("value contains characters that are not in the fpe alphabet")
+		}
+		res[i] = n
+	}
+	return res, nil
+}
+
+// The number represented by the numerals, most significant first
+func (f *ff1) num(x []int) *big.Int {
+	res := new(big.Int)
+	for _, n := range x {
+		res.Mul(res, f.radix)
+		res.Add(res, big.NewInt(int64(n)))
+	}
+	return res
+}
+
+// The m numerals that represent the number x, most significant first
+func (f *ff1) str(x *big.Int, m int) []int {
+	res := make([]int, m)
+	x = new(big.Int).Set(x)
+	mod := new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		x.DivMod(x, f.radix, mod)
+		res[i] = int(mod.Int64())
+	}
+	return res
+}
+
+// Encrypts (or decrypts) the string following the algorithms 7 and 8
+// of NIST SP 800-38G
+func (f *ff1) cipher(s string, encrypt bool) (string, error) {
+	x, err := f.numerals(s)
+	if err != nil {
+		return s, err
+	}
+	n := len(x)
+	if new(big.Int).Exp(f.radix, big.NewInt(int64(n)), nil).Cmp(big.NewInt(ff1MinDomain)) < 0 {
+		return s, fmt.Errorf("value too short to be encrypted with fpe, it needs at least %d possible values", ff1MinDomain)
+	}
+	u := n / 2
+	v := n - u
+	a, b := x[:u], x[u:]
+	// bytes needed to represent radix^v - 1
+	bl := (new(big.Int).Sub(new(big.Int).Exp(f.radix, big.NewInt(int64(v)), nil), big.NewInt(1)).BitLen() + 7) / 8
+	d := 4*((bl+3)/4) + 4
+
+	p := make([]byte, 16)
+	p[0], p[1], p[2] = 1, 2, 1
+	radix := uint32(f.radix.Int64())
+	p[3], p[4], p[5] = byte(radix>>16), byte(radix>>8), byte(radix)
+	p[6], p[7] = 10, byte(u)
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(len(f.tweak)))
+
+	t := len(f.tweak)
+	q := make([]byte, t+((-t-bl-1)%16+16)%16+1+bl)
+	copy(q, f.tweak)
+
+	modU := new(big.Int).Exp(f.radix, big.NewInt(int64(u)), nil)
+	modV := new(big.Int).Exp(f.radix, big.NewInt(int64(v)), nil)
+	for j := 0; j < 10; j++ {
+		i := j
+		if !encrypt {
+			i = 9 - j
+		}
+		// the half that is used as input to the round function
+		in := b
+		if !encrypt {
+			in = a
+		}
+		q[len(q)-bl-1] = byte(i)
+		numIn := f.num(in).Bytes()
+		for k := len(q) - bl; k < len(q); k++ {
+			q[k] = 0
+		}
+		copy(q[len(q)-len(numIn):], numIn)
+		y := new(big.Int).SetBytes(f.roundOutput(p, q, d))
+
+		m, mod := u, modU
+		if i%2 == 1 {
+			m, mod = v, modV
+		}
+		c := new(big.Int)
+		if encrypt {
+			c.Add(f.num(a), y)
+		} else {
+			c.Sub(f.num(b), y)
+		}
+		c.Mod(c, mod)
+		if encrypt {
+			a, b = b, f.str(c, m)
+		} else {
+			a, b = f.str(c, m), a
+		}
+	}
+
+	res := make([]rune, 0, n)
+	for _, i := range append(append([]int{}, a...), b...) {
+		res = append(res, f.alphabet[i])
+	}
+	return string(res), nil
+}
+
+// Returns the first d bytes of the output of the round
+// function for P || Q (steps 6.ii and 6.iii)
+func (f *ff1) roundOutput(p []byte, q []byte, d int) []byte {
+	// PRF: CBC-MAC with a zero IV
+	r := make([]byte, aes.BlockSize)
+	for _, in := range [][]byte{p, q} {
+		for k := 0; k < len(in); k += aes.BlockSize {
+			for l := 0; l < aes.BlockSize; l++ {
+				r[l] ^= in[k+l]
+			}
+			f.block.Encrypt(r, r)
+		}
+	}
+	s := append([]byte{}, r...)
+	for j := 1; len(s) < d; j++ {
+		block := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(block[8:], uint64(j))
+		for l := range block {
+			block[l] ^= r[l]
+		}
+		f.block.Encrypt(block, block)
+		s = append(s, block...)
+	}
+	return s[:d]
+}
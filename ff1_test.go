@@ -0,0 +1,101 @@
+package anon
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Samples from https://csrc.nist.gov/CSRC/media/Projects/Cryptographic-Standards-and-Guidelines/documents/examples/FF1samples.pdf
+func TestFF1(t *testing.T) {
+	key128, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	key256, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94")
+	digits := "0123456789"
+	alphanumeric := "0123456789abcdefghijklmnopqrstuvwxyz"
+	tweak, _ := hex.DecodeString("39383736353433323130")
+	tweak36, _ := hex.DecodeString("3737373770717273373737")
+	samples := []struct {
+		name       string
+		key        []byte
+		tweak      []byte
+		alphabet   string
+		plaintext  string
+		ciphertext string
+	}{
+		{"sample 1", key128, nil, digits, "0123456789", "2433477484"},
+		{"sample 2", key128, tweak, digits, "0123456789", "6124200773"},
+		{"sample 3", key128, tweak36, alphanumeric, "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+		{"sample 7", key256, nil, digits, "0123456789", "6657667009"},
+		{"sample 8", key256, tweak, digits, "0123456789", "1001623463"},
+		{"sample 9", key256, tweak36, alphanumeric, "0123456789abcdefghi", "xs8a0azh2avyalyzuwd"},
+	}
+	for _, sample := range samples {
+		t.Run(sample.name, func(t *testing.T) {
+			f, err := newFF1(sample.key, sample.tweak, sample.alphabet)
+			require.NoError(t, err)
+			res, err := f.encrypt(sample.plaintext)
+			assert.NoError(t, err)
+			assert.Equal(t, sample.ciphertext, res, "should encrypt the plaintext")
+			res, err = f.decrypt(sample.ciphertext)
+			assert.NoError(t, err)
+			assert.Equal(t, sample.plaintext, res, "should decrypt the ciphertext")
+		})
+	}
+}
+
+func TestFF1Decrypt(t *testing.T) {
+	key, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	f, _ := newFF1(key, []byte("tweak"), "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	properties := gopter.NewProperties(nil)
+
+	properties.Property("decrypt(encrypt(s)) == s", prop.ForAll(
+		func(s string) bool {
+			encrypted, err1 := f.encrypt(s)
+			decrypted, err2 := f.decrypt(encrypted)
+			return assert.NoError(t, err1) && assert.NoError(t, err2) &&
+				assert.Len(t, encrypted, len(s)) && assert.Equal(t, s, decrypted)
+		},
+		gen.RegexMatch("[A-Z]{5,40}"),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestNewFF1(t *testing.T) {
+	key, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	t.Run("with an invalid key", func(t *testing.T) {
+		f, err := newFF1([]byte("short"), nil, "0123456789")
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, f)
+	})
+	t.Run("with an alphabet too short", func(t *testing.T) {
+		f, err := newFF1(key, nil, "0")
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, f)
+	})
+	t.Run("with a duplicated character in the alphabet", func(t *testing.T) {
+		f, err := newFF1(key, nil, "0120")
+		assert.Error(t, err, "should return an error")
+		assert.Nil(t, f)
+	})
+}
+
+func TestFF1Encrypt(t *testing.T) {
+	key, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	f, _ := newFF1(key, nil, "0123456789")
+	t.Run("with a character not in the alphabet", func(t *testing.T) {
+		res, err := f.encrypt("0123-4567")
+		assert.Error(t, err, "should return an error")
+		assert.Equal(t, "0123-4567", res, "should return the input unchanged")
+	})
+	t.Run("with a value too short", func(t *testing.T) {
+		res, err := f.encrypt("12345")
+		assert.Error(t, err, "should return an error")
+		assert.Equal(t, "12345", res, "should return the input unchanged")
+	})
+}
@@ -0,0 +1,17 @@
+module github.com/intenthq/anon
+
+go 1.22
+
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.18.0
+	github.com/leanovate/gopter v0.2.11
+	github.com/stretchr/testify v1.8.4
+	github.com/ulikunitz/xz v0.5.15
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
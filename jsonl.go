@@ -0,0 +1,481 @@
+package anon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonObject is a JSON object that keeps the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *jsonObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// Decodes a JSON value, decoding the objects as jsonObjects
+// and the numbers as json.Numbers
+func decodeJSON(d *json.Decoder) (interface{}, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		obj := &jsonObject{values: map[string]interface{}{}}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key.(string), value)
+		}
+		_, err = d.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for d.More() {
+			value, err := decodeJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err = d.Token()
+		return arr, err
+	}
+	return t, nil
+}
+
+// Encodes a value decoded by decodeJSON
+func encodeJSON(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case *jsonObject:
+		buf.WriteByte('{')
+		for i, key := range x.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, x.values[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range x {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case json.Number:
+		buf.WriteString(string(x))
+	default:
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(x); err != nil {
+			return err
+		}
+		// Encode adds a newline after the value
+		buf.Truncate(buf.Len() - 1)
+	}
+	return nil
+}
+
+// pathSegment is either the key of an object or
+// an index of an array (all the elements if index is -1)
+type pathSegment struct {
+	key   string
+	index int
+	array bool
+}
+
+// fieldPath is the path to a field of a JSON value, e.g. "user.email",
+// "orders[].amount" (all the elements of the array) or "phones[0]"
+type fieldPath []pathSegment
+
+var pathPartRegexp = regexp.MustCompile(`^([^\[\]]*)((?:\[\d*\])*)$`)
+
+func parseFieldPath(s string) (fieldPath, error) {
+	if s == "" {
+		return nil, errors.New("the field path can't be empty")
+	}
+	var path fieldPath
+	for _, part := range strings.Split(s, ".") {
+		m := pathPartRegexp.FindStringSubmatch(part)
+		if m == nil || m[1] == "" && m[2] == "" {
+			return nil, fmt.Errorf("invalid field path %s", s)
+		}
+		if m[1] != "" {
+			path = append(path, pathSegment{key: m[1]})
+		}
+		for _, index := range strings.Split(m[2], "]")[:strings.Count(m[2], "]")] {
+			i := -1
+			if index != "[" {
+				i, _ = strconv.Atoi(index[1:])
+			}
+			path = append(path, pathSegment{index: i, array: true})
+		}
+	}
+	return path, nil
+}
+
+// Calls f with each value in the path, replacing it with the value
+// that f returns. Values that are not in the path are ignored.
+func (p fieldPath) update(v interface{}, f func(interface{}) (interface{}, error)) (interface{}, error) {
+	if len(p) == 0 {
+		return f(v)
+	}
+	var err error
+	switch x := v.(type) {
+	case *jsonObject:
+		if value, ok := x.values[p[0].key]; ok && !p[0].array {
+			x.values[p[0].key], err = p[1:].update(value, f)
+		}
+	case []interface{}:
+		for i := range x {
+			if p[0].array && (p[0].index < 0 || p[0].index == i) {
+				if x[i], err = p[1:].update(x[i], f); err != nil {
+					break
+				}
+			}
+		}
+	}
+	return v, err
+}
+
+// Returns the first value in the path, if there is any
+func (p fieldPath) get(v interface{}) (interface{}, bool) {
+	var res interface{}
+	found := false
+	p.update(v, func(value interface{}) (interface{}, error) {
+		if !found {
+			res, found = value, true
+		}
+		return value, nil
+	})
+	return res, found
+}
+
+// Copies the values in the path from src to dst, creating the
+// objects and arrays that don't exist in dst and keeping the
+// order of the keys in src. Returns the updated dst.
+func (p fieldPath) copy(src interface{}, dst interface{}) interface{} {
+	if len(p) == 0 {
+		return src
+	}
+	switch x := src.(type) {
+	case *jsonObject:
+		value, ok := x.values[p[0].key]
+		if !ok || p[0].array {
+			return dst
+		}
+		obj, isObj := dst.(*jsonObject)
+		if !isObj {
+			obj = &jsonObject{values: map[string]interface{}{}}
+		}
+		obj.set(p[0].key, p[1:].copy(value, obj.values[p[0].key]))
+		position := func(key string) int {
+			for i, k := range x.keys {
+				if k == key {
+					return i
+				}
+			}
+			return len(x.keys)
+		}
+		sort.SliceStable(obj.keys, func(i, j int) bool { return position(obj.keys[i]) < position(obj.keys[j]) })
+		return obj
+	case []interface{}:
+		if !p[0].array {
+			return dst
+		}
+		arr, isArr := dst.([]interface{})
+		if !isArr {
+			arr = make([]interface{}, len(x))
+		}
+		for i := range x {
+			if p[0].index < 0 || p[0].index == i {
+				arr[i] = p[1:].copy(x[i], arr[i])
+			}
+		}
+		return arr
+	}
+	return dst
+}
+
+var jsonNumberRegexp = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?([eE][+-]?\d+)?$`)
+
+// Applies the anonymisation to a string, number or boolean. Numbers and
+// booleans are anonymised as strings, so the type of a field doesn't depend
+// on its values: if keepType is true (the action keeps numbers as numbers)
+// the result must have the type of the input, or be empty to write a null
+// (e.g. with the null error policy). Otherwise the result is a string.
+func anonymiseJSONValue(anon Anonymisation, v interface{}, keepType bool) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return anon(x)
+	case json.Number, bool:
+		res, err := anon(jsonValueString(x))
+		if err != nil || !keepType {
+			return res, err
+		}
+		_, isNumber := x.(json.Number)
+		switch {
+		case res == "":
+			return nil, nil
+		case isNumber && jsonNumberRegexp.MatchString(res):
+			return json.Number(res), nil
+		case isNumber:
+			return v, errors.New("the output of the action is not a number")
+		case res == "true" || res == "false":
+			return res == "true", nil
+		}
+		return v, errors.New("the output of the action is not a boolean")
+	}
+	return v, errors.New("the field is not a string, number or boolean")
+}
+
+// Returns the string representation of a string, number or boolean
+func jsonValueString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	case nil:
+		return ""
+	}
+	var buf bytes.Buffer
+	encodeJSON(&buf, v)
+	return buf.String()
+}
+
+// jsonlSchema stores the paths of the fields that
+// are anonymised in a JSON Lines file
+type jsonlSchema struct {
+	// Path of the field of each action
+	paths []fieldPath
+	// If the numbers and booleans of the field of
+	// each action keep their type, see anonymiseJSONValue
+	keepTypes []bool
+	// If not nil, the path of the field used to sample the records
+	idPath fieldPath
+	// If true, the fields without an action are not written
+	drop bool
+	// Path of the fields referenced by the actions, by their name
+	references map[string]fieldPath
+}
+
+// Returns the schema of the JSON Lines file defined in the config
+func newJSONLSchema(conf *Config) (*jsonlSchema, error) {
+	schema := &jsonlSchema{references: map[string]fieldPath{}}
+	switch conf.JSONL.Untouched {
+	case "", "keep":
+	case "drop":
+		schema.drop = true
+	default:
+		return nil, fmt.Errorf("invalid untouched policy %s, it must be either 'keep' or 'drop'", conf.JSONL.Untouched)
+	}
+	seen := map[string]bool{}
+	for i, ac := range conf.Actions {
+		if ac.Column == "" {
+			return nil, fmt.Errorf("action %d (%s) needs the path of a field in the column", i, ac.Name)
+		}
+		if seen[ac.Column] {
+			return nil, fmt.Errorf("field %s has more than one action defined", ac.Column)
+		}
+		seen[ac.Column] = true
+		path, err := parseFieldPath(ac.Column)
+		if err != nil {
+			return nil, err
+		}
+		schema.paths = append(schema.paths, path)
+		schema.keepTypes = append(schema.keepTypes, ac.outputKind().keepsNumbers())
+	}
+	if conf.Sampling.IDColumnName != "" {
+		path, err := parseFieldPath(conf.Sampling.IDColumnName)
+		if err != nil {
+			return nil, err
+		}
+		schema.idPath = path
+	} else if conf.Sampling.Mod > 1 {
+		return nil, errors.New("sampling a JSON Lines file needs the path of the id field in idColumnName")
+	} else if usesAction(conf.Actions, "dateShift") {
+		return nil, errors.New("the dateShift action needs the path of the id field in idColumnName")
+	}
+	for _, name := range referencedColumns(conf) {
+		path, err := parseFieldPath(name)
+		if err != nil {
+			return nil, err
+		}
+		schema.references[name] = path
+	}
+	return schema, nil
+}
+
+// jsonRecord is a record of a JSON Lines file
+type jsonRecord struct {
+	value  interface{}
+	schema *jsonlSchema
+}
+
+// Applies each anonymisation to the values in the path of its field,
+// the columns of the errors are the indices of the actions.
+func (r *jsonRecord) anonymise(anons []Anonymisation) error {
+	for i, path := range r.schema.paths {
+		if i >= len(anons) {
+			break
+		}
+		var err error
+		r.value, err = path.update(r.value, func(v interface{}) (interface{}, error) {
+			res, err := anonymiseJSONValue(anons[i], v, r.schema.keepTypes[i])
+			if err != nil {
+				return v, columnError{i, jsonValueString(v), err}
+			}
+			return res, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The columns are the indices of the actions
+func (r *jsonRecord) eachValue(f func(column int, value string)) {
+	for i, path := range r.schema.paths {
+		path.update(r.value, func(v interface{}) (interface{}, error) {
+			f(i, jsonValueString(v))
+			return v, nil
+		})
+	}
+}
+
+// jsonlReader reads the records of a JSON Lines file
+type jsonlReader struct {
+	r        *bufio.Reader
+	line     int
+	sampling SamplingConfig
+	schema   *jsonlSchema
+}
+
+func newJSONLReader(in io.Reader, conf SamplingConfig, schema *jsonlSchema) *jsonlReader {
+	return &jsonlReader{r: bufio.NewReader(in), sampling: conf, schema: schema}
+}
+
+// Lines that are not valid JSON or without the id
+// field are returned as items with an error
+func (jr *jsonlReader) read() (item, error) {
+	var line []byte
+	for len(line) == 0 {
+		var err error
+		line, err = jr.r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return item{}, io.EOF
+		} else if err != nil && err != io.EOF {
+			return item{}, err
+		}
+		jr.line++
+		line = bytes.TrimSpace(line)
+	}
+	it := item{line: jr.line, sampled: true}
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+	value, err := decodeJSON(d)
+	if err == nil && d.More() {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	if err != nil {
+		it.err = fmt.Errorf("invalid JSON: %v", err)
+		return it, nil
+	}
+	it.record = &jsonRecord{value: value, schema: jr.schema}
+	if jr.schema.idPath != nil {
+		id, ok := jr.schema.idPath.get(value)
+		if !ok {
+			it.err = errors.New("id field not found")
+			return it, nil
+		}
+		it.context.subject = jsonValueString(id)
+		it.sampled = sample(it.context.subject, jr.sampling)
+	}
+	if len(jr.schema.references) > 0 {
+		it.context.references = make(map[string]string, len(jr.schema.references))
+		for name, path := range jr.schema.references {
+			if v, ok := path.get(value); ok {
+				it.context.references[name] = jsonValueString(v)
+			}
+		}
+	}
+	return it, nil
+}
+
+// jsonlWriter writes the records to a JSON Lines file
+type jsonlWriter struct {
+	w   *bufio.Writer
+	buf bytes.Buffer
+}
+
+func newJSONLWriter(out io.Writer) *jsonlWriter {
+	return &jsonlWriter{w: bufio.NewWriter(out)}
+}
+
+// Writes the record in a line, without the fields
+// that don't have an action if they are dropped
+func (jw *jsonlWriter) write(rec record) error {
+	r := rec.(*jsonRecord)
+	value := r.value
+	if r.schema.drop {
+		value = nil
+		for _, path := range r.schema.paths {
+			value = path.copy(r.value, value)
+		}
+		if value == nil {
+			value = &jsonObject{values: map[string]interface{}{}}
+		}
+	}
+	jw.buf.Reset()
+	if err := encodeJSON(&jw.buf, value); err != nil {
+		return err
+	}
+	jw.buf.WriteByte('\n')
+	_, err := jw.w.Write(jw.buf.Bytes())
+	return err
+}
+
+func (jw *jsonlWriter) flush() error {
+	return jw.w.Flush()
+}
+
+func (jw *jsonlWriter) close() error {
+	return jw.flush()
+}
@@ -0,0 +1,267 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLine(t *testing.T, s string) interface{} {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	v, err := decodeJSON(d)
+	require.NoError(t, err)
+	return v
+}
+
+func encodeValue(t *testing.T, v interface{}) string {
+	var buf bytes.Buffer
+	require.NoError(t, encodeJSON(&buf, v))
+	return buf.String()
+}
+
+func TestDecodeEncodeJSON(t *testing.T) {
+	t.Run("keeps the order of the keys and the numbers", func(t *testing.T) {
+		s := `{"z":1.50,"a":{"y":[1,"<b>",null,true],"b":{}},"m":[]}`
+		assert.Equal(t, s, encodeValue(t, decodeLine(t, s)))
+	})
+	t.Run("with invalid JSON", func(t *testing.T) {
+		_, err := decodeJSON(json.NewDecoder(strings.NewReader(`{"a":}`)))
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestParseFieldPath(t *testing.T) {
+	t.Run("with valid paths", func(t *testing.T) {
+		path, err := parseFieldPath("a.b[].c[1][]")
+		assert.NoError(t, err)
+		assert.Equal(t, fieldPath{
+			pathSegment{key: "a"},
+			pathSegment{key: "b"},
+			pathSegment{index: -1, array: true},
+			pathSegment{key: "c"},
+			pathSegment{index: 1, array: true},
+			pathSegment{index: -1, array: true},
+		}, path)
+	})
+	t.Run("with invalid paths", func(t *testing.T) {
+		for _, s := range []string{"", "a..b", "a[b]", "a]", "a[1"} {
+			_, err := parseFieldPath(s)
+			assert.Error(t, err, "should return an error for %s", s)
+		}
+	})
+}
+
+func TestFieldPathUpdate(t *testing.T) {
+	upper := func(v interface{}) (interface{}, error) {
+		return strings.ToUpper(v.(string)), nil
+	}
+	t.Run("updates the nested fields", func(t *testing.T) {
+		v := decodeLine(t, `{"a":{"b":"x"},"c":[{"d":"y"},{"d":"z"},{}]}`)
+		for _, s := range []string{"a.b", "c[].d", "missing.field"} {
+			path, _ := parseFieldPath(s)
+			var err error
+			v, err = path.update(v, upper)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, `{"a":{"b":"X"},"c":[{"d":"Y"},{"d":"Z"},{}]}`, encodeValue(t, v))
+	})
+	t.Run("updates a single element of an array", func(t *testing.T) {
+		path, _ := parseFieldPath("a[1]")
+		v, err := path.update(decodeLine(t, `{"a":["x","y"]}`), upper)
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":["x","Y"]}`, encodeValue(t, v))
+	})
+	t.Run("returns the error of the function", func(t *testing.T) {
+		path, _ := parseFieldPath("a")
+		_, err := path.update(decodeLine(t, `{"a":"x"}`), func(v interface{}) (interface{}, error) {
+			return v, errors.New("error")
+		})
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestFieldPathCopy(t *testing.T) {
+	src := decodeLine(t, `{"a":"x","b":{"c":"y","d":"z"},"e":[{"f":1,"g":2}]}`)
+	var dst interface{}
+	for _, s := range []string{"e[].f", "b.c", "a", "missing"} {
+		path, _ := parseFieldPath(s)
+		dst = path.copy(src, dst)
+	}
+	assert.Equal(t, `{"a":"x","b":{"c":"y"},"e":[{"f":1}]}`, encodeValue(t, dst), "should copy only the fields in the paths keeping the order")
+}
+
+func TestAnonymiseJSONValue(t *testing.T) {
+	constant := func(s string) Anonymisation {
+		return func(string) (string, error) { return s, nil }
+	}
+	t.Run("with a string", func(t *testing.T) {
+		for _, keepType := range []bool{true, false} {
+			v, err := anonymiseJSONValue(constant("10"), "a", keepType)
+			assert.NoError(t, err)
+			assert.Equal(t, "10", v, "should return a string")
+		}
+	})
+	t.Run("with a number", func(t *testing.T) {
+		v, err := anonymiseJSONValue(constant("10"), json.Number("12"), true)
+		assert.NoError(t, err)
+		assert.Equal(t, json.Number("10"), v, "should keep it as a number if the action keeps numbers")
+		_, err = anonymiseJSONValue(constant("10-20"), json.Number("12"), true)
+		assert.Error(t, err, "should return an error if the result isn't a number")
+		v, err = anonymiseJSONValue(constant(""), json.Number("12"), true)
+		assert.NoError(t, err)
+		assert.Nil(t, v, "should return null if the result is empty")
+		for _, res := range []string{"10", "10-20"} {
+			v, err = anonymiseJSONValue(constant(res), json.Number("12"), false)
+			assert.NoError(t, err)
+			assert.Equal(t, res, v, "should return a string otherwise")
+		}
+	})
+	t.Run("with a boolean", func(t *testing.T) {
+		v, err := anonymiseJSONValue(constant("false"), true, true)
+		assert.NoError(t, err)
+		assert.Equal(t, false, v, "should keep it as a boolean if the action keeps the type")
+		_, err = anonymiseJSONValue(constant("x"), true, true)
+		assert.Error(t, err, "should return an error if the result isn't a boolean")
+		v, err = anonymiseJSONValue(constant("false"), true, false)
+		assert.NoError(t, err)
+		assert.Equal(t, "false", v, "should return a string otherwise")
+	})
+	t.Run("with null", func(t *testing.T) {
+		v, err := anonymiseJSONValue(constant("x"), nil, true)
+		assert.NoError(t, err)
+		assert.Nil(t, v, "should leave it unchanged")
+	})
+	t.Run("with an object", func(t *testing.T) {
+		_, err := anonymiseJSONValue(constant("x"), decodeLine(t, `{}`), true)
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestNewJSONLSchema(t *testing.T) {
+	t.Run("with a valid config", func(t *testing.T) {
+		schema, err := newJSONLSchema(&Config{
+			JSONL:    JSONLConfig{Untouched: "drop"},
+			Sampling: SamplingConfig{Mod: 2, IDColumnName: "id"},
+			Actions:  []ActionConfig{ActionConfig{Name: "nothing", Column: "a.b"}},
+		})
+		assert.NoError(t, err)
+		assert.True(t, schema.drop)
+		assert.Len(t, schema.paths, 1)
+		assert.Equal(t, fieldPath{pathSegment{key: "id"}}, schema.idPath)
+	})
+	for name, conf := range map[string]*Config{
+		"with an invalid untouched policy": &Config{JSONL: JSONLConfig{Untouched: "other"}},
+		"with an action without a field":   &Config{Actions: []ActionConfig{ActionConfig{Name: "nothing"}}},
+		"with a duplicated field":          &Config{Actions: []ActionConfig{ActionConfig{Name: "nothing", Column: "a"}, ActionConfig{Name: "outcode", Column: "a"}}},
+		"with an invalid field":            &Config{Actions: []ActionConfig{ActionConfig{Name: "nothing", Column: "a[x]"}}},
+		"sampling without an id field":     &Config{Sampling: SamplingConfig{Mod: 2}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newJSONLSchema(conf)
+			assert.Error(t, err, "should return an error")
+		})
+	}
+}
+
+func TestProcessorProcessJSONL(t *testing.T) {
+	conf := &Config{
+		Format: FormatJSONL,
+		Actions: []ActionConfig{
+			ActionConfig{Name: "outcode", Column: "user.postcode"},
+			ActionConfig{Name: "year", Column: "orders[].date", DateConfig: DateConfig{Format: "20060102"}},
+		},
+	}
+	in := `{"id":1,"user":{"name":"x","postcode":"SW1A 1AA"},"orders":[{"date":"20020202"},{"date":"20030303"}]}
+
+not json
+{"id":3,"user":{"postcode":"E1 6AN"},"orders":[{"date":"fail"}]}
+{"id":4}
+`
+	t.Run("keeping the untouched fields", func(t *testing.T) {
+		var out, rejects bytes.Buffer
+		st := NewStats()
+		p, err := NewProcessor(conf, Options{Rejects: &rejects, Stats: st})
+		require.NoError(t, err)
+		err = p.Process(strings.NewReader(in), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, `{"id":1,"user":{"name":"x","postcode":"SW1A"},"orders":[{"date":"2002"},{"date":"2003"}]}
+{"id":4}
+`, out.String(), "should anonymise the fields of each record")
+		assert.Equal(t, `line,column,action,reason,value
+3,,,invalid JSON: invalid character 'o' in literal null (expecting 'u'),
+4,1,year,can't parse the date with the format 20060102,
+`, rejects.String(), "should reject the invalid records with their line and action")
+		assert.Equal(t, int64(4), st.Read)
+		assert.Equal(t, int64(2), st.Written)
+	})
+	t.Run("dropping the untouched fields", func(t *testing.T) {
+		conf := *conf
+		conf.JSONL.Untouched = "drop"
+		var out bytes.Buffer
+		p, err := NewProcessor(&conf, Options{})
+		require.NoError(t, err)
+		err = p.Process(strings.NewReader(in), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, `{"user":{"postcode":"SW1A"},"orders":[{"date":"2002"},{"date":"2003"}]}
+{}
+`, out.String(), "should only write the fields with an action")
+	})
+	t.Run("sampling by an id field", func(t *testing.T) {
+		conf := &Config{
+			Format:   FormatJSONL,
+			Sampling: SamplingConfig{Mod: 2, IDColumnName: "id"},
+		}
+		var out, rejects bytes.Buffer
+		p, err := NewProcessor(conf, Options{Rejects: &rejects})
+		require.NoError(t, err)
+		err = p.Process(strings.NewReader("{\"id\":\"a\"}\n{\"id\":\"b\"}\n{\"id\":\"c\"}\n{}\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"c\"}\n", out.String(), "should only write the sampled records")
+		assert.Contains(t, rejects.String(), "4,,,id field not found,", "should reject the records without an id")
+	})
+	t.Run("with outputs that are numbers and strings", func(t *testing.T) {
+		one, tenOrMore := "1", "10+"
+		conf := &Config{Format: FormatJSONL, Actions: []ActionConfig{
+			ActionConfig{Name: "ranges", Column: "visits", RangeConfig: []RangeConfig{
+				RangeConfig{Lt: float(10), Output: &one},
+				RangeConfig{Gte: float(10), Output: &tenOrMore},
+			}},
+			ActionConfig{Name: "nothing", Column: "age"},
+		}}
+		var out bytes.Buffer
+		p, err := NewProcessor(conf, Options{})
+		require.NoError(t, err)
+		err = p.Process(strings.NewReader(`{"visits":5,"age":30}`+"\n"+`{"visits":15,"age":true}`+"\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, `{"visits":"1","age":30}`+"\n"+`{"visits":"10+","age":true}`+"\n", out.String(), "should write all the outputs of an action that doesn't keep numbers as strings")
+	})
+	t.Run("with an invalid format", func(t *testing.T) {
+		_, err := NewProcessor(&Config{Format: "xml"}, Options{})
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestRevealJSONL(t *testing.T) {
+	conf := &Config{
+		Format: FormatJSONL,
+		Actions: []ActionConfig{
+			ActionConfig{Name: "encrypt", Column: "email", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_JSONL_KEY"}}},
+		},
+	}
+	t.Setenv("ANON_TEST_JSONL_KEY", sivKey)
+	var anonymised, revealed bytes.Buffer
+	p, err := NewProcessor(conf, Options{})
+	require.NoError(t, err)
+	in := "{\"email\":\"a@b.com\",\"n\":1}\n"
+	require.NoError(t, p.Process(strings.NewReader(in), &anonymised))
+	assert.NotContains(t, anonymised.String(), "a@b.com")
+	err = Reveal(conf, &anonymised, &revealed)
+	assert.NoError(t, err, "should return no error")
+	assert.Equal(t, in, revealed.String(), "should decrypt the encrypted fields")
+}
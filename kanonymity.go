@@ -0,0 +1,167 @@
+package anon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KAnonymityConfig stores the config to enforce k-anonymity over the
+// columns of the actions (or output columns) that are quasi-identifiers
+type KAnonymityConfig struct {
+	// Minimum number of records written with the same values
+	// of the quasi-identifiers, it's not enforced if it's not set
+	K int
+	// What to do with the records of the groups smaller than K: suppress
+	// (default) doesn't write them and generalise replaces the values of
+	// their quasi-identifiers with Output
+	Policy string
+	// Value of the generalised quasi-identifiers, * by default
+	Output string
+}
+
+// Checks that k-anonymity can be enforced with the rest of the config
+func checkKAnonymity(conf *Config) error {
+	k := conf.KAnonymity
+	if k.K < 0 {
+		return errors.New("k can't be negative")
+	} else if k.Policy != "" && k.Policy != "suppress" && k.Policy != "generalise" {
+		return fmt.Errorf("invalid k-anonymity policy %s, it must be either 'suppress' or 'generalise'", k.Policy)
+	} else if k.K > 0 && len(quasiIdentifiers(conf)) == 0 {
+		return errors.New("k-anonymity needs at least one action or output column tagged as quasiIdentifier")
+	}
+	if k.K == 0 {
+		return nil
+	}
+	for i, column := range conf.Output {
+		if name := randomAction(column.Actions); column.QuasiIdentifier && name != "" {
+			return fmt.Errorf("output column %d is a quasi-identifier, it can't use the action %s as its output is random", i, name)
+		}
+	}
+	for i, ac := range conf.Actions {
+		if name := randomAction([]ActionConfig{ac}); ac.QuasiIdentifier && name != "" {
+			return fmt.Errorf("action %d is a quasi-identifier, it can't use the action %s as its output is random", i, name)
+		}
+	}
+	return nil
+}
+
+// Returns the name of the first action, or step of their pipelines, whose
+// output is random (noise), so the records can't be grouped by it. Empty
+// if there isn't any. A hash without a salt isn't random, as its salt is
+// drawn once when it's created and it's used in both passes.
+func randomAction(configs []ActionConfig) string {
+	for _, config := range configs {
+		if config.Name == "noise" {
+			return config.Name
+		} else if name := randomAction(config.Pipeline); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Returns the positions of the actions (or output
+// columns) whose columns are quasi-identifiers
+func quasiIdentifiers(conf *Config) []int {
+	var res []int
+	for i, column := range conf.Output {
+		if column.QuasiIdentifier {
+			res = append(res, i)
+		}
+	}
+	for i, ac := range conf.Actions {
+		if ac.QuasiIdentifier {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// Given the actions config and the index of each column in the header,
+// returns the positions of the columns of the actions in the positions.
+// The columns must have been checked with byColumn.
+func columnPositions(configs []ActionConfig, positions []int, columns map[string]int) []int {
+	res := make([]int, len(positions))
+	for i, position := range positions {
+		res[i] = columns[configs[position].Column]
+	}
+	return res
+}
+
+// kAnonymity counts, in the first pass over the input, the records written
+// with each combination of values of the quasi-identifiers and, in the
+// second one, suppresses or generalises the records of the smaller groups
+type kAnonymity struct {
+	conf   KAnonymityConfig
+	counts map[string]int
+	// Number of records in the groups smaller than k, known after the first pass
+	small int
+}
+
+func newKAnonymity(conf KAnonymityConfig) *kAnonymity {
+	return &kAnonymity{conf: conf, counts: map[string]int{}, small: -1}
+}
+
+// Returns the values of the columns of the record as the key of its group
+func groupKey(rec record, columns []int) string {
+	values := make(map[int]string, len(columns))
+	rec.eachValue(func(i int, v string) {
+		values[i] = v
+	})
+	var key strings.Builder
+	for _, column := range columns {
+		v := values[column]
+		key.WriteString(strconv.Itoa(len(v)))
+		key.WriteByte(':')
+		key.WriteString(v)
+	}
+	return key.String()
+}
+
+// Counts the record in the first pass or, in the second one, returns if it
+// has to be written, generalising it if its group is smaller than k. If the
+// generalised records would be fewer than k too, they are suppressed.
+func (k *kAnonymity) enforce(rec record, opts processOptions) bool {
+	key := groupKey(rec, opts.quasiIdentifiers)
+	if opts.firstPass {
+		k.counts[key]++
+		return true
+	}
+	if k.small < 0 {
+		k.small = 0
+		for _, n := range k.counts {
+			if n < k.conf.K {
+				k.small += n
+			}
+		}
+	}
+	if k.counts[key] >= k.conf.K {
+		return true
+	}
+	if k.conf.Policy == "generalise" && k.small >= k.conf.K && k.generalise(rec, opts.quasiIdentifiers) {
+		opts.stats.generalised()
+		return true
+	}
+	opts.stats.suppressed()
+	return false
+}
+
+// Replaces the values of the quasi-identifiers of the record with
+// the output. Returns false if the record can't hold the output
+// (e.g. a column of numbers in a Parquet or JSON Lines file).
+func (k *kAnonymity) generalise(rec record, columns []int) bool {
+	output := k.conf.Output
+	if output == "" {
+		output = "*"
+	}
+	var anons []Anonymisation
+	for _, column := range columns {
+		for len(anons) <= column {
+			anons = append(anons, identity)
+		}
+		anons[column] = func(string) (string, error) { return output, nil }
+	}
+	return rec.anonymise(anons) == nil
+}
@@ -0,0 +1,113 @@
+package anon
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckKAnonymity(t *testing.T) {
+	quasi := []ActionConfig{ActionConfig{Name: "outcode", QuasiIdentifier: true}}
+	t.Run("with a valid config", func(t *testing.T) {
+		assert.NoError(t, checkKAnonymity(&Config{KAnonymity: KAnonymityConfig{K: 5}, Actions: quasi}))
+		assert.NoError(t, checkKAnonymity(&Config{KAnonymity: KAnonymityConfig{K: 5}, Output: []OutputColumn{OutputColumn{QuasiIdentifier: true}}}))
+		assert.NoError(t, checkKAnonymity(&Config{}), "should accept a config without k-anonymity")
+		assert.NoError(t, checkKAnonymity(&Config{KAnonymity: KAnonymityConfig{K: 5}, Actions: append([]ActionConfig{
+			ActionConfig{Name: "hash", QuasiIdentifier: true},
+			ActionConfig{Name: "noise"},
+		}, quasi...)}), "should accept a hash without a salt and random actions on the rest of columns")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []*Config{
+			&Config{KAnonymity: KAnonymityConfig{K: -1}, Actions: quasi},
+			&Config{KAnonymity: KAnonymityConfig{K: 5, Policy: "drop"}, Actions: quasi},
+			&Config{KAnonymity: KAnonymityConfig{K: 5}, Actions: []ActionConfig{ActionConfig{Name: "outcode"}}},
+			&Config{KAnonymity: KAnonymityConfig{K: 5}, Actions: []ActionConfig{ActionConfig{Name: "noise", QuasiIdentifier: true}}},
+			&Config{KAnonymity: KAnonymityConfig{K: 5}, Actions: []ActionConfig{ActionConfig{Name: "pipeline", QuasiIdentifier: true, Pipeline: []ActionConfig{ActionConfig{Name: "outcode"}, ActionConfig{Name: "noise"}}}}},
+			&Config{KAnonymity: KAnonymityConfig{K: 5}, Output: []OutputColumn{OutputColumn{QuasiIdentifier: true, Actions: []ActionConfig{ActionConfig{Name: "noise"}}}}},
+		} {
+			assert.Error(t, checkKAnonymity(conf), "should return an error for %+v", conf.KAnonymity)
+		}
+	})
+}
+
+func TestGroupKey(t *testing.T) {
+	assert.Equal(t, groupKey(csvRecord{"a", "x", "b"}, []int{0, 2}), groupKey(csvRecord{"a", "y", "b"}, []int{0, 2}), "should only use the values of the columns")
+	assert.NotEqual(t, groupKey(csvRecord{"a:", "b"}, []int{0, 1}), groupKey(csvRecord{"a", ":b"}, []int{0, 1}), "should keep the values apart")
+}
+
+func TestProcessorProcessKAnonymity(t *testing.T) {
+	input := "id,postcode,year\n1,a1 1aa,2001\n2,a1 2bb,2001\n3,b2 3cc,2002\n4,a1 4dd,2001\n5,c3 5ee,2003\n"
+	actions := []ActionConfig{
+		ActionConfig{Name: "outcode", Column: "postcode", QuasiIdentifier: true},
+		ActionConfig{Name: "nothing", Column: "year", QuasiIdentifier: true},
+	}
+	process := func(t *testing.T, conf *Config, opts Options, input string) string {
+		var out bytes.Buffer
+		p, err := NewProcessor(conf, opts)
+		require.NoError(t, err)
+		require.NoError(t, p.Process(strings.NewReader(input), &out))
+		return out.String()
+	}
+	t.Run("suppressing the records", func(t *testing.T) {
+		for _, workers := range []int{1, 4} {
+			stats := NewStats()
+			conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Actions: actions, KAnonymity: KAnonymityConfig{K: 2}}
+			out := process(t, conf, Options{Workers: workers, Stats: stats}, input)
+			assert.Equal(t, "id,postcode,year\n1,a1,2001\n2,a1,2001\n4,a1,2001\n", out, "should only write the groups of at least k records")
+			assert.EqualValues(t, 2, stats.Suppressed, "should count the suppressed records")
+			assert.EqualValues(t, 5, stats.Read, "should only collect the statistics of the second pass")
+		}
+	})
+	t.Run("generalising the records", func(t *testing.T) {
+		stats := NewStats()
+		conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Actions: actions, KAnonymity: KAnonymityConfig{K: 2, Policy: "generalise"}}
+		out := process(t, conf, Options{Stats: stats}, input)
+		assert.Equal(t, "id,postcode,year\n1,a1,2001\n2,a1,2001\n3,*,*\n4,a1,2001\n5,*,*\n", out, "should generalise the quasi-identifiers of the smaller groups")
+		assert.EqualValues(t, 2, stats.Generalised, "should count the generalised records")
+	})
+	t.Run("when the generalised records are fewer than k", func(t *testing.T) {
+		conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Actions: actions, KAnonymity: KAnonymityConfig{K: 3, Policy: "generalise", Output: "?"}}
+		out := process(t, conf, Options{}, input)
+		assert.Equal(t, "id,postcode,year\n1,a1,2001\n2,a1,2001\n4,a1,2001\n", out, "should suppress them")
+	})
+	t.Run("without a header", func(t *testing.T) {
+		conf := &Config{Csv: CsvConfig{Delimiter: ","}, Actions: []ActionConfig{ActionConfig{Name: "nothing", QuasiIdentifier: true}}, KAnonymity: KAnonymityConfig{K: 2}}
+		out := process(t, conf, Options{}, "a,1\nb,2\na,3\n")
+		assert.Equal(t, "a,1\na,3\n", out, "should use the columns by their position")
+	})
+	t.Run("with output columns", func(t *testing.T) {
+		conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, KAnonymity: KAnonymityConfig{K: 2, Policy: "generalise"}, Output: []OutputColumn{
+			OutputColumn{Source: "id"},
+			OutputColumn{Source: "postcode", Name: "outcode", Actions: []ActionConfig{ActionConfig{Name: "outcode"}}, QuasiIdentifier: true},
+		}}
+		out := process(t, conf, Options{}, input)
+		assert.Equal(t, "id,outcode\n1,a1\n2,a1\n3,*\n4,a1\n5,*\n", out, "should enforce it on the output columns")
+	})
+	t.Run("with JSON Lines", func(t *testing.T) {
+		conf := &Config{Format: FormatJSONL, Actions: []ActionConfig{ActionConfig{Name: "nothing", Column: "age", QuasiIdentifier: true}}, KAnonymity: KAnonymityConfig{K: 2, Policy: "generalise"}}
+		out := process(t, conf, Options{}, `{"id":1,"age":"30"}`+"\n"+`{"id":2,"age":"30"}`+"\n"+`{"id":3,"age":"40"}`+"\n"+`{"id":4,"age":"50"}`+"\n")
+		assert.Equal(t, `{"id":1,"age":"30"}`+"\n"+`{"id":2,"age":"30"}`+"\n"+`{"id":3,"age":"*"}`+"\n"+`{"id":4,"age":"*"}`+"\n", out, "should enforce it on the fields")
+		out = process(t, conf, Options{}, `{"id":1,"age":30}`+"\n"+`{"id":2,"age":30}`+"\n"+`{"id":3,"age":40}`+"\n"+`{"id":4,"age":50}`+"\n")
+		assert.Equal(t, `{"id":1,"age":30}`+"\n"+`{"id":2,"age":30}`+"\n", out, "should suppress the records if the numbers can't be generalised")
+	})
+	t.Run("with a hash without a salt", func(t *testing.T) {
+		conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, KAnonymity: KAnonymityConfig{K: 2}, Actions: []ActionConfig{
+			ActionConfig{Name: "hash", Column: "year", QuasiIdentifier: true},
+		}}
+		out := process(t, conf, Options{}, input)
+		assert.Len(t, strings.Split(strings.TrimSpace(out), "\n"), 4, "should hash the values with the same salt in both passes")
+	})
+	t.Run("with a random quasi-identifier", func(t *testing.T) {
+		// the noise would be different in each pass, so the
+		// records would be counted in groups they aren't written in
+		conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, KAnonymity: KAnonymityConfig{K: 2}, Actions: []ActionConfig{
+			ActionConfig{Name: "noise", Column: "year", NoiseConfig: NoiseConfig{Epsilon: 1, Sensitivity: 1}, QuasiIdentifier: true},
+		}}
+		_, err := NewProcessor(conf, Options{})
+		assert.EqualError(t, err, "action 0 is a quasi-identifier, it can't use the action noise as its output is random")
+	})
+}
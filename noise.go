@@ -0,0 +1,284 @@
+package anon
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Checks the privacy parameters and returns the scale (laplace) or the
+// standard deviation (gaussian) of the noise, in units of the grid the
+// values are rounded to, and that grid. The noise is calibrated to the
+// sensitivity plus one step of the grid, so rounding the values doesn't
+// spend more budget than epsilon (and delta).
+func (conf *NoiseConfig) scale() (*big.Rat, float64, error) {
+	if conf.Epsilon <= 0 || conf.Sensitivity <= 0 || math.IsInf(conf.Sensitivity, 0) {
+		return nil, 0, errors.New("epsilon and sensitivity must be greater than 0")
+	} else if conf.Min != nil && conf.Max != nil && *conf.Min > *conf.Max {
+		return nil, 0, errors.New("min can't be greater than max")
+	} else if conf.Decimals != nil && *conf.Decimals < 0 {
+		return nil, 0, errors.New("decimals can't be negative")
+	}
+	grid, step := conf.grid()
+	// sensitivity in steps of the grid
+	sensitivity := new(big.Rat).Quo(new(big.Rat).SetFloat64(conf.Sensitivity), grid)
+	steps := new(big.Int).Quo(sensitivity.Num(), sensitivity.Denom())
+	steps.Add(steps, big.NewInt(1))
+	switch conf.Mechanism {
+	case "", "laplace":
+		if conf.Delta != 0 {
+			return nil, 0, errors.New("delta is only used by the gaussian mechanism")
+		}
+		scale := new(big.Rat).SetInt(steps)
+		return scale.Quo(scale, new(big.Rat).SetFloat64(conf.Epsilon)), step, nil
+	case "gaussian":
+		if conf.Delta <= 0 || conf.Delta >= 1 {
+			return nil, 0, errors.New("delta must be between 0 and 1")
+		}
+		// rho-zCDP implies (rho + 2*sqrt(rho*ln(1/delta)), delta)-DP
+		l := math.Log(1 / conf.Delta)
+		rho := math.Pow(math.Sqrt(l+conf.Epsilon)-math.Sqrt(l), 2)
+		sigma, _ := new(big.Float).SetInt(steps).Float64()
+		sigma = math.Nextafter(sigma/math.Sqrt(2*rho), math.Inf(1))
+		return new(big.Rat).SetFloat64(sigma), step, nil
+	}
+	return nil, 0, fmt.Errorf("invalid mechanism %s, it must be laplace or gaussian", conf.Mechanism)
+}
+
+// Returns the grid the noisy values are rounded to, as a rational and
+// as a float: a step of the given decimals or, if they aren't set, about
+// a hundredth of the sensitivity
+func (conf *NoiseConfig) grid() (*big.Rat, float64) {
+	exponent := int(math.Floor(math.Log10(conf.Sensitivity))) - 2
+	if conf.Decimals != nil {
+		exponent = -*conf.Decimals
+	}
+	power := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exponent))), nil)
+	grid := new(big.Rat).SetInt(power)
+	if exponent < 0 {
+		grid.Inv(grid)
+	}
+	step, _ := grid.Float64()
+	return grid, step
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// Returns the number of decimals of the values rounded to the step
+func decimals(step float64) int {
+	if step >= 1 {
+		return 0
+	}
+	return int(math.Round(-math.Log10(step)))
+}
+
+// Returns an anonymisation that adds noise to numbers
+func noise(conf NoiseConfig) (Anonymisation, error) {
+	return noiseWith(conf, crand.Reader)
+}
+
+// Returns an anonymisation that clamps the numbers to the bounds, rounds
+// them to the grid, adds discrete noise sampled with the random bytes of
+// rnd and clamps the result again
+func noiseWith(conf NoiseConfig, rnd io.Reader) (Anonymisation, error) {
+	scale, step, err := conf.scale()
+	if err != nil {
+		return nil, err
+	}
+	sample := func() (*big.Int, error) { return discreteLaplace(rnd, scale) }
+	if conf.Mechanism == "gaussian" {
+		sample = func() (*big.Int, error) { return discreteGaussian(rnd, new(big.Rat).Mul(scale, scale)) }
+	}
+	digits := decimals(step)
+	return func(s string) (string, error) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return s, errors.New("value is not a number")
+		}
+		steps := math.Round(conf.clamp(v) / step)
+		if math.Abs(steps) > 1<<53 {
+			return s, errors.New("value is too large for the decimals of the noise")
+		}
+		n, err := sample()
+		if err != nil {
+			return s, err
+		}
+		v = conf.clamp((steps + float64(n.Int64())) * step)
+		return strconv.FormatFloat(v, 'f', digits, 64), nil
+	}, nil
+}
+
+// Returns a uniform random integer in [0, n)
+func uniform(rnd io.Reader, n *big.Int) (*big.Int, error) {
+	return crand.Int(rnd, n)
+}
+
+// Returns true with probability p, in [0, 1]
+func bernoulli(rnd io.Reader, p *big.Rat) (bool, error) {
+	u, err := uniform(rnd, p.Denom())
+	if err != nil {
+		return false, err
+	}
+	return u.Cmp(p.Num()) < 0, nil
+}
+
+// Returns true with probability exp(-gamma), for gamma >= 0, without
+// floating point arithmetic (Canonne, Kamath and Steinke, 2020)
+func bernoulliExp(rnd io.Reader, gamma *big.Rat) (bool, error) {
+	one := big.NewRat(1, 1)
+	gamma = new(big.Rat).Set(gamma)
+	for gamma.Cmp(one) > 0 {
+		ok, err := bernoulliExp(rnd, one)
+		if err != nil || !ok {
+			return false, err
+		}
+		gamma.Sub(gamma, one)
+	}
+	k := int64(1)
+	for {
+		ok, err := bernoulli(rnd, new(big.Rat).Quo(gamma, big.NewRat(k, 1)))
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return k%2 == 1, nil
+		}
+		k++
+	}
+}
+
+// Returns a sample of the discrete laplace distribution centered at 0,
+// where P(x) is proportional to exp(-|x|/scale), sampled exactly
+// (Canonne, Kamath and Steinke, 2020)
+func discreteLaplace(rnd io.Reader, scale *big.Rat) (*big.Int, error) {
+	t, s := scale.Num(), scale.Denom()
+	for {
+		u, err := uniform(rnd, t)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := bernoulliExp(rnd, new(big.Rat).SetFrac(u, t))
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		v := new(big.Int)
+		for {
+			ok, err := bernoulliExp(rnd, big.NewRat(1, 1))
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+			v.Add(v, big.NewInt(1))
+		}
+		y := v.Mul(v, t).Add(v, u)
+		y.Quo(y, s)
+		negative, err := bernoulli(rnd, big.NewRat(1, 2))
+		if err != nil {
+			return nil, err
+		}
+		if negative && y.Sign() == 0 {
+			continue
+		} else if negative {
+			y.Neg(y)
+		}
+		return y, nil
+	}
+}
+
+// Returns a sample of the discrete gaussian distribution centered at 0,
+// where P(x) is proportional to exp(-x^2/(2*variance)), sampled exactly
+// from the discrete laplace (Canonne, Kamath and Steinke, 2020)
+func discreteGaussian(rnd io.Reader, variance *big.Rat) (*big.Int, error) {
+	v, _ := variance.Float64()
+	t := new(big.Rat).SetInt64(int64(math.Sqrt(v)) + 1)
+	// variance / t
+	mean := new(big.Rat).Quo(variance, t)
+	for {
+		y, err := discreteLaplace(rnd, t)
+		if err != nil {
+			return nil, err
+		}
+		// exp(-(|y| - variance/t)^2 / (2*variance))
+		d := new(big.Rat).Sub(new(big.Rat).SetInt(new(big.Int).Abs(y)), mean)
+		d.Mul(d, d).Quo(d, new(big.Rat).Mul(variance, big.NewRat(2, 1)))
+		ok, err := bernoulliExp(rnd, d)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			return y, nil
+		}
+	}
+}
+
+func (conf *NoiseConfig) clamp(v float64) float64 {
+	if conf.Min != nil && v < *conf.Min {
+		return *conf.Min
+	} else if conf.Max != nil && v > *conf.Max {
+		return *conf.Max
+	}
+	return v
+}
+
+// PrivacyBudget stores the privacy budget spent on a column by the noise
+// actions applied to it, added up if there is more than one
+type PrivacyBudget struct {
+	// Name of the column (or output column), or the position
+	// of its action if the csv doesn't have a header
+	Column  string  `json:"column"`
+	Epsilon float64 `json:"epsilon"`
+	Delta   float64 `json:"delta"`
+}
+
+// Adds up the privacy parameters of the noise actions,
+// or of the steps of their pipelines
+func spentBudget(configs []ActionConfig) (epsilon float64, delta float64) {
+	for _, config := range configs {
+		if config.Name == "noise" {
+			epsilon += config.NoiseConfig.Epsilon
+			delta += config.NoiseConfig.Delta
+		}
+		e, d := spentBudget(config.Pipeline)
+		epsilon, delta = epsilon+e, delta+d
+	}
+	return epsilon, delta
+}
+
+// Returns the privacy budget spent on each column by the noise actions
+// of the config, in the order of the actions (or the output columns)
+func privacyBudgets(conf *Config) []PrivacyBudget {
+	var res []PrivacyBudget
+	for i, column := range conf.Output {
+		name := column.Name
+		if name == "" {
+			name = column.Source
+		}
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if epsilon, delta := spentBudget(column.Actions); epsilon > 0 {
+			res = append(res, PrivacyBudget{Column: name, Epsilon: epsilon, Delta: delta})
+		}
+	}
+	for i, ac := range conf.Actions {
+		name := ac.Column
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if epsilon, delta := spentBudget([]ActionConfig{ac}); epsilon > 0 {
+			res = append(res, PrivacyBudget{Column: name, Epsilon: epsilon, Delta: delta})
+		}
+	}
+	return res
+}
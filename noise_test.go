@@ -0,0 +1,213 @@
+package anon
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoiseScale(t *testing.T) {
+	negative := -1
+	t.Run("with a valid config", func(t *testing.T) {
+		scale, step, err := (&NoiseConfig{Epsilon: 0.5, Sensitivity: 10}).scale()
+		assert.NoError(t, err)
+		assert.Equal(t, 0.1, step, "should round the values to about a hundredth of the sensitivity")
+		assert.Equal(t, big.NewRat(202, 1), scale, "should divide the sensitivity in steps, plus one, by epsilon with the laplace mechanism")
+		scale, step, _ = (&NoiseConfig{Epsilon: 0.5, Sensitivity: 10, Decimals: new(int)}).scale()
+		assert.Equal(t, 1.0, step, "should round the values to the decimals")
+		assert.Equal(t, big.NewRat(22, 1), scale)
+		scale, step, err = (&NoiseConfig{Mechanism: "gaussian", Epsilon: 0.5, Delta: 1e-5, Sensitivity: 1}).scale()
+		assert.NoError(t, err)
+		sigma, _ := scale.Float64()
+		assert.InDelta(t, 9.80, sigma*step, 0.01, "should calibrate the standard deviation with the zCDP bound")
+		_, _, err = (&NoiseConfig{Mechanism: "gaussian", Epsilon: 2, Delta: 1e-5, Sensitivity: 1}).scale()
+		assert.NoError(t, err, "should allow any epsilon with the gaussian mechanism")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []NoiseConfig{
+			NoiseConfig{Sensitivity: 1},
+			NoiseConfig{Epsilon: 1},
+			NoiseConfig{Epsilon: 1, Sensitivity: 1, Delta: 0.1},
+			NoiseConfig{Mechanism: "gaussian", Epsilon: 0.5, Sensitivity: 1},
+			NoiseConfig{Mechanism: "gaussian", Epsilon: 0.5, Sensitivity: 1, Delta: 1},
+			NoiseConfig{Mechanism: "uniform", Epsilon: 1, Sensitivity: 1},
+			NoiseConfig{Epsilon: 1, Sensitivity: 1, Min: float(1), Max: float(0)},
+			NoiseConfig{Epsilon: 1, Sensitivity: 1, Decimals: &negative},
+		} {
+			_, err := noise(conf)
+			assert.Error(t, err, "should return an error for %+v", conf)
+		}
+	})
+}
+
+func TestBernoulliExp(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, gamma := range []*big.Rat{big.NewRat(0, 1), big.NewRat(1, 2), big.NewRat(5, 2)} {
+		n, count := 20000, 0
+		for i := 0; i < n; i++ {
+			ok, err := bernoulliExp(rnd, gamma)
+			require.NoError(t, err)
+			if ok {
+				count++
+			}
+		}
+		g, _ := gamma.Float64()
+		assert.InDelta(t, math.Exp(-g), float64(count)/float64(n), 0.01, "should return true with probability exp(-%s)", gamma)
+	}
+}
+
+func TestDiscreteLaplace(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	n, counts := 20000, map[int64]int{}
+	for i := 0; i < n; i++ {
+		x, err := discreteLaplace(rnd, big.NewRat(1, 1))
+		require.NoError(t, err)
+		counts[x.Int64()]++
+	}
+	for _, x := range []int64{0, 1, -1, 2, -2} {
+		// P(x) = (1 - e^-1) / (1 + e^-1) * e^-|x|
+		p := math.Tanh(0.5) * math.Exp(-math.Abs(float64(x)))
+		assert.InDelta(t, p, float64(counts[x])/float64(n), 0.01, "should sample %d with its probability", x)
+	}
+}
+
+func TestDiscreteGaussian(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	n, sum, squares := 20000, 0.0, 0.0
+	for i := 0; i < n; i++ {
+		x, err := discreteGaussian(rnd, big.NewRat(9, 1))
+		require.NoError(t, err)
+		sum += float64(x.Int64())
+		squares += float64(x.Int64() * x.Int64())
+	}
+	mean := sum / float64(n)
+	assert.InDelta(t, 0, mean, 0.1, "should be centered at 0")
+	assert.InEpsilon(t, 9, squares/float64(n)-mean*mean, 0.05, "should have the variance")
+}
+
+func TestNoise(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// mean and variance of the noise added to n values
+	moments := func(conf NoiseConfig, value string, n int) (float64, float64) {
+		f, err := noiseWith(conf, rnd)
+		require.NoError(t, err)
+		sum, squares := 0.0, 0.0
+		for i := 0; i < n; i++ {
+			res, err := f(value)
+			require.NoError(t, err)
+			v, _ := strconv.ParseFloat(res, 64)
+			sum += v
+			squares += v * v
+		}
+		mean := sum / float64(n)
+		return mean, squares/float64(n) - mean*mean
+	}
+	t.Run("with the laplace mechanism", func(t *testing.T) {
+		conf := NoiseConfig{Epsilon: 1, Sensitivity: 2}
+		scale, step, _ := conf.scale()
+		b, _ := scale.Float64()
+		mean, variance := moments(conf, "100", 20000)
+		assert.InDelta(t, 100, mean, 0.1, "should add noise centered at 0")
+		assert.InEpsilon(t, 2*b*b*step*step, variance, 0.05, "should add noise with a variance of 2*scale^2")
+	})
+	t.Run("with the gaussian mechanism", func(t *testing.T) {
+		conf := NoiseConfig{Mechanism: "gaussian", Epsilon: 0.5, Delta: 1e-5, Sensitivity: 1}
+		scale, step, _ := conf.scale()
+		sigma, _ := scale.Float64()
+		mean, variance := moments(conf, "100", 20000)
+		assert.InDelta(t, 100, mean, 0.2, "should add noise centered at 0")
+		assert.InEpsilon(t, sigma*sigma*step*step, variance, 0.05, "should add noise with the standard deviation")
+	})
+	t.Run("on a grid", func(t *testing.T) {
+		f, err := noiseWith(NoiseConfig{Epsilon: 1, Sensitivity: 1}, rnd)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			res, err := f("1.23456")
+			require.NoError(t, err)
+			v, _ := strconv.ParseFloat(res, 64)
+			assert.Equal(t, strconv.FormatFloat(v, 'f', 2, 64), res, "should round the values to a hundredth of the sensitivity")
+		}
+	})
+	t.Run("with clamping and rounding", func(t *testing.T) {
+		f, err := noiseWith(NoiseConfig{Epsilon: 1, Sensitivity: 100, Min: float(0), Max: float(10), Decimals: new(int)}, rnd)
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			res, err := f("1000")
+			require.NoError(t, err)
+			v, err := strconv.Atoi(res)
+			require.NoError(t, err, "should round the values")
+			assert.True(t, v >= 0 && v <= 10, "should clamp the values")
+		}
+	})
+	t.Run("without random bytes", func(t *testing.T) {
+		f, _ := noiseWith(NoiseConfig{Epsilon: 1, Sensitivity: 1}, strings.NewReader(""))
+		res, err := f("1")
+		assert.Error(t, err, "should return an error")
+		assert.Equal(t, "1", res, "should return the input unchanged")
+	})
+	t.Run("with a value that is not a number", func(t *testing.T) {
+		f, _ := noiseWith(NoiseConfig{Epsilon: 1, Sensitivity: 1}, rnd)
+		_, err := f("a")
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("with crypto/rand", func(t *testing.T) {
+		f, _ := noise(NoiseConfig{Epsilon: 1, Sensitivity: 1})
+		values := map[string]bool{}
+		for i := 0; i < 10; i++ {
+			res, err := f("0")
+			require.NoError(t, err)
+			values[res] = true
+		}
+		assert.True(t, len(values) > 1, "should add random noise")
+	})
+}
+
+func TestPrivacyBudgets(t *testing.T) {
+	noise := func(epsilon float64, delta float64) ActionConfig {
+		return ActionConfig{Name: "noise", NoiseConfig: NoiseConfig{Epsilon: epsilon, Delta: delta}}
+	}
+	conf := &Config{Actions: []ActionConfig{
+		ActionConfig{Name: "nothing"},
+		noise(0.5, 0),
+		ActionConfig{Name: "pipeline", Column: "spend", Pipeline: []ActionConfig{noise(0.25, 1e-6), noise(0.5, 1e-6)}},
+	}}
+	assert.Equal(t, []PrivacyBudget{
+		PrivacyBudget{Column: "1", Epsilon: 0.5},
+		PrivacyBudget{Column: "spend", Epsilon: 0.75, Delta: 2e-6},
+	}, privacyBudgets(conf), "should add up the budget of the noise actions of each column")
+	output := &Config{Output: []OutputColumn{
+		OutputColumn{Source: "spend", Actions: []ActionConfig{noise(1, 0)}},
+		OutputColumn{Source: "spend", Name: "spend2", Actions: []ActionConfig{noise(2, 0)}},
+	}}
+	assert.Equal(t, []PrivacyBudget{
+		PrivacyBudget{Column: "spend", Epsilon: 1},
+		PrivacyBudget{Column: "spend2", Epsilon: 2},
+	}, privacyBudgets(output), "should report the budget of the output columns")
+}
+
+func TestProcessorProcessNoise(t *testing.T) {
+	conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Actions: []ActionConfig{
+		ActionConfig{Name: "noise", Column: "spend", NoiseConfig: NoiseConfig{Epsilon: 1, Sensitivity: 10, Min: float(0), Decimals: new(int)}},
+	}}
+	var out, report bytes.Buffer
+	stats := NewStats()
+	p, err := NewProcessor(conf, Options{Stats: stats})
+	require.NoError(t, err)
+	require.NoError(t, p.Process(strings.NewReader("id,spend\n1,100\n2,50\n"), &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3, "should write all the records")
+	for _, line := range lines[1:] {
+		_, err := strconv.Atoi(strings.Split(line, ",")[1])
+		assert.NoError(t, err, "should write the noisy values")
+	}
+	require.NoError(t, stats.WriteJSON(&report))
+	assert.Contains(t, report.String(), `"privacy": [`, "should report the privacy budget")
+	assert.Equal(t, []PrivacyBudget{PrivacyBudget{Column: "spend", Epsilon: 1}}, stats.Privacy)
+}
@@ -0,0 +1,147 @@
+package anon
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+)
+
+// OutputColumn is a column of the output, derived from a column of the
+// input. The same input column can be used by more than one output column.
+type OutputColumn struct {
+	// Name of the column in the output header, the
+	// name of the source column if it's not set
+	Name string
+	// Name of the input column the value is taken
+	// from, only used when the csv has a header
+	Source string
+	// Position of the input column the value is taken
+	// from, used when the csv doesn't have a header
+	SourceIndex uint32
+	// Actions applied in order to the value of the source column
+	Actions []ActionConfig
+	// If true, the column is a quasi-identifier the
+	// k-anonymity of the records is enforced on
+	QuasiIdentifier bool
+}
+
+// Checks that the output columns can be used with the rest of the config
+func checkOutput(conf *Config) error {
+	if len(conf.Output) == 0 {
+		return nil
+	} else if conf.Format != "" && conf.Format != FormatCsv {
+		return fmt.Errorf("output columns are only supported with the %s format", FormatCsv)
+	} else if len(conf.Actions) > 0 {
+		return errors.New("actions and output can't be both set, the actions go in each output column")
+	} else if len(conf.Csv.Rename) > 0 {
+		return errors.New("the columns can't be renamed when the output is set, set the name of the output columns instead")
+	}
+	return nil
+}
+
+// Returns the anonymisation of each output column,
+// that applies its actions in order
+func outputAnonymisations(output []OutputColumn) ([]Anonymisation, error) {
+	res := make([]Anonymisation, len(output))
+	for i, column := range output {
+		anons, err := anonymisations(column.Actions)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = chain(anons)
+	}
+	return res, nil
+}
+
+// Returns the reversal of each output column, that reverses
+// its encrypted actions in the opposite order they were applied
+func outputReversals(output []OutputColumn) ([]Anonymisation, error) {
+	res := make([]Anonymisation, len(output))
+	for i, column := range output {
+		var err error
+		if res[i], err = pipelineReversal(column.Actions); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Returns an anonymisation that applies the anonymisations in order,
+// stopping at the first one that fails. The errors handled by the error
+// policies don't stop it, the last one is returned with the result.
+func chain(anons []Anonymisation) Anonymisation {
+	return func(s string) (string, error) {
+		var handled error
+		for _, anon := range anons {
+			res, err := anon(s)
+			if errors.As(err, &handledError{}) {
+				handled = err
+			} else if err != nil {
+				return res, err
+			}
+			s = res
+		}
+		return s, handled
+	}
+}
+
+// Returns the position in the input of the source of each output column
+// and their names. If columns is nil, the csv doesn't have a header and
+// the sources are taken by their position.
+func outputColumns(output []OutputColumn, columns map[string]int) ([]int, []string, error) {
+	indices, names := make([]int, len(output)), make([]string, len(output))
+	for i, column := range output {
+		if columns == nil {
+			if column.Source != "" {
+				return nil, nil, fmt.Errorf("output column %d references column %s, but the csv doesn't have a header", i, column.Source)
+			}
+			indices[i], names[i] = int(column.SourceIndex), column.Name
+			continue
+		}
+		if column.Source == "" {
+			return nil, nil, fmt.Errorf("output column %d needs a source column when the csv has a header", i)
+		}
+		j, ok := columns[column.Source]
+		if !ok {
+			return nil, nil, fmt.Errorf("column %s not found in the header", column.Source)
+		}
+		indices[i], names[i] = j, column.Name
+		if names[i] == "" {
+			names[i] = column.Source
+		}
+	}
+	if columns != nil {
+		if _, err := headerIndices(names); err != nil {
+			return nil, nil, fmt.Errorf("invalid output header: %v", err)
+		}
+	}
+	return indices, names, nil
+}
+
+// Resolves the output columns against the header of the csv, so the
+// records read from r are projected to them, and writes their names as
+// the header (unless it's dropped). When revealing, the header is the one
+// written, so it's written unchanged and the reversals are already sorted.
+func readOutputHeader(r *csvReader, w *csv.Writer, header []string, conf *Config, anons *[]Anonymisation, revealing bool) (*[]Anonymisation, uint32, error) {
+	columns, err := headerIndices(header)
+	if err != nil {
+		return nil, 0, err
+	}
+	if revealing {
+		w.Write(header)
+		return anons, 0, nil
+	}
+	indices, names, err := outputColumns(conf.Output, columns)
+	if err != nil {
+		return nil, 0, err
+	}
+	idColumn, err := conf.Sampling.idColumnIndex(columns)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.columns = indices
+	if !conf.Csv.DropHeader {
+		w.Write(names)
+	}
+	return anons, idColumn, nil
+}
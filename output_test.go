@@ -0,0 +1,141 @@
+package anon
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOutput(t *testing.T) {
+	output := []OutputColumn{OutputColumn{Source: "id"}}
+	t.Run("with a valid config", func(t *testing.T) {
+		assert.NoError(t, checkOutput(&Config{Output: output}))
+		assert.NoError(t, checkOutput(&Config{Actions: []ActionConfig{ActionConfig{Name: "nothing"}}}), "should accept a config without output")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []*Config{
+			&Config{Format: FormatJSONL, Output: output},
+			&Config{Output: output, Actions: []ActionConfig{ActionConfig{Name: "nothing"}}},
+			&Config{Output: output, Csv: CsvConfig{Header: true, Rename: map[string]string{"id": "uid"}}},
+		} {
+			assert.Error(t, checkOutput(conf), "should return an error for %+v", conf)
+		}
+	})
+}
+
+func TestChain(t *testing.T) {
+	upper := func(s string) (string, error) { return strings.ToUpper(s), nil }
+	fail := func(s string) (string, error) { return "", errors.New("failed") }
+	t.Run("with no anonymisations", func(t *testing.T) {
+		res, err := chain(nil)("a")
+		assert.NoError(t, err)
+		assert.Equal(t, "a", res, "should return the value unchanged")
+	})
+	t.Run("with several anonymisations", func(t *testing.T) {
+		res, err := chain([]Anonymisation{outcode, upper})("a1 b2")
+		assert.NoError(t, err)
+		assert.Equal(t, "A1", res, "should apply them in order")
+	})
+	t.Run("when one of them fails", func(t *testing.T) {
+		_, err := chain([]Anonymisation{fail, upper})("a")
+		assert.EqualError(t, err, "failed", "should return its error")
+	})
+	t.Run("when the error of one of them is handled", func(t *testing.T) {
+		handled := func(s string) (string, error) { return "unknown", handledError{err: errors.New("failed")} }
+		res, err := chain([]Anonymisation{handled, upper})("a")
+		assert.Equal(t, handledError{err: errors.New("failed")}, err, "should return the error handled")
+		assert.Equal(t, "UNKNOWN", res, "should apply the rest to the value returned")
+	})
+}
+
+func TestOutputColumns(t *testing.T) {
+	columns := map[string]int{"id": 0, "email": 1, "date": 2}
+	t.Run("with a header", func(t *testing.T) {
+		indices, names, err := outputColumns([]OutputColumn{
+			OutputColumn{Source: "date"},
+			OutputColumn{Source: "email", Name: "email_hash"},
+			OutputColumn{Source: "date", Name: "year"},
+		}, columns)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2, 1, 2}, indices, "should return the position of the sources")
+		assert.Equal(t, []string{"date", "email_hash", "year"}, names, "should name the columns after their source if they don't have a name")
+	})
+	t.Run("without a header", func(t *testing.T) {
+		indices, _, err := outputColumns([]OutputColumn{OutputColumn{SourceIndex: 2}, OutputColumn{}}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2, 0}, indices, "should take the sources by position")
+	})
+	t.Run("with invalid output columns", func(t *testing.T) {
+		for _, output := range [][]OutputColumn{
+			{OutputColumn{SourceIndex: 1}},
+			{OutputColumn{Source: "postcode"}},
+			{OutputColumn{Source: "date"}, OutputColumn{Source: "id", Name: "date"}},
+		} {
+			_, _, err := outputColumns(output, columns)
+			assert.Error(t, err, "should return an error for %v", output)
+		}
+		_, _, err := outputColumns([]OutputColumn{OutputColumn{Source: "id"}}, nil)
+		assert.Error(t, err, "should return an error if a column is referenced by name without a header")
+	})
+}
+
+func TestProcessorProcessOutput(t *testing.T) {
+	output := []OutputColumn{
+		OutputColumn{Source: "id", Name: "id_hash", Actions: []ActionConfig{ActionConfig{Name: "hash", Salt: new(string)}}},
+		OutputColumn{Source: "date", Name: "year", Actions: []ActionConfig{ActionConfig{Name: "year", DateConfig: DateConfig{Format: "20060102"}}}},
+		OutputColumn{Source: "date"},
+	}
+	h, _ := hash("")("1")
+	t.Run("when the csv has a header", func(t *testing.T) {
+		var out bytes.Buffer
+		p, err := NewProcessor(&Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Output: output}, Options{})
+		require.NoError(t, err)
+		err = p.Process(strings.NewReader("email,id,date\na@b.com,1,20020202\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "id_hash,year,date\n"+h+",2002,20020202\n", out.String(), "should write the output columns only")
+	})
+	t.Run("when the csv doesn't have a header", func(t *testing.T) {
+		var out, rejects bytes.Buffer
+		conf := &Config{Csv: CsvConfig{Delimiter: ","}, Output: []OutputColumn{
+			OutputColumn{SourceIndex: 2},
+			OutputColumn{SourceIndex: 0, Actions: []ActionConfig{ActionConfig{Name: "outcode"}}},
+		}}
+		p, err := NewProcessor(conf, Options{Rejects: &rejects})
+		require.NoError(t, err)
+		err = p.Process(strings.NewReader("a1 b2,x,y\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Equal(t, "y,a1\n", out.String(), "should write the output columns in order")
+
+		err = p.Process(strings.NewReader("c3 d4,z\n"), &out)
+		assert.NoError(t, err, "should return no error")
+		assert.Contains(t, rejects.String(), "out of range", "should reject the records without the source columns")
+	})
+	t.Run("with an invalid action", func(t *testing.T) {
+		_, err := NewProcessor(&Config{Output: []OutputColumn{OutputColumn{Actions: []ActionConfig{ActionConfig{Name: "invalid"}}}}}, Options{})
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestRevealOutput(t *testing.T) {
+	os.Setenv("ANON_TEST_KEY", sivKey)
+	defer os.Unsetenv("ANON_TEST_KEY")
+	encrypt := ActionConfig{Name: "encrypt", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_KEY"}}}
+	conf := &Config{Csv: CsvConfig{Delimiter: ",", Header: true}, Output: []OutputColumn{
+		OutputColumn{Source: "postcode", Name: "outcode", Actions: []ActionConfig{ActionConfig{Name: "outcode"}, encrypt}},
+		OutputColumn{Source: "id"},
+	}}
+	var anonymised, out bytes.Buffer
+	p, err := NewProcessor(conf, Options{})
+	require.NoError(t, err)
+	require.NoError(t, p.Process(strings.NewReader("id,postcode\n1,a1 b2\n"), &anonymised))
+	assert.NotContains(t, anonymised.String(), "a1", "should encrypt the column")
+
+	err = Reveal(conf, &anonymised, &out)
+	assert.NoError(t, err, "should return no error")
+	assert.Equal(t, "outcode,id\na1,1\n", out.String(), "should decrypt the encrypted output columns")
+}
@@ -0,0 +1,1159 @@
+package anon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Physical types of the Parquet columns
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// Encodings of the Parquet pages
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingRLEDictionary   = 8
+)
+
+// Names of the encodings that can't be read, for the errors
+var unsupportedEncodings = map[int64]string{
+	4: "BIT_PACKED",
+	5: "DELTA_BINARY_PACKED",
+	6: "DELTA_LENGTH_BYTE_ARRAY",
+	7: "DELTA_BYTE_ARRAY",
+	9: "BYTE_STREAM_SPLIT",
+}
+
+// Types of the Parquet pages
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// Repetition types of the Parquet columns
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+	repetitionRepeated = 2
+)
+
+// Converted types that change how a value is formatted
+const (
+	convertedUTF8            = 0
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+)
+
+// Codecs that can be used to compress the Parquet pages
+var parquetCodecs = map[string]int64{
+	"uncompressed": 0,
+	"snappy":       1,
+	"gzip":         2,
+	"zstd":         6,
+}
+
+var parquetMagic = []byte("PAR1")
+
+// Default number of rows of each row group written
+const defaultRowGroupSize = 100000
+
+// Pages are written when they reach this size (before compressing them)
+const parquetPageSize = 1 << 20
+
+// Layout of the dates when they are anonymised as strings
+const parquetDateLayout = "2006-01-02"
+
+// ParquetConfig stores the config to write Parquet files
+type ParquetConfig struct {
+	// Either snappy (default), gzip, zstd or uncompressed
+	Compression string
+	// Number of rows of each row group, 100000 if not set
+	RowGroupSize int
+}
+
+// Checks the Parquet config and that every action has a column
+func checkParquetConfig(conf *Config) error {
+	if _, ok := parquetCodecs[conf.Parquet.Compression]; !ok && conf.Parquet.Compression != "" {
+		return fmt.Errorf("invalid Parquet compression %s, it must be one of snappy, gzip, zstd or uncompressed", conf.Parquet.Compression)
+	}
+	if conf.Parquet.RowGroupSize < 0 {
+		return errors.New("the Parquet row group size can't be negative")
+	}
+	for i, ac := range conf.Actions {
+		if ac.Column == "" {
+			return fmt.Errorf("action %d (%s) needs the name of a column of the Parquet schema", i, ac.Name)
+		}
+	}
+	return nil
+}
+
+// parquetColumn is a (non nested) column of a Parquet schema
+type parquetColumn struct {
+	// Element of the schema, written as it is to the output
+	element  tStruct
+	name     string
+	typ      int64
+	length   int
+	optional bool
+	// If true, int32 values are days since the epoch
+	date bool
+	// If not 0, int64 values are timestamps in this unit
+	timestampUnit time.Duration
+	// If true, the values can't be formatted as strings to anonymise them
+	unsupported bool
+}
+
+func newParquetColumn(element tStruct) (*parquetColumn, error) {
+	c := &parquetColumn{
+		element:  element,
+		name:     element.string(4),
+		typ:      element.int(1),
+		length:   int(element.int(2)),
+		optional: element.int(3) == repetitionOptional,
+	}
+	if element.int(5) > 0 || !element.has(1) || element.int(3) == repetitionRepeated {
+		return nil, fmt.Errorf("column %s is nested or repeated, only flat Parquet schemas are supported", c.name)
+	}
+	if c.typ < parquetBoolean || c.typ > parquetFixedLenByteArray {
+		return nil, fmt.Errorf("column %s has an invalid type %d", c.name, c.typ)
+	}
+	if c.typ == parquetFixedLenByteArray && c.length <= 0 {
+		return nil, fmt.Errorf("column %s has an invalid length %d", c.name, c.length)
+	}
+	logical := element.strct(10)
+	converted := int64(-1)
+	if element.has(6) {
+		converted = element.int(6)
+	}
+	switch {
+	case c.typ == parquetInt96 || converted == convertedDecimal || logical.has(5):
+		c.unsupported = true
+	case c.typ == parquetInt32 && (converted == convertedDate || logical.has(6)):
+		c.date = true
+	case c.typ == parquetInt64 && converted == convertedTimestampMillis:
+		c.timestampUnit = time.Millisecond
+	case c.typ == parquetInt64 && converted == convertedTimestampMicros:
+		c.timestampUnit = time.Microsecond
+	case c.typ == parquetInt64 && logical.has(8):
+		unit := logical.strct(8).strct(2)
+		c.timestampUnit = time.Nanosecond
+		if unit.has(1) {
+			c.timestampUnit = time.Millisecond
+		} else if unit.has(2) {
+			c.timestampUnit = time.Microsecond
+		}
+	}
+	return c, nil
+}
+
+// Returns the column written by an action applied to this column, given
+// the kind of values written by the action (or the steps of a pipeline).
+// The type is kept if the action doesn't change it, integers are written
+// as int32, numbers as doubles (unless they already are floating point)
+// and the rest of actions output strings.
+func (c *parquetColumn) output(ac *ActionConfig) *parquetColumn {
+	var typ int64
+	switch ac.outputKind() {
+	case kindInput:
+		return c
+	case kindInteger:
+		typ = parquetInt32
+	case kindNumber:
+		if c.typ == parquetFloat || c.typ == parquetDouble {
+			return c
+		}
+		typ = parquetDouble
+	default:
+		typ = parquetByteArray
+	}
+	element := tStruct{tI32(1, typ), tI32(3, c.element.int(3)), tBinary(4, c.name)}
+	if typ == parquetByteArray {
+		element = append(element, tI32(6, convertedUTF8))
+	}
+	if c.element.has(9) {
+		element = append(element, tI32(9, c.element.int(9)))
+	}
+	if typ == parquetByteArray {
+		// logical type STRING
+		element = append(element, tStructField(10, tStruct{tStructField(1, tStruct{})}))
+	}
+	out, _ := newParquetColumn(element)
+	return out
+}
+
+// Returns the value as the string that is anonymised
+func (c *parquetColumn) format(v interface{}) string {
+	switch x := v.(type) {
+	case bool:
+		return strconv.FormatBool(x)
+	case int32:
+		if c.date {
+			return time.Unix(int64(x)*86400, 0).UTC().Format(parquetDateLayout)
+		}
+		return strconv.FormatInt(int64(x), 10)
+	case int64:
+		if c.timestampUnit != 0 {
+			return time.Unix(0, 0).Add(time.Duration(x) * c.timestampUnit).UTC().Format(time.RFC3339Nano)
+		}
+		return strconv.FormatInt(x, 10)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case []byte:
+		return string(x)
+	}
+	return ""
+}
+
+// Parses an anonymised string into a value of the column.
+// Empty strings are nulls if the column is optional.
+func (c *parquetColumn) parse(s string) (interface{}, error) {
+	if s == "" && c.optional && c.typ != parquetByteArray {
+		return nil, nil
+	}
+	var v interface{}
+	var err error
+	switch c.typ {
+	case parquetBoolean:
+		v, err = strconv.ParseBool(s)
+	case parquetInt32:
+		if c.date {
+			var t time.Time
+			t, err = time.Parse(parquetDateLayout, s)
+			v = int32(t.Unix() / 86400)
+		} else {
+			var i int64
+			i, err = strconv.ParseInt(s, 10, 32)
+			v = int32(i)
+		}
+	case parquetInt64:
+		if c.timestampUnit != 0 {
+			var t time.Time
+			t, err = time.Parse(time.RFC3339Nano, s)
+			v = t.Sub(time.Unix(0, 0)).Nanoseconds() / int64(c.timestampUnit)
+		} else {
+			v, err = strconv.ParseInt(s, 10, 64)
+		}
+	case parquetFloat:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		v = float32(f)
+	case parquetDouble:
+		v, err = strconv.ParseFloat(s, 64)
+	case parquetByteArray:
+		v = []byte(s)
+	case parquetFixedLenByteArray:
+		if len(s) != c.length {
+			err = errors.New("wrong length")
+		}
+		v = []byte(s)
+	default:
+		err = errors.New("unsupported type")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the output can't be written to the %s column", parquetTypeNames[c.typ])
+	}
+	return v, nil
+}
+
+var parquetTypeNames = map[int64]string{
+	parquetBoolean:           "boolean",
+	parquetInt32:             "int32",
+	parquetInt64:             "int64",
+	parquetInt96:             "int96",
+	parquetFloat:             "float",
+	parquetDouble:            "double",
+	parquetByteArray:         "byte array",
+	parquetFixedLenByteArray: "fixed length byte array",
+}
+
+// parquetSchema stores the columns read and written of a Parquet file
+type parquetSchema struct {
+	root    tStruct
+	columns []*parquetColumn
+	// Index of each column by name
+	indices map[string]int
+	// Columns written, which can have a different type than the ones read
+	output []*parquetColumn
+	// Name of the action of each column, empty if it doesn't have one
+	actions []string
+	// Key-value metadata of the file, written as it is to the output
+	keyValues []tStruct
+}
+
+// Returns the schema of the file given its metadata. If reveal is true,
+// only the encrypted columns are reversed and their types are kept.
+func newParquetSchema(meta tStruct, actions []ActionConfig, reveal bool) (*parquetSchema, error) {
+	elements, err := meta.structs(2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Parquet schema: %v", err)
+	}
+	if len(elements) == 0 {
+		return nil, errors.New("the Parquet file doesn't have a schema")
+	}
+	s := &parquetSchema{root: elements[0]}
+	names := []string{}
+	for _, e := range elements[1:] {
+		c, err := newParquetColumn(e)
+		if err != nil {
+			return nil, err
+		}
+		s.columns = append(s.columns, c)
+		names = append(names, c.name)
+	}
+	if int(s.root.int(5)) != len(s.columns) {
+		return nil, errors.New("only flat Parquet schemas are supported")
+	}
+	if s.indices, err = headerIndices(names); err != nil {
+		return nil, err
+	}
+	if s.keyValues, err = meta.structs(5); err != nil {
+		return nil, fmt.Errorf("invalid Parquet metadata: %v", err)
+	}
+	s.output = append([]*parquetColumn{}, s.columns...)
+	s.actions = make([]string, len(s.columns))
+	for _, ac := range actions {
+		i, ok := s.indices[ac.Column]
+		if !ok {
+			return nil, fmt.Errorf("column %s not found in the Parquet schema", ac.Column)
+		}
+		if reveal && ac.Name != "encrypt" && ac.Name != "fpe" {
+			continue
+		}
+		if s.columns[i].unsupported {
+			return nil, fmt.Errorf("column %s has a %s type that can't be anonymised", ac.Column, parquetTypeNames[s.columns[i].typ])
+		}
+		s.actions[i] = ac.Name
+		if !reveal {
+			s.output[i] = s.columns[i].output(&ac)
+		}
+	}
+	for i := range s.columns {
+		if s.output[i] != s.columns[i] {
+			// Arrow restores the types of the columns from the schema
+			// it keeps in the metadata, which no longer match
+			s.keyValues = withoutKey(s.keyValues, "ARROW:schema")
+			break
+		}
+	}
+	return s, nil
+}
+
+// Returns the key-value metadata without the given key
+func withoutKey(keyValues []tStruct, key string) []tStruct {
+	res := []tStruct{}
+	for _, kv := range keyValues {
+		if kv.string(1) != key {
+			res = append(res, kv)
+		}
+	}
+	return res
+}
+
+// parquetRecord is a row of a Parquet file, nulls are nil
+type parquetRecord struct {
+	values []interface{}
+	schema *parquetSchema
+}
+
+// Anonymises the values of the columns with an action, converting them to
+// strings and back to the type of the output column. Nulls are left as nulls.
+func (r *parquetRecord) anonymise(anons []Anonymisation) error {
+	for i, v := range r.values {
+		if i >= len(anons) || r.schema.actions[i] == "" || v == nil {
+			continue
+		}
+		s := r.schema.columns[i].format(v)
+		res, err := anons[i](s)
+		if err != nil {
+			return columnError{i, s, err}
+		}
+		if r.values[i], err = r.schema.output[i].parse(res); err != nil {
+			return columnError{i, s, actionError{r.schema.actions[i], err}}
+		}
+	}
+	return nil
+}
+
+func (r *parquetRecord) eachValue(f func(column int, value string)) {
+	for i, v := range r.values {
+		f(i, r.schema.output[i].format(v))
+	}
+}
+
+// parquetReader reads the rows of a Parquet file, one row group at a time
+type parquetReader struct {
+	r         io.ReaderAt
+	schema    *parquetSchema
+	sampling  SamplingConfig
+	idColumn  int
+	rowGroups []tStruct
+	// Size of the file, that the column chunks must be within
+	size int64
+	// Position of the columns referenced by the actions, by their name
+	references map[string]int
+	// Values of the current row group by column
+	values [][]interface{}
+	row    int
+	line   int
+}
+
+// Returns a reader of the Parquet file. As the metadata is at the end of
+// the file, the input is copied to a temporary file if it can't be read
+// at random, that is removed when the reader is closed.
+func newParquetReader(in io.Reader, conf *Config, reveal bool) (*parquetReader, error) {
+	r, size, err := readerAt(in)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := parquetReaderAt(r, size, conf, reveal)
+	if err != nil {
+		closeInput(r)
+		return nil, err
+	}
+	return pr, nil
+}
+
+// Returns a reader of the Parquet file of the given size
+func parquetReaderAt(r io.ReaderAt, size int64, conf *Config, reveal bool) (*parquetReader, error) {
+	tail := make([]byte, 8)
+	if size < 12 {
+		return nil, errors.New("the input is not a Parquet file")
+	}
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return nil, err
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if !bytes.Equal(tail[4:], parquetMagic) || footerSize > size-12 {
+		return nil, errors.New("the input is not a Parquet file")
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-8-footerSize); err != nil {
+		return nil, err
+	}
+	meta, _, err := decodeThrift(footer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Parquet metadata: %v", err)
+	}
+	schema, err := newParquetSchema(meta, conf.Actions, reveal)
+	if err != nil {
+		return nil, err
+	}
+	rowGroups, err := meta.structs(4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Parquet metadata: %v", err)
+	}
+	pr := &parquetReader{r: r, schema: schema, sampling: conf.Sampling, rowGroups: rowGroups, size: size}
+	if !reveal {
+		idColumn, err := conf.Sampling.idColumnIndex(schema.indices)
+		if err != nil {
+			return nil, err
+		}
+		if int(idColumn) >= len(schema.columns) {
+			return nil, fmt.Errorf("id column (%d) out of range, the Parquet file has %d columns", idColumn, len(schema.columns))
+		}
+		pr.idColumn = int(idColumn)
+		if pr.references, err = resolveReferences(referencedColumns(conf), schema.indices); err != nil {
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// Returns the input as an io.ReaderAt with its size. If it can't be read
+// at random (e.g. it's a pipe) it's copied to a temporary file.
+func readerAt(in io.Reader) (io.ReaderAt, int64, error) {
+	if r, ok := in.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		// it fails if the input is a pipe
+		if size, err := r.Seek(0, io.SeekEnd); err == nil {
+			return r, size, nil
+		}
+	}
+	f, err := ioutil.TempFile("", "anon")
+	if err != nil {
+		return nil, 0, err
+	}
+	input := tempFile{f}
+	size, err := io.Copy(f, in)
+	if err != nil {
+		input.Close()
+		return nil, 0, err
+	}
+	return input, size, nil
+}
+
+// Removes the input if it's a temporary copy, see readerAt
+func closeInput(r io.ReaderAt) error {
+	if f, ok := r.(tempFile); ok {
+		return f.Close()
+	}
+	return nil
+}
+
+// Removes the copy of the input, if there is one
+func (pr *parquetReader) close() error {
+	return closeInput(pr.r)
+}
+
+// Rows with a null id are returned as items with an error
+func (pr *parquetReader) read() (item, error) {
+	for pr.values == nil || pr.row >= len(pr.values[0]) {
+		if len(pr.rowGroups) == 0 || len(pr.schema.columns) == 0 {
+			return item{}, io.EOF
+		}
+		if err := pr.readRowGroup(pr.rowGroups[0]); err != nil {
+			return item{}, err
+		}
+		pr.rowGroups = pr.rowGroups[1:]
+	}
+	values := make([]interface{}, len(pr.values))
+	for i := range values {
+		values[i] = pr.values[i][pr.row]
+	}
+	pr.row++
+	pr.line++
+	it := item{line: pr.line, record: &parquetRecord{values: values, schema: pr.schema}, sampled: true}
+	if id := values[pr.idColumn]; id != nil {
+		it.context.subject = pr.schema.columns[pr.idColumn].format(id)
+	}
+	if len(pr.references) > 0 {
+		it.context.references = make(map[string]string, len(pr.references))
+		for name, i := range pr.references {
+			if values[i] != nil {
+				it.context.references[name] = pr.schema.columns[i].format(values[i])
+			}
+		}
+	}
+	if pr.sampling.Mod > 1 {
+		if values[pr.idColumn] == nil {
+			it.err = errors.New("id column is null")
+			return it, nil
+		}
+		it.sampled = sample(it.context.subject, pr.sampling)
+	}
+	return it, nil
+}
+
+func (pr *parquetReader) readRowGroup(rg tStruct) error {
+	chunks, err := rg.structs(1)
+	if err != nil {
+		return fmt.Errorf("invalid row group: %v", err)
+	}
+	if len(chunks) != len(pr.schema.columns) {
+		return errors.New("the row group doesn't have a chunk for every column")
+	}
+	rows := rg.int(3)
+	if rows < 0 || rows > math.MaxInt32 {
+		return fmt.Errorf("invalid number of rows %d in the row group", rows)
+	}
+	pr.values = make([][]interface{}, len(chunks))
+	pr.row = 0
+	for i, chunk := range chunks {
+		values, err := pr.readColumnChunk(chunk, pr.schema.columns[i], int(rows))
+		if err != nil {
+			return fmt.Errorf("column %s: %v", pr.schema.columns[i].name, err)
+		}
+		pr.values[i] = values
+	}
+	return nil
+}
+
+// Reads the values of a column chunk, that must have the given number of rows
+func (pr *parquetReader) readColumnChunk(chunk tStruct, c *parquetColumn, rows int) ([]interface{}, error) {
+	meta := chunk.strct(3)
+	if meta == nil || chunk.has(1) {
+		return nil, errors.New("column chunks in other files are not supported")
+	}
+	start := meta.int(9)
+	if dictionary := meta.int(11); dictionary > 0 && dictionary < start {
+		start = dictionary
+	}
+	size := meta.int(7)
+	if start < 0 || size < 0 || start > pr.size || size > pr.size-start {
+		return nil, errors.New("invalid column chunk")
+	}
+	buf := make([]byte, size)
+	if _, err := pr.r.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	codec := meta.int(4)
+	// the number of rows comes from the metadata, so it's
+	// only trusted to allocate as much as the chunk's size
+	values := make([]interface{}, 0, min(rows, len(buf)))
+	var dictionary []interface{}
+	for len(values) < rows {
+		header, n, err := decodeThrift(buf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page header: %v", err)
+		}
+		buf = buf[n:]
+		pageSize := int(header.int(3))
+		if pageSize < 0 || pageSize > len(buf) {
+			return nil, errors.New("invalid page size")
+		}
+		page := buf[:pageSize]
+		buf = buf[pageSize:]
+		uncompressedSize := int(header.int(2))
+		switch header.int(1) {
+		case pageDictionary:
+			data, err := decompress(codec, page, uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+			if dictionary, err = decodePlain(data, c, int(header.strct(7).int(1))); err != nil {
+				return nil, err
+			}
+		case pageData:
+			data, err := decompress(codec, page, uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+			dh := header.strct(5)
+			n := dh.int(1)
+			if n < 0 || n > int64(rows-len(values)) {
+				return nil, errors.New("too many values in the column chunk")
+			}
+			var defs []int
+			if c.optional {
+				if len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+					return nil, errors.New("invalid definition levels")
+				}
+				l := int(binary.LittleEndian.Uint32(data))
+				if defs, err = decodeRLE(data[4:4+l], 1, int(n)); err != nil {
+					return nil, err
+				}
+				data = data[4+l:]
+			}
+			if values, err = decodeValues(values, data, dh.int(2), c, int(n), defs, dictionary); err != nil {
+				return nil, err
+			}
+		case pageDataV2:
+			dh := header.strct(8)
+			n := dh.int(1)
+			if n < 0 || n > int64(rows-len(values)) {
+				return nil, errors.New("too many values in the column chunk")
+			}
+			repLength, defLength := dh.int(6), dh.int(5)
+			if repLength < 0 || defLength < 0 || repLength > int64(len(page)) || defLength > int64(len(page))-repLength {
+				return nil, errors.New("invalid levels length")
+			}
+			var defs []int
+			if c.optional {
+				if defs, err = decodeRLE(page[repLength:repLength+defLength], 1, int(n)); err != nil {
+					return nil, err
+				}
+			}
+			data := page[repLength+defLength:]
+			if !dh.has(7) || dh.bool(7) {
+				if data, err = decompress(codec, data, uncompressedSize); err != nil {
+					return nil, err
+				}
+			}
+			if values, err = decodeValues(values, data, dh.int(4), c, int(n), defs, dictionary); err != nil {
+				return nil, err
+			}
+		}
+		if len(buf) == 0 && len(values) < rows {
+			return nil, errors.New("not enough values in the column chunk")
+		}
+	}
+	if len(values) != rows {
+		return nil, errors.New("too many values in the column chunk")
+	}
+	return values, nil
+}
+
+// Decodes the values of a data page, appending them to values.
+// Values with a definition level of 0 are null.
+func decodeValues(values []interface{}, data []byte, encoding int64, c *parquetColumn, n int, defs []int, dictionary []interface{}) ([]interface{}, error) {
+	nonNull := n
+	if defs != nil {
+		nonNull = 0
+		for _, d := range defs {
+			if d > 1 {
+				return nil, errors.New("invalid definition level")
+			}
+			nonNull += d
+		}
+	}
+	var decoded []interface{}
+	var err error
+	switch encoding {
+	case encodingPlain:
+		decoded, err = decodePlain(data, c, nonNull)
+	case encodingPlainDictionary, encodingRLEDictionary:
+		if dictionary == nil || len(data) == 0 {
+			return nil, errors.New("dictionary page not found")
+		}
+		var indices []int
+		if indices, err = decodeRLE(data[1:], int(data[0]), nonNull); err != nil {
+			return nil, err
+		}
+		decoded = make([]interface{}, len(indices))
+		for i, index := range indices {
+			if index >= len(dictionary) {
+				return nil, errors.New("dictionary index out of range")
+			}
+			decoded[i] = dictionary[index]
+		}
+	case encodingRLE:
+		if c.typ != parquetBoolean || len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+			return nil, errors.New("invalid RLE encoded values")
+		}
+		var bits []int
+		if bits, err = decodeRLE(data[4:4+binary.LittleEndian.Uint32(data)], 1, nonNull); err != nil {
+			return nil, err
+		}
+		decoded = make([]interface{}, len(bits))
+		for i, b := range bits {
+			decoded[i] = b == 1
+		}
+	default:
+		if name, ok := unsupportedEncodings[encoding]; ok {
+			return nil, fmt.Errorf("unsupported Parquet encoding %s", name)
+		}
+		return nil, fmt.Errorf("unsupported Parquet encoding %d", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, 0; i < n; i++ {
+		if defs == nil || defs[i] == 1 {
+			values = append(values, decoded[j])
+			j++
+		} else {
+			values = append(values, nil)
+		}
+	}
+	return values, nil
+}
+
+var errParquetValues = errors.New("not enough data for the values of the page")
+
+// Decodes n values with the plain encoding
+func decodePlain(data []byte, c *parquetColumn, n int) ([]interface{}, error) {
+	// byte arrays take at least the 4 bytes of their length
+	sizes := map[int64]int{parquetInt32: 4, parquetInt64: 8, parquetInt96: 12, parquetFloat: 4, parquetDouble: 8, parquetByteArray: 4, parquetFixedLenByteArray: c.length}
+	if size, ok := sizes[c.typ]; ok && n > len(data)/size || c.typ == parquetBoolean && n > 8*len(data) || n < 0 {
+		return nil, errParquetValues
+	}
+	values := make([]interface{}, n)
+	for i := range values {
+		switch c.typ {
+		case parquetBoolean:
+			values[i] = data[i/8]>>(i%8)&1 == 1
+		case parquetInt32:
+			values[i] = int32(binary.LittleEndian.Uint32(data[4*i:]))
+		case parquetInt64:
+			values[i] = int64(binary.LittleEndian.Uint64(data[8*i:]))
+		case parquetInt96:
+			values[i] = data[12*i : 12*(i+1)]
+		case parquetFloat:
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+		case parquetDouble:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		case parquetFixedLenByteArray:
+			values[i] = data[c.length*i : c.length*(i+1)]
+		case parquetByteArray:
+			if len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+				return nil, errParquetValues
+			}
+			l := int(binary.LittleEndian.Uint32(data))
+			values[i] = data[4 : 4+l]
+			data = data[4+l:]
+		}
+	}
+	return values, nil
+}
+
+// Decodes n values of the given bit width with the
+// RLE/bit-packing hybrid encoding
+func decodeRLE(data []byte, bitWidth int, n int) ([]int, error) {
+	if bitWidth > 32 {
+		return nil, errors.New("invalid bit width")
+	}
+	res := make([]int, 0, min(n, 8*len(data)))
+	byteWidth := (bitWidth + 7) / 8
+	for len(res) < n {
+		header, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, errors.New("not enough data for the RLE encoded values")
+		}
+		data = data[k:]
+		if header&1 == 0 {
+			// repeated value
+			if len(data) < byteWidth {
+				return nil, errors.New("not enough data for the RLE encoded values")
+			}
+			v := 0
+			for b := 0; b < byteWidth; b++ {
+				v |= int(data[b]) << (8 * b)
+			}
+			data = data[byteWidth:]
+			for count := header >> 1; count > 0 && len(res) < n; count-- {
+				res = append(res, v)
+			}
+		} else {
+			// bit-packed groups of 8 values
+			size := len(data)
+			if groups := header >> 1; groups < uint64(size) {
+				size = int(groups) * bitWidth
+				if size > len(data) {
+					size = len(data)
+				}
+			}
+			for bit := 0; bit+bitWidth <= size*8 && len(res) < n; bit += bitWidth {
+				v := 0
+				for b := 0; b < bitWidth; b++ {
+					v |= int(data[(bit+b)/8]>>((bit+b)%8)&1) << b
+				}
+				res = append(res, v)
+			}
+			data = data[size:]
+		}
+	}
+	return res, nil
+}
+
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecodeAllCapLimit(true))
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+var errPageSize = errors.New("the page is bigger than its uncompressed size")
+
+// Decompresses a page, that can't be bigger than the uncompressed size of
+// its header so a corrupted page can't take an arbitrary amount of memory
+func decompress(codec int64, data []byte, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, errors.New("invalid uncompressed page size")
+	}
+	switch codec {
+	case 0:
+		return data, nil
+	case 1:
+		if n, err := snappy.DecodedLen(data); err != nil {
+			return nil, err
+		} else if n > size {
+			return nil, errPageSize
+		}
+		return snappy.Decode(nil, data)
+	case 2:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		res, err := io.ReadAll(io.LimitReader(r, int64(size)+1))
+		if err == nil && len(res) > size {
+			return nil, errPageSize
+		}
+		return res, err
+	case 6:
+		res, err := zstdDecoder.DecodeAll(data, make([]byte, 0, size))
+		if err == zstd.ErrDecoderSizeExceeded {
+			return nil, errPageSize
+		}
+		return res, err
+	}
+	return nil, fmt.Errorf("unsupported Parquet compression codec %d", codec)
+}
+
+func compress(codec int64, data []byte) []byte {
+	switch codec {
+	case 1:
+		return snappy.Encode(nil, data)
+	case 2:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	case 6:
+		return zstdEncoder.EncodeAll(data, nil)
+	}
+	return data
+}
+
+// parquetWriter writes the rows to a Parquet file, buffering
+// them in memory until there are enough for a row group
+type parquetWriter struct {
+	w            *bufio.Writer
+	offset       int64
+	schema       *parquetSchema
+	codec        int64
+	rowGroupSize int
+	// Values of the buffered rows by column
+	values    [][]interface{}
+	rows      int
+	rowGroups []interface{}
+	numRows   int64
+}
+
+func newParquetWriter(out io.Writer, schema *parquetSchema, conf ParquetConfig) *parquetWriter {
+	pw := &parquetWriter{
+		w:            bufio.NewWriter(out),
+		schema:       schema,
+		codec:        parquetCodecs["snappy"],
+		rowGroupSize: defaultRowGroupSize,
+		values:       make([][]interface{}, len(schema.output)),
+	}
+	if conf.Compression != "" {
+		pw.codec = parquetCodecs[conf.Compression]
+	}
+	if conf.RowGroupSize > 0 {
+		pw.rowGroupSize = conf.RowGroupSize
+	}
+	return pw
+}
+
+func (pw *parquetWriter) write(rec record) error {
+	for i, v := range rec.(*parquetRecord).values {
+		pw.values[i] = append(pw.values[i], v)
+	}
+	pw.rows++
+	return nil
+}
+
+// Writes a row group if there are enough rows buffered
+func (pw *parquetWriter) flush() error {
+	if pw.rows >= pw.rowGroupSize {
+		if err := pw.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+	return pw.w.Flush()
+}
+
+// Writes the rows buffered and the metadata of the file
+func (pw *parquetWriter) close() error {
+	if err := pw.writeMagic(); err != nil {
+		return err
+	}
+	if pw.rows > 0 {
+		if err := pw.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+	elements := []interface{}{pw.schema.root}
+	for _, c := range pw.schema.output {
+		elements = append(elements, c.element)
+	}
+	meta := tStruct{
+		tI32(1, 1),
+		tListField(2, thriftStruct, elements...),
+		tI64(3, pw.numRows),
+		tListField(4, thriftStruct, pw.rowGroups...),
+	}
+	if len(pw.schema.keyValues) > 0 {
+		keyValues := []interface{}{}
+		for _, kv := range pw.schema.keyValues {
+			keyValues = append(keyValues, kv)
+		}
+		meta = append(meta, tListField(5, thriftStruct, keyValues...))
+	}
+	footer := encodeThrift(append(meta, tBinary(6, "anon")))
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	if err := pw.writeBytes(append(footer, parquetMagic...)); err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+func (pw *parquetWriter) writeBytes(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *parquetWriter) writeMagic() error {
+	if pw.offset > 0 {
+		return nil
+	}
+	return pw.writeBytes(parquetMagic)
+}
+
+func (pw *parquetWriter) writeRowGroup() error {
+	if err := pw.writeMagic(); err != nil {
+		return err
+	}
+	start := pw.offset
+	var chunks []interface{}
+	var uncompressed int64
+	for i, c := range pw.schema.output {
+		meta, err := pw.writeColumnChunk(c, pw.values[i])
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, tStruct{tI64(2, meta.int(9)), tStructField(3, meta)})
+		uncompressed += meta.int(6)
+		pw.values[i] = pw.values[i][:0]
+	}
+	pw.rowGroups = append(pw.rowGroups, tStruct{
+		tListField(1, thriftStruct, chunks...),
+		tI64(2, uncompressed),
+		tI64(3, int64(pw.rows)),
+		tI64(5, start),
+		tI64(6, pw.offset-start),
+	})
+	pw.numRows += int64(pw.rows)
+	pw.rows = 0
+	return nil
+}
+
+// Writes the values of a column in plain encoded data pages,
+// returning the metadata of the column chunk
+func (pw *parquetWriter) writeColumnChunk(c *parquetColumn, values []interface{}) (tStruct, error) {
+	start := pw.offset
+	var uncompressed int64
+	for len(values) > 0 {
+		n, size := 0, 0
+		for ; n < len(values) && size < parquetPageSize; n++ {
+			size += plainSize(values[n])
+		}
+		page := encodePage(c, values[:n])
+		compressed := compress(pw.codec, page)
+		header := encodeThrift(tStruct{
+			tI32(1, pageData),
+			tI32(2, int64(len(page))),
+			tI32(3, int64(len(compressed))),
+			tStructField(5, tStruct{
+				tI32(1, int64(n)),
+				tI32(2, encodingPlain),
+				tI32(3, encodingRLE),
+				tI32(4, encodingRLE),
+			}),
+		})
+		if err := pw.writeBytes(header); err != nil {
+			return nil, err
+		}
+		if err := pw.writeBytes(compressed); err != nil {
+			return nil, err
+		}
+		uncompressed += int64(len(header) + len(page))
+		values = values[n:]
+	}
+	return tStruct{
+		tI32(1, c.typ),
+		tListField(2, thriftI32, int64(encodingPlain), int64(encodingRLE)),
+		tListField(3, thriftBinary, []byte(c.name)),
+		tI32(4, pw.codec),
+		tI64(5, int64(pw.rows)),
+		tI64(6, uncompressed),
+		tI64(7, pw.offset-start),
+		tI64(9, start),
+	}, nil
+}
+
+// Approximate size of the value in the page
+func plainSize(v interface{}) int {
+	if b, ok := v.([]byte); ok {
+		return 4 + len(b)
+	}
+	return 8
+}
+
+// Encodes the definition levels (if the column is
+// optional) and the values of a data page
+func encodePage(c *parquetColumn, values []interface{}) []byte {
+	var buf []byte
+	if c.optional {
+		// bit-packed definition levels
+		groups := (len(values) + 7) / 8
+		levels := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+		packed := make([]byte, groups)
+		for i, v := range values {
+			if v != nil {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		levels = append(levels, packed...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(levels)))
+		buf = append(buf, levels...)
+	}
+	var bits []bool
+	for _, v := range values {
+		switch x := v.(type) {
+		case bool:
+			bits = append(bits, x)
+		case int32:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(x))
+		case int64:
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(x))
+		case float32:
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(x))
+		case float64:
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(x))
+		case []byte:
+			if c.typ == parquetByteArray {
+				buf = binary.LittleEndian.AppendUint32(buf, uint32(len(x)))
+			}
+			buf = append(buf, x...)
+		}
+	}
+	if bits != nil {
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		buf = append(buf, packed...)
+	}
+	return buf
+}
+
+// Anonymises a Parquet file, the output has the same
+// schema except for the types changed by the actions
+func processParquet(in io.Reader, out io.Writer, conf *Config, anons []Anonymisation, opts processOptions) error {
+	r, err := newParquetReader(in, conf, false)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+	if anons, err = byColumn(conf.Actions, anons, r.schema.indices); err != nil {
+		return err
+	}
+	opts.byContext = contextByColumn(conf.Actions, opts.byContext, r.schema.indices)
+	opts.quasiIdentifiers = columnPositions(conf.Actions, opts.quasiIdentifiers, r.schema.indices)
+	return processRecords(r, newParquetWriter(out, r.schema, conf.Parquet), anons, opts)
+}
+
+// Reverses the encrypted columns of a Parquet file
+func revealParquet(in io.Reader, out io.Writer, conf *Config, revs []Anonymisation) error {
+	r, err := newParquetReader(in, conf, true)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+	if revs, err = byColumn(conf.Actions, revs, r.schema.indices); err != nil {
+		return err
+	}
+	return reveal(r, newParquetWriter(out, r.schema, conf.Parquet), revs)
+}
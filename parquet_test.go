@@ -0,0 +1,446 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testParquetElements = []interface{}{
+	tStruct{tBinary(4, "schema"), tI32(5, 4)},
+	tStruct{tI32(1, parquetInt64), tI32(3, repetitionRequired), tBinary(4, "id")},
+	tStruct{tI32(1, parquetByteArray), tI32(3, repetitionOptional), tBinary(4, "name"), tI32(6, convertedUTF8)},
+	tStruct{tI32(1, parquetInt32), tI32(3, repetitionOptional), tBinary(4, "dob"), tI32(6, convertedDate)},
+	tStruct{tI32(1, parquetDouble), tI32(3, repetitionRequired), tBinary(4, "score")},
+}
+
+func testParquetSchema(t *testing.T, elements []interface{}, actions []ActionConfig) *parquetSchema {
+	schema, err := newParquetSchema(tStruct{tListField(2, thriftStruct, elements...)}, actions, false)
+	require.NoError(t, err)
+	return schema
+}
+
+// Writes the rows to a Parquet file with the schema
+func writeParquet(t *testing.T, schema *parquetSchema, conf ParquetConfig, rows ...[]interface{}) []byte {
+	var out bytes.Buffer
+	w := newParquetWriter(&out, schema, conf)
+	for _, row := range rows {
+		require.NoError(t, w.write(&parquetRecord{values: row, schema: schema}))
+		require.NoError(t, w.flush())
+	}
+	require.NoError(t, w.close())
+	return out.Bytes()
+}
+
+// Reads the schema and the rows of a Parquet file
+func readParquet(t *testing.T, data []byte) (*parquetSchema, [][]interface{}) {
+	r, err := newParquetReader(bytes.NewReader(data), &Config{}, false)
+	require.NoError(t, err)
+	var rows [][]interface{}
+	for {
+		it, err := r.read()
+		if err == io.EOF {
+			return r.schema, rows
+		}
+		require.NoError(t, err)
+		rows = append(rows, it.record.(*parquetRecord).values)
+	}
+}
+
+func epochDays(s string) int32 {
+	t, _ := time.Parse(parquetDateLayout, s)
+	return int32(t.Unix() / 86400)
+}
+
+func TestParquetWriterReader(t *testing.T) {
+	rows := [][]interface{}{
+		{int64(1), []byte("a"), epochDays("2002-02-02"), 1.5},
+		{int64(2), nil, nil, -2.0},
+		{int64(3), []byte(""), epochDays("1960-12-31"), 0.0},
+	}
+	for codec := range parquetCodecs {
+		t.Run("with "+codec+" compression", func(t *testing.T) {
+			schema := testParquetSchema(t, testParquetElements, nil)
+			data := writeParquet(t, schema, ParquetConfig{Compression: codec, RowGroupSize: 2}, rows...)
+			read, res := readParquet(t, data)
+			assert.Equal(t, rows, res, "should read the same rows")
+			assert.Equal(t, schema.columns, read.columns, "should read the same schema")
+		})
+	}
+	t.Run("without rows", func(t *testing.T) {
+		_, res := readParquet(t, writeParquet(t, testParquetSchema(t, testParquetElements, nil), ParquetConfig{}))
+		assert.Empty(t, res)
+	})
+}
+
+func TestParquetReader(t *testing.T) {
+	t.Run("with a file written by another implementation", func(t *testing.T) {
+		// parquet_test.parquet was written by github.com/xitongsys/parquet-go
+		// v1.6.2 with snappy, 3 row groups of 4 rows and dictionary pages
+		// in the optional city and dob columns
+		data, err := ioutil.ReadFile("parquet_test.parquet")
+		require.NoError(t, err)
+		cities := []string{"London", "Madrid", "Paris"}
+		var expected [][]interface{}
+		for i := 0; i < 12; i++ {
+			row := []interface{}{int64(i), []byte(cities[i%3]), int32(10000 + i), float64(i) / 2}
+			if i%4 == 3 {
+				row[1] = nil
+			}
+			if i%5 == 4 {
+				row[2] = nil
+			}
+			if i%2 == 1 {
+				row[3] = nil
+			}
+			expected = append(expected, row)
+		}
+		r, err := newParquetReader(bytes.NewReader(data), &Config{}, false)
+		require.NoError(t, err)
+		assert.Len(t, r.rowGroups, 3)
+		schema, rows := readParquet(t, data)
+		assert.Equal(t, expected, rows, "should read every row group with the nulls")
+		assert.True(t, schema.columns[2].date, "should read the logical types")
+
+		var out bytes.Buffer
+		salt := ""
+		p, err := NewProcessor(&Config{Format: FormatParquet, Actions: []ActionConfig{
+			ActionConfig{Name: "hash", Column: "city", Salt: &salt},
+			ActionConfig{Name: "year", Column: "dob", DateConfig: DateConfig{Format: parquetDateLayout}},
+		}}, Options{})
+		require.NoError(t, err)
+		require.NoError(t, p.Process(bytes.NewReader(data), &out))
+		_, rows = readParquet(t, out.Bytes())
+		require.Len(t, rows, 12)
+		assert.Equal(t, []interface{}{int64(0), []byte("4c57f0c88d9844630327623633ce269cf826ab99"), int32(1997), 0.0}, rows[0], "should anonymise the values of the dictionary pages")
+		assert.Equal(t, []interface{}{int64(3), nil, int32(1997), nil}, rows[3], "should keep the nulls")
+	})
+	t.Run("with dictionary and v2 data pages", func(t *testing.T) {
+		// a single optional string column with the values "x", null, "y", "x"
+		dictionary := []byte{1, 0, 0, 0, 'x', 1, 0, 0, 0, 'y'}
+		// definition levels 1, 0, 1, 1 and indices 0, 1, 0 as RLE runs
+		levels := []byte{0x02, 0x01, 0x02, 0x00, 0x04, 0x01}
+		indices := []byte{1, 0x02, 0x00, 0x02, 0x01, 0x02, 0x00}
+		var file bytes.Buffer
+		file.Write(parquetMagic)
+		dictionaryHeader := encodeThrift(tStruct{
+			tI32(1, pageDictionary), tI32(2, int64(len(dictionary))), tI32(3, int64(len(dictionary))),
+			tStructField(7, tStruct{tI32(1, 2), tI32(2, encodingPlain)}),
+		})
+		dataHeader := encodeThrift(tStruct{
+			tI32(1, pageDataV2), tI32(2, int64(len(levels)+len(indices))), tI32(3, int64(len(levels)+len(indices))),
+			tStructField(8, tStruct{
+				tI32(1, 4), tI32(2, 1), tI32(3, 4), tI32(4, encodingRLEDictionary), tI32(5, int64(len(levels))), tI32(6, 0),
+				tField{id: 7, typ: thriftBoolFalse, value: false},
+			}),
+		})
+		for _, b := range [][]byte{dictionaryHeader, dictionary, dataHeader, levels, indices} {
+			file.Write(b)
+		}
+		footer := encodeThrift(tStruct{
+			tI32(1, 1),
+			tListField(2, thriftStruct,
+				tStruct{tBinary(4, "schema"), tI32(5, 1)},
+				tStruct{tI32(1, parquetByteArray), tI32(3, repetitionOptional), tBinary(4, "s")},
+			),
+			tI64(3, 4),
+			tListField(4, thriftStruct, tStruct{
+				tListField(1, thriftStruct, tStruct{tI64(2, 4), tStructField(3, tStruct{
+					tI32(1, parquetByteArray), tI32(4, 0), tI64(5, 4),
+					tI64(7, int64(file.Len()-4)), tI64(9, int64(4+len(dictionaryHeader)+len(dictionary))), tI64(11, 4),
+				})}),
+				tI64(3, 4),
+			}),
+		})
+		file.Write(footer)
+		file.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+		file.Write(parquetMagic)
+
+		_, rows := readParquet(t, file.Bytes())
+		assert.Equal(t, [][]interface{}{{[]byte("x")}, {nil}, {[]byte("y")}, {[]byte("x")}}, rows)
+	})
+	t.Run("from a stream", func(t *testing.T) {
+		data := writeParquet(t, testParquetSchema(t, testParquetElements, nil), ParquetConfig{}, []interface{}{int64(1), nil, nil, 1.0})
+		r, err := newParquetReader(io.MultiReader(bytes.NewReader(data)), &Config{}, false)
+		require.NoError(t, err)
+		f, ok := r.r.(tempFile)
+		require.True(t, ok, "should copy the input to a temporary file")
+		_, err = r.read()
+		assert.NoError(t, err)
+		assert.NoError(t, r.close())
+		_, err = os.Stat(f.Name())
+		assert.True(t, os.IsNotExist(err), "should remove the temporary file when it's closed")
+	})
+	t.Run("with an input that is not a Parquet file", func(t *testing.T) {
+		_, err := newParquetReader(strings.NewReader("a,b,c\n1,2,3\n"), &Config{}, false)
+		assert.Error(t, err, "should return an error")
+		_, err = newParquetReader(io.MultiReader(strings.NewReader("a,b,c\n1,2,3\n")), &Config{}, false)
+		assert.Error(t, err, "should return an error from a stream")
+	})
+	t.Run("with a nested schema", func(t *testing.T) {
+		elements := []interface{}{
+			tStruct{tBinary(4, "schema"), tI32(5, 1)},
+			tStruct{tI32(3, repetitionRequired), tBinary(4, "user"), tI32(5, 1)},
+			tStruct{tI32(1, parquetByteArray), tI32(3, repetitionRequired), tBinary(4, "name")},
+		}
+		_, err := newParquetSchema(tStruct{tListField(2, thriftStruct, elements...)}, nil, false)
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("with an action on an unknown column", func(t *testing.T) {
+		_, err := newParquetSchema(tStruct{tListField(2, thriftStruct, testParquetElements...)}, []ActionConfig{ActionConfig{Name: "hash", Column: "other"}}, false)
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("with key-value metadata", func(t *testing.T) {
+		keyValues := []interface{}{
+			tStruct{tBinary(1, "writer.version"), tBinary(2, "1")},
+			tStruct{tBinary(1, "ARROW:schema"), tBinary(2, "...")},
+		}
+		meta := tStruct{tListField(2, thriftStruct, testParquetElements...), tListField(5, thriftStruct, keyValues...)}
+		for _, c := range []struct {
+			actions  []ActionConfig
+			expected []tStruct
+		}{
+			{nil, []tStruct{keyValues[0].(tStruct), keyValues[1].(tStruct)}},
+			{[]ActionConfig{ActionConfig{Name: "year", Column: "dob"}}, []tStruct{keyValues[0].(tStruct)}},
+		} {
+			schema, err := newParquetSchema(meta, c.actions, false)
+			require.NoError(t, err)
+			read, _ := readParquet(t, writeParquet(t, schema, ParquetConfig{}))
+			assert.Equal(t, c.expected, read.keyValues, "should keep the metadata that still matches the output with %v", c.actions)
+		}
+	})
+	t.Run("with an unsupported encoding", func(t *testing.T) {
+		_, err := decodeValues(nil, []byte{0, 0, 0, 0}, 5, testParquetSchema(t, testParquetElements, nil).columns[0], 1, nil, nil)
+		assert.EqualError(t, err, "unsupported Parquet encoding DELTA_BINARY_PACKED", "should name the encoding")
+	})
+	t.Run("with a schema element that is not a struct", func(t *testing.T) {
+		_, err := newParquetSchema(tStruct{tListField(2, thriftI32, int64(1), int64(2))}, nil, false)
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("with corrupted metadata", func(t *testing.T) {
+		schema := testParquetSchema(t, testParquetElements, nil)
+		outOfFile := tStruct{tStructField(3, tStruct{tI64(7, 1<<40), tI64(9, 4)})}
+		for name, rowGroup := range map[string]tStruct{
+			"a negative number of rows":    {tListField(1, thriftStruct, tStruct{}, tStruct{}, tStruct{}, tStruct{}), tI64(3, -1)},
+			"a chunk that is not a struct": {tListField(1, thriftI32, int64(1), int64(2), int64(3), int64(4)), tI64(3, 1)},
+			"a chunk out of the file":      {tListField(1, thriftStruct, outOfFile, outOfFile, outOfFile, outOfFile), tI64(3, 1)},
+		} {
+			pr := &parquetReader{r: bytes.NewReader(make([]byte, 100)), schema: schema, size: 100, rowGroups: []tStruct{rowGroup}}
+			_, err := pr.read()
+			assert.Error(t, err, "should return an error with %s", name)
+			assert.NotContains(t, err.Error(), "chunk for every column", "should check the %s", name)
+		}
+	})
+}
+
+func TestParquetReaderProperties(t *testing.T) {
+	data, err := ioutil.ReadFile("parquet_test.parquet")
+	require.NoError(t, err)
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 2000
+	properties := gopter.NewProperties(parameters)
+	properties.Property("reading a corrupted file returns an error instead of panicking", prop.ForAll(
+		func(positions []int, values []byte) bool {
+			corrupted := append([]byte{}, data...)
+			for i, p := range positions {
+				corrupted[p] = values[i%len(values)]
+			}
+			r, err := newParquetReader(bytes.NewReader(corrupted), &Config{}, false)
+			for err == nil {
+				_, err = r.read()
+			}
+			return true
+		},
+		gen.SliceOfN(4, gen.IntRange(0, len(data)-1)),
+		gen.SliceOfN(4, gen.UInt8()),
+	))
+	properties.TestingRun(t)
+}
+
+func FuzzParquetReader(f *testing.F) {
+	data, err := ioutil.ReadFile("parquet_test.parquet")
+	require.NoError(f, err)
+	f.Add(data)
+	for codec := range parquetCodecs {
+		schema, err := newParquetSchema(tStruct{tListField(2, thriftStruct, testParquetElements...)}, nil, false)
+		require.NoError(f, err)
+		var out bytes.Buffer
+		w := newParquetWriter(&out, schema, ParquetConfig{Compression: codec})
+		require.NoError(f, w.write(&parquetRecord{values: []interface{}{int64(1), []byte("a"), nil, 1.5}, schema: schema}))
+		require.NoError(f, w.close())
+		f.Add(out.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := newParquetReader(bytes.NewReader(data), &Config{}, false)
+		for err == nil {
+			_, err = r.read()
+		}
+	})
+}
+
+func TestDecodeRLE(t *testing.T) {
+	t.Run("with runs and bit-packed values", func(t *testing.T) {
+		// 3 times 5, then 8 bit-packed values of 3 bits: 0 to 7
+		data := []byte{0x06, 0x05, 0x03, 0x88, 0xc6, 0xfa}
+		values, err := decodeRLE(data, 3, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{5, 5, 5, 0, 1, 2, 3, 4, 5, 6}, values)
+	})
+	t.Run("with not enough data", func(t *testing.T) {
+		_, err := decodeRLE([]byte{0x06, 0x05}, 3, 4)
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestParquetColumn(t *testing.T) {
+	timestamp, _ := newParquetColumn(tStruct{
+		tI32(1, parquetInt64), tBinary(4, "ts"),
+		tStructField(10, tStruct{tStructField(8, tStruct{tField{id: 1, typ: thriftBoolTrue, value: true}, tStructField(2, tStruct{tStructField(2, tStruct{})})})}),
+	})
+	dob, _ := newParquetColumn(testParquetElements[3].(tStruct))
+	t.Run("formats and parses the values", func(t *testing.T) {
+		for _, c := range []struct {
+			column *parquetColumn
+			value  interface{}
+			s      string
+		}{
+			{timestamp, int64(1500000000123456), "2017-07-14T02:40:00.123456Z"},
+			{dob, epochDays("2002-02-02"), "2002-02-02"},
+			{dob, nil, ""},
+		} {
+			assert.Equal(t, c.s, c.column.format(c.value))
+			v, err := c.column.parse(c.s)
+			assert.NoError(t, err)
+			assert.Equal(t, c.value, v)
+		}
+	})
+	t.Run("with a value that can't be parsed", func(t *testing.T) {
+		_, err := dob.parse("02/02/2002")
+		assert.EqualError(t, err, "the output can't be written to the int32 column", "should not include the value in the error")
+	})
+	t.Run("with the output of an action", func(t *testing.T) {
+		score, _ := newParquetColumn(testParquetElements[4].(tStruct))
+		assert.Equal(t, dob, dob.output(&ActionConfig{Name: "nothing"}), "should keep the type")
+		assert.Equal(t, dob, dob.output(&ActionConfig{Name: "dateShift"}), "should keep the type")
+		assert.Equal(t, int64(parquetInt32), dob.output(&ActionConfig{Name: "year"}).typ)
+		assert.False(t, dob.output(&ActionConfig{Name: "year"}).date, "should output plain integers")
+		assert.Equal(t, int64(parquetInt32), dob.output(&ActionConfig{Name: "date", DateConfig: DateConfig{Granularity: "year"}}).typ, "should output integers with the year granularity")
+		assert.Equal(t, int64(parquetByteArray), dob.output(&ActionConfig{Name: "date", DateConfig: DateConfig{Granularity: "month"}}).typ, "should output strings with other granularities")
+		assert.Equal(t, int64(parquetDouble), dob.output(&ActionConfig{Name: "noise"}).typ, "should output doubles with noise")
+		assert.Equal(t, score, score.output(&ActionConfig{Name: "noise"}), "should keep the type of doubles with noise")
+		assert.Equal(t, int64(parquetInt32), dob.output(&ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{{Name: "year"}, {Name: "nothing"}}}).typ, "should output the values of the last step that changes them")
+		assert.Equal(t, int64(parquetByteArray), dob.output(&ActionConfig{Name: "pipeline", Pipeline: []ActionConfig{{Name: "year"}, {Name: "hash"}}}).typ)
+		assert.Equal(t, int64(parquetByteArray), dob.output(&ActionConfig{Name: "hash"}).typ, "should output strings otherwise")
+		assert.True(t, dob.output(&ActionConfig{Name: "hash"}).optional, "should keep the repetition")
+	})
+}
+
+func TestProcessorProcessParquet(t *testing.T) {
+	input := writeParquet(t, testParquetSchema(t, testParquetElements, nil), ParquetConfig{},
+		[]interface{}{int64(1), []byte("a"), epochDays("2002-02-02"), 10.0},
+		[]interface{}{int64(2), nil, nil, 70.0},
+		[]interface{}{int64(3), []byte("b"), epochDays("1999-01-01"), 200.0},
+	)
+	fifty, hundred, low, high, salt := 50.0, 100.0, "low", "high", ""
+	actions := []ActionConfig{
+		ActionConfig{Name: "year", Column: "dob", DateConfig: DateConfig{Format: parquetDateLayout}},
+		ActionConfig{Name: "ranges", Column: "score", RangeConfig: []RangeConfig{
+			{Lt: &fifty, Output: &low},
+			{Gte: &fifty, Lt: &hundred, Output: &high},
+		}},
+		ActionConfig{Name: "hash", Column: "name", Salt: &salt},
+	}
+	t.Run("with a valid config", func(t *testing.T) {
+		var out, rejects bytes.Buffer
+		p, err := NewProcessor(&Config{Format: FormatParquet, Actions: actions}, Options{Rejects: &rejects})
+		require.NoError(t, err)
+		err = p.Process(bytes.NewReader(input), &out)
+		assert.NoError(t, err, "should return no error")
+		schema, rows := readParquet(t, out.Bytes())
+		assert.Equal(t, [][]interface{}{
+			{int64(1), []byte("86f7e437faa5a7fce15d1ddcb9eaeaea377667b8"), int32(2002), []byte("low")},
+			{int64(2), nil, nil, []byte("high")},
+		}, rows, "should anonymise the columns keeping the nulls")
+		assert.Equal(t, []int64{parquetInt64, parquetByteArray, parquetInt32, parquetByteArray},
+			[]int64{schema.columns[0].typ, schema.columns[1].typ, schema.columns[2].typ, schema.columns[3].typ},
+			"should change the types of the columns as the actions output")
+		assert.Equal(t, "line,column,action,reason,value\n3,3,ranges,No range defined for value,\n", rejects.String(), "should reject the rows that can't be anonymised")
+	})
+	t.Run("with actions that output numbers", func(t *testing.T) {
+		five := 5.0
+		dateConfig := DateConfig{Format: parquetDateLayout}
+		for _, c := range []struct {
+			action   ActionConfig
+			typ      int64
+			expected []interface{}
+		}{
+			{ActionConfig{Name: "noise", Column: "score", NoiseConfig: NoiseConfig{Epsilon: 1, Sensitivity: 1, Min: &five, Max: &five}}, parquetDouble, []interface{}{5.0, 5.0, 5.0}},
+			{ActionConfig{Name: "noise", Column: "id", NoiseConfig: NoiseConfig{Epsilon: 1, Sensitivity: 1, Min: &five, Max: &five}}, parquetDouble, []interface{}{5.0, 5.0, 5.0}},
+			{ActionConfig{Name: "date", Column: "dob", DateConfig: DateConfig{Format: parquetDateLayout, Granularity: "year"}}, parquetInt32, []interface{}{int32(2002), nil, int32(1999)}},
+			{ActionConfig{Name: "pipeline", Column: "dob", Pipeline: []ActionConfig{
+				{Name: "date", DateConfig: DateConfig{Format: parquetDateLayout, Granularity: "month", Output: parquetDateLayout}},
+				{Name: "year", DateConfig: dateConfig},
+			}}, parquetInt32, []interface{}{int32(2002), nil, int32(1999)}},
+		} {
+			var out bytes.Buffer
+			p, err := NewProcessor(&Config{Format: FormatParquet, Actions: []ActionConfig{c.action}}, Options{})
+			require.NoError(t, err)
+			require.NoError(t, p.Process(bytes.NewReader(input), &out))
+			schema, rows := readParquet(t, out.Bytes())
+			i := schema.indices[c.action.Column]
+			assert.Equal(t, c.typ, schema.columns[i].typ, "should write the type of the values of %s", c.action.Name)
+			var values []interface{}
+			for _, row := range rows {
+				values = append(values, row[i])
+			}
+			assert.Equal(t, c.expected, values, "should write the values of %s as numbers", c.action.Name)
+		}
+	})
+	t.Run("from a stream", func(t *testing.T) {
+		var out bytes.Buffer
+		p, err := NewProcessor(&Config{Format: FormatParquet, Sampling: SamplingConfig{Mod: 2, IDColumnName: "id"}}, Options{})
+		require.NoError(t, err)
+		err = p.Process(io.MultiReader(bytes.NewReader(input)), &out)
+		assert.NoError(t, err, "should read the whole input")
+		_, rows := readParquet(t, out.Bytes())
+		assert.Len(t, rows, 2, "should sample the rows by the id column")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []*Config{
+			&Config{Format: FormatParquet, Parquet: ParquetConfig{Compression: "lzo"}},
+			&Config{Format: FormatParquet, Actions: []ActionConfig{ActionConfig{Name: "hash"}}},
+		} {
+			_, err := NewProcessor(conf, Options{})
+			assert.Error(t, err, "should return an error")
+		}
+	})
+}
+
+func TestRevealParquet(t *testing.T) {
+	t.Setenv("ANON_TEST_PARQUET_KEY", sivKey)
+	conf := &Config{
+		Format:  FormatParquet,
+		Actions: []ActionConfig{ActionConfig{Name: "encrypt", Column: "name", EncryptConfig: EncryptConfig{KeyConfig{KeyEnv: "ANON_TEST_PARQUET_KEY"}}}},
+	}
+	rows := [][]interface{}{{int64(1), []byte("a"), epochDays("2002-02-02"), 1.0}, {int64(2), nil, nil, 2.0}}
+	input := writeParquet(t, testParquetSchema(t, testParquetElements, nil), ParquetConfig{}, rows...)
+	var anonymised, revealed bytes.Buffer
+	p, err := NewProcessor(conf, Options{})
+	require.NoError(t, err)
+	require.NoError(t, p.Process(bytes.NewReader(input), &anonymised))
+	err = Reveal(conf, &anonymised, &revealed)
+	assert.NoError(t, err, "should return no error")
+	_, res := readParquet(t, revealed.Bytes())
+	assert.Equal(t, rows, res, "should decrypt the encrypted columns")
+}
@@ -0,0 +1,272 @@
+package anon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Number of records sent together to a worker
+const batchSize = 128
+
+// record is a record of the input in any of the supported formats
+type record interface {
+	// Anonymises the values of the record in place
+	anonymise(anons []Anonymisation) error
+	// Calls f with each value of the record and the index of its column
+	eachValue(f func(column int, value string))
+}
+
+// recordReader reads the records of the input
+type recordReader interface {
+	// Returns the next record. Records that can't be parsed are returned as
+	// items with an error, any other error reading the input is returned.
+	read() (item, error)
+}
+
+// recordWriter writes the records to the output
+type recordWriter interface {
+	write(rec record) error
+	flush() error
+	// Flushes the records and finishes the output
+	close() error
+}
+
+// item is a record that goes through the process
+type item struct {
+	line    int
+	record  record
+	sampled bool
+	// Context the anonymisations that depend on
+	// the record (e.g. on its subject) are created for
+	context rowContext
+	// Error reading or anonymising the record
+	err error
+	// Errors handled by the error policies of the actions,
+	// the record is written with the values they returned
+	handled []columnError
+}
+
+// batch is a group of consecutive items, seq is its position in the input
+type batch struct {
+	seq   int
+	items []item
+}
+
+// Anonymises the record if it has been sampled, creating
+// the anonymisations that depend on its context
+func (it *item) anonymise(anons []Anonymisation, opts processOptions) {
+	if it.err == nil && it.sampled {
+		ctx := it.context
+		ctx.firstPass = opts.firstPass
+		it.err = it.record.anonymise(it.collectHandled(forContext(anons, opts.byContext, ctx)))
+	}
+}
+
+// Returns the anonymisations with the errors handled by their
+// error policies collected in the item instead of returned
+func (it *item) collectHandled(anons []Anonymisation) []Anonymisation {
+	res := make([]Anonymisation, len(anons))
+	for i := range anons {
+		i, anon := i, anons[i]
+		res[i] = func(s string) (string, error) {
+			v, err := anon(s)
+			if errors.As(err, &handledError{}) {
+				it.handled = append(it.handled, columnError{i, s, err})
+				return v, nil
+			}
+			return v, err
+		}
+	}
+	return res
+}
+
+// Writes the record to the output, reports it as rejected, skips it or
+// enforces k-anonymity on it depending on the item. Returns an error if the process has to stop.
+func writeItem(w recordWriter, it item, opts processOptions) error {
+	opts.stats.read()
+	for _, err := range it.handled {
+		rej := newRejection(it.line, err, nil)
+		opts.stats.failed(rej)
+		var he handledError
+		if errors.As(err, &he) && he.passThrough && !opts.firstPass {
+			logPassThrough(rej)
+		}
+	}
+	if errors.As(it.err, &abortError{}) {
+		return newRejection(it.line, it.err, nil).abort()
+	} else if it.err != nil {
+		// they are logged and written to the rejects in the second pass
+		if !opts.firstPass {
+			opts.stats.rejected(opts.rejects.reject(it.line, it.err))
+		}
+	} else if !it.sampled {
+		opts.stats.sampledOut()
+	} else if opts.kAnonymity == nil || opts.kAnonymity.enforce(it.record, opts) {
+		if err := w.write(it.record); err != nil {
+			return err
+		}
+		opts.stats.written(it.record)
+	}
+	return nil
+}
+
+// Reads, samples and anonymises every record. If more than one
+// worker is configured, the records are anonymised in parallel,
+// keeping their order in the output.
+func processRecords(r recordReader, w recordWriter, anons []Anonymisation, opts processOptions) error {
+	var err error
+	if opts.workers > 1 {
+		err = processParallel(r, w, anons, opts)
+	} else {
+		err = processSequential(r, w, anons, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+	opts.stats.finish()
+	return opts.rejects.flush()
+}
+
+// Reads, anonymises and writes one record at a time
+func processSequential(r recordReader, w recordWriter, anons []Anonymisation, opts processOptions) error {
+	for i := 0; ; i++ {
+		it, err := r.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		it.anonymise(anons, opts)
+		if err := writeItem(w, it, opts); err != nil {
+			return err
+		}
+		//TODO decide how often do we want to flush
+		if i%100 == 0 {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return w.flush()
+}
+
+// Reverses the encrypted values of every record, failing
+// on the first value that can't be decrypted.
+func reveal(r recordReader, w recordWriter, revs []Anonymisation) error {
+	for {
+		it, err := r.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		} else if it.err != nil {
+			return fmt.Errorf("line %d: %v", it.line, it.err)
+		}
+		if err := it.record.anonymise(revs); err != nil {
+			return fmt.Errorf("line %d: %v", it.line, err)
+		}
+		if err := w.write(it.record); err != nil {
+			return err
+		}
+	}
+	return w.close()
+}
+
+// Reads the records in batches, anonymises them with several workers
+// in parallel and writes them in the same order they were read.
+func processParallel(r recordReader, w recordWriter, anons []Anonymisation, opts processOptions) error {
+	// closed when the process finishes, to stop the rest of goroutines
+	done := make(chan struct{})
+	defer close(done)
+	batches := make(chan batch, opts.workers)
+	results := make(chan batch, opts.workers)
+	// bounds the number of batches read but not written yet,
+	// so the memory used to reorder them is bounded
+	inFlight := make(chan struct{}, 4*opts.workers)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(batches)
+		readErr <- readBatches(r, batches, inFlight, done)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				for j := range b.items {
+					b.items[j].anonymise(anons, opts)
+				}
+				select {
+				case results <- b:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := map[int]batch{}
+	next := 0
+	for b := range results {
+		pending[b.seq] = b
+		for b, ok := pending[next]; ok; b, ok = pending[next] {
+			delete(pending, next)
+			for _, it := range b.items {
+				if err := writeItem(w, it, opts); err != nil {
+					return err
+				}
+			}
+			if err := w.flush(); err != nil {
+				return err
+			}
+			<-inFlight
+			next++
+		}
+	}
+	return <-readErr
+}
+
+// Reads the records and sends them in batches until the input
+// finishes, there is an error reading it or the process is done.
+func readBatches(r recordReader, batches chan<- batch, inFlight chan<- struct{}, done <-chan struct{}) error {
+	for seq := 0; ; seq++ {
+		b := batch{seq: seq, items: make([]item, 0, batchSize)}
+		var err error
+		for len(b.items) < batchSize {
+			var it item
+			if it, err = r.read(); err != nil {
+				break
+			}
+			b.items = append(b.items, it)
+		}
+		if len(b.items) > 0 {
+			select {
+			case inFlight <- struct{}{}:
+			case <-done:
+				return nil
+			}
+			select {
+			case batches <- b:
+			case <-done:
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Generates a csv with the given number of rows, with an invalid
+// date every 7 rows and a wrong number of fields every 11 rows
+func generateCsv(rows int) string {
+	var sb strings.Builder
+	for i := 0; i < rows; i++ {
+		switch {
+		case i%11 == 0:
+			sb.WriteString("only one field\n")
+		case i%7 == 0:
+			fmt.Fprintf(&sb, "id%d,W1W 8BE,invalid date,user%d@example.com\n", i, i)
+		default:
+			fmt.Fprintf(&sb, "id%d,W1W 8BE,2018%02d%02d,user%d@example.com\n", i, i%12+1, i%28+1, i)
+		}
+	}
+	return sb.String()
+}
+
+func benchmarkAnonymisations() []Anonymisation {
+	y, _ := year("20060102")
+	return []Anonymisation{hash(salt), outcode, named("year", y), hash(salt)}
+}
+
+func TestProcessParallel(t *testing.T) {
+	in := generateCsv(5000)
+	run := func(workers int, conf *Config, anons []Anonymisation) (string, string, *Stats, error) {
+		var out, rejectsOut bytes.Buffer
+		r := csv.NewReader(strings.NewReader(in))
+		r.FieldsPerRecord = 4
+		st := NewStats()
+		err := process(r, csv.NewWriter(&out), conf, &anons, processOptions{
+			rejects: newRejectsWriter(&rejectsOut, nil),
+			stats:   st,
+			workers: workers,
+		})
+		return out.String(), rejectsOut.String(), st, err
+	}
+	t.Run("keeps the order of the input", func(t *testing.T) {
+		conf := &Config{Sampling: SamplingConfig{Mod: 3}}
+		expectedOut, expectedRejects, expectedStats, err := run(1, conf, benchmarkAnonymisations())
+		require.NoError(t, err)
+		for _, workers := range []int{2, 4, 16} {
+			out, rejects, st, err := run(workers, conf, benchmarkAnonymisations())
+			assert.NoError(t, err)
+			assert.Equal(t, expectedOut, out, "should write the same output as a single worker")
+			assert.Equal(t, expectedRejects, rejects, "should write the same rejects as a single worker")
+			assert.Equal(t, expectedStats.Read, st.Read)
+			assert.Equal(t, expectedStats.SampledOut, st.SampledOut)
+			assert.Equal(t, expectedStats.Written, st.Written)
+			assert.Equal(t, expectedStats.Rejected, st.Rejected)
+		}
+	})
+	t.Run("when there is an error processing a column that has to fail", func(t *testing.T) {
+		y, _ := year("20060102")
+		ac := ActionConfig{Name: "year", OnError: "fail"}
+		y, _ = ac.withErrorPolicy(y)
+		_, _, _, err := run(4, &Config{Sampling: SamplingConfig{Mod: 1}}, []Anonymisation{identity, identity, y})
+		assert.Error(t, err, "should return an error")
+	})
+	t.Run("when the id column is out of range", func(t *testing.T) {
+		_, _, _, err := run(4, &Config{Sampling: SamplingConfig{Mod: 1, IDColumn: 10}}, benchmarkAnonymisations())
+		assert.Error(t, err, "should return an error")
+	})
+}
+
+func TestWriteItem(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	withPolicy := func(policy string) Anonymisation {
+		y, _ := year("20060102")
+		ac := ActionConfig{Name: "year", OnError: policy}
+		y, _ = ac.withErrorPolicy(named("year", y))
+		return y
+	}
+	write := func(anon Anonymisation, opts processOptions) (string, error) {
+		logs.Reset()
+		var out bytes.Buffer
+		it := item{line: 3, record: csvRecord{"a", "secret"}, sampled: true}
+		it.anonymise([]Anonymisation{identity, anon}, opts)
+		w := &csvWriter{csv.NewWriter(&out)}
+		if err := writeItem(w, it, opts); err != nil {
+			return "", err
+		}
+		require.NoError(t, w.close())
+		return out.String(), nil
+	}
+	t.Run("logs the values passed through", func(t *testing.T) {
+		st := NewStats()
+		out, err := write(withPolicy("passThrough"), processOptions{stats: st})
+		require.NoError(t, err)
+		assert.Equal(t, "a,secret\n", out, "should write the value unchanged")
+		assert.Contains(t, logs.String(), "record at line 3, column 1 (year): value passed through unchanged", "should log it")
+		assert.NotContains(t, logs.String(), "secret", "shouldn't log the value")
+		assert.Equal(t, map[string]int64{"year": 1}, st.Columns[1].Errors, "should count the error")
+	})
+	t.Run("logs the rejected records", func(t *testing.T) {
+		out, err := write(withPolicy("dropRow"), processOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, out, "shouldn't write the record")
+		assert.Contains(t, logs.String(), "record at line 3 rejected, column 1 (year)", "should log it")
+	})
+	t.Run("in the first pass", func(t *testing.T) {
+		for _, policy := range []string{"passThrough", "dropRow"} {
+			_, err := write(withPolicy(policy), processOptions{firstPass: true})
+			require.NoError(t, err)
+			assert.Empty(t, logs.String(), "shouldn't log anything with %s", policy)
+		}
+	})
+	t.Run("when the process has to fail", func(t *testing.T) {
+		fail := func(s string) (string, error) { return s, fmt.Errorf("can't parse %q", s) }
+		ac := ActionConfig{Name: "year", OnError: "fail"}
+		anon, _ := ac.withErrorPolicy(named("year", fail))
+		_, err := write(anon, processOptions{})
+		assert.True(t, errors.As(err, &abortError{}), "should abort the process")
+		assert.EqualError(t, err, "record at line 3, column 1 (year): can't parse <value>", "should redact the value")
+	})
+}
+
+func benchmarkProcess(b *testing.B, workers int) {
+	in := generateCsv(20000)
+	anons := benchmarkAnonymisations()
+	conf := &Config{Sampling: SamplingConfig{Mod: 1}}
+	// the rejected records are logged
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	b.SetBytes(int64(len(in)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := csv.NewReader(strings.NewReader(in))
+		r.FieldsPerRecord = 4
+		if err := process(r, csv.NewWriter(ioutil.Discard), conf, &anons, processOptions{workers: workers}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessSequential(b *testing.B) { benchmarkProcess(b, 1) }
+func BenchmarkProcess2Workers(b *testing.B)   { benchmarkProcess(b, 2) }
+func BenchmarkProcess4Workers(b *testing.B)   { benchmarkProcess(b, 4) }
+func BenchmarkProcess8Workers(b *testing.B)   { benchmarkProcess(b, 8) }
@@ -0,0 +1,128 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ActionFactory creates the anonymisation of an action given its config.
+// Custom actions can read their own config block with DecodeConfig.
+type ActionFactory func(ac *ActionConfig) (Anonymisation, error)
+
+var (
+	actionsMu sync.RWMutex
+	actions   = map[string]ActionFactory{}
+)
+
+func init() {
+	RegisterAction("nothing", func(*ActionConfig) (Anonymisation, error) {
+		return identity, nil
+	})
+	RegisterAction("outcode", func(*ActionConfig) (Anonymisation, error) {
+		return outcode, nil
+	})
+	RegisterAction("hash", func(ac *ActionConfig) (Anonymisation, error) {
+		return hash(ac.saltOrRandom()), nil
+	})
+	RegisterAction("hmac", func(ac *ActionConfig) (Anonymisation, error) {
+		return hmacHash(ac.HmacConfig)
+	})
+	RegisterAction("fpe", func(ac *ActionConfig) (Anonymisation, error) {
+		return fpe(ac.FpeConfig)
+	})
+	RegisterAction("encrypt", func(ac *ActionConfig) (Anonymisation, error) {
+		return encrypt(ac.EncryptConfig)
+	})
+	RegisterAction("year", func(ac *ActionConfig) (Anonymisation, error) {
+		return date(DateConfig{Format: ac.DateConfig.Format, Formats: ac.DateConfig.Formats, Granularity: "year"})
+	})
+	RegisterAction("date", func(ac *ActionConfig) (Anonymisation, error) {
+		return date(ac.DateConfig)
+	})
+	// The Processor creates them for the context of each record, otherwise
+	// all the dates would be shifted as the same subject and the reference
+	// columns would be missing
+	RegisterAction("dateShift", func(ac *ActionConfig) (Anonymisation, error) {
+		return nil, errNeedsContext(ac.Name)
+	})
+	RegisterAction("age", func(ac *ActionConfig) (Anonymisation, error) {
+		if ac.byContext() {
+			return nil, errNeedsContext(ac.Name)
+		}
+		create, err := age(ac.DateConfig, ac.AgeConfig, ac.RangeConfig)
+		if err != nil {
+			return nil, err
+		}
+		// with a reference date it doesn't depend on the context
+		return create(rowContext{}), nil
+	})
+	RegisterAction("ranges", func(ac *ActionConfig) (Anonymisation, error) {
+		return ranges(ac.RangeConfig)
+	})
+	RegisterAction("bin", func(ac *ActionConfig) (Anonymisation, error) {
+		if ac.BinConfig.Quantiles == 0 {
+			return bin(ac.BinConfig)
+		}
+		// The Processor computes the quantiles in a first pass over the
+		// input, otherwise there are no values to compute them from
+		return nil, errNeedsContext(ac.Name)
+	})
+	RegisterAction("noise", func(ac *ActionConfig) (Anonymisation, error) {
+		return noise(ac.NoiseConfig)
+	})
+	RegisterAction("pipeline", func(ac *ActionConfig) (Anonymisation, error) {
+		return pipeline(ac.Pipeline)
+	})
+}
+
+// RegisterAction makes an action available by the provided name, so it
+// can be used in the config. If RegisterAction is called twice with the
+// same name or if factory is nil, it panics.
+func RegisterAction(name string, factory ActionFactory) {
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+	if factory == nil {
+		panic("anon: RegisterAction factory is nil")
+	}
+	if _, dup := actions[name]; dup {
+		panic("anon: RegisterAction called twice for action " + name)
+	}
+	actions[name] = factory
+}
+
+// Actions returns a sorted list of the names of the registered actions
+func Actions() []string {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	res := make([]string, 0, len(actions))
+	for name := range actions {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func lookupAction(name string) (ActionFactory, bool) {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	factory, ok := actions[name]
+	return factory, ok
+}
+
+// DecodeConfig decodes the config block of the action into v,
+// failing if it contains fields that v doesn't define.
+func (ac *ActionConfig) DecodeConfig(v interface{}) error {
+	if len(ac.Config) == 0 {
+		return errors.New("the action needs a config block")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(ac.Config))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid config for action %s: %v", ac.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package anon
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type repeatConfig struct {
+	Times int
+}
+
+func TestRegisterAction(t *testing.T) {
+	RegisterAction("test-repeat", func(ac *ActionConfig) (Anonymisation, error) {
+		var conf repeatConfig
+		if err := ac.DecodeConfig(&conf); err != nil {
+			return nil, err
+		}
+		return func(s string) (string, error) {
+			return strings.Repeat(s, conf.Times), nil
+		}, nil
+	})
+	t.Run("registers the action", func(t *testing.T) {
+		ac := ActionConfig{Name: "test-repeat", Config: json.RawMessage(`{"times": 3}`)}
+		res, err := ac.Create()
+		require.NoError(t, err)
+		out, err := res("a")
+		assert.NoError(t, err)
+		assert.Equal(t, "aaa", out, "should create the action with its config")
+		assert.Contains(t, Actions(), "test-repeat")
+	})
+	t.Run("with a name already registered", func(t *testing.T) {
+		assert.Panics(t, func() {
+			RegisterAction("hash", func(*ActionConfig) (Anonymisation, error) { return identity, nil })
+		}, "should panic")
+	})
+	t.Run("with a nil factory", func(t *testing.T) {
+		assert.Panics(t, func() { RegisterAction("test-nil", nil) }, "should panic")
+	})
+}
+
+func TestActions(t *testing.T) {
+	actions := Actions()
+	for _, name := range []string{"age", "bin", "date", "dateShift", "encrypt", "fpe", "hash", "hmac", "noise", "nothing", "outcode", "pipeline", "ranges", "year"} {
+		assert.Contains(t, actions, name, "should contain the built-in actions")
+	}
+	assert.IsIncreasing(t, actions, "should be sorted")
+}
+
+func TestActionConfigDecodeConfig(t *testing.T) {
+	t.Run("with a valid config", func(t *testing.T) {
+		var conf repeatConfig
+		ac := ActionConfig{Name: "test", Config: json.RawMessage(`{"times": 2}`)}
+		assert.NoError(t, ac.DecodeConfig(&conf))
+		assert.Equal(t, repeatConfig{Times: 2}, conf)
+	})
+	t.Run("without a config", func(t *testing.T) {
+		var conf repeatConfig
+		ac := ActionConfig{Name: "test"}
+		assert.Error(t, ac.DecodeConfig(&conf), "should return an error")
+	})
+	t.Run("with an unknown field", func(t *testing.T) {
+		var conf repeatConfig
+		ac := ActionConfig{Name: "test", Config: json.RawMessage(`{"times": 2, "other": 1}`)}
+		assert.Error(t, ac.DecodeConfig(&conf), "should return an error")
+	})
+}
@@ -0,0 +1,152 @@
+package anon
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// actionError wraps the error returned by an action
+type actionError struct {
+	action string
+	err    error
+}
+
+func (e actionError) Error() string {
+	return fmt.Sprintf("%s: %v", e.action, e.err)
+}
+
+func (e actionError) Unwrap() error {
+	return e.err
+}
+
+// columnError wraps the error returned when anonymising a column
+type columnError struct {
+	column int
+	value  string
+	err    error
+}
+
+func (e columnError) Error() string {
+	return fmt.Sprintf("column %d: %v", e.column, e.err)
+}
+
+func (e columnError) Unwrap() error {
+	return e.err
+}
+
+// rejection stores the details of a record that
+// has been rejected, without any of its raw values
+type rejection struct {
+	line int
+	// -1 if the whole record has been rejected
+	column int
+	action string
+	reason string
+	// The hashed raw value, empty if it's omitted
+	value string
+}
+
+// Returns the rejection for the given error, redacting the raw value
+// from the reason and hashing it if hashValue is not nil
+func newRejection(line int, err error, hashValue Anonymisation) rejection {
+	rej := rejection{line: line, column: -1, reason: err.Error()}
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		rej.line = pe.StartLine
+		rej.reason = pe.Err.Error()
+	}
+	var ce columnError
+	if errors.As(err, &ce) {
+		rej.column = ce.column
+		rej.reason = ce.err.Error()
+		var ae actionError
+		if errors.As(err, &ae) {
+			rej.action = ae.action
+			rej.reason = ae.err.Error()
+		}
+		rej.reason = redact(rej.reason, ce.value)
+		if hashValue != nil {
+			rej.value, _ = hashValue(ce.value)
+		}
+	}
+	return rej
+}
+
+// Removes any occurrence of the value from the message
+func redact(message string, value string) string {
+	if value == "" {
+		return message
+	}
+	message = strings.Replace(message, strconv.Quote(value), "<value>", -1)
+	return strings.Replace(message, value, "<value>", -1)
+}
+
+// Returns the error that stops the process because of the
+// rejected record, with the raw value redacted from its reason
+func (r rejection) abort() error {
+	if r.column < 0 {
+		return abortError{fmt.Errorf("record at line %d: %s", r.line, r.reason)}
+	}
+	return abortError{fmt.Errorf("record at line %d, column %d (%s): %s", r.line, r.column, r.action, r.reason)}
+}
+
+// Logs that the value of a column has been written unchanged
+// after the error, without the value
+func logPassThrough(rej rejection) {
+	log.Printf("record at line %d, column %d (%s): value passed through unchanged: %s", rej.line, rej.column, rej.action, rej.reason)
+}
+
+func (r rejection) String() string {
+	if r.column < 0 {
+		return fmt.Sprintf("record at line %d rejected: %s", r.line, r.reason)
+	}
+	return fmt.Sprintf("record at line %d rejected, column %d (%s): %s", r.line, r.column, r.action, r.reason)
+}
+
+// rejectsWriter writes the rejected records to a csv
+type rejectsWriter struct {
+	w *csv.Writer
+	// If not nil, used to hash the raw values, otherwise they are omitted
+	hashValue Anonymisation
+}
+
+// Creates a rejects writer and writes the header of the csv
+func newRejectsWriter(w io.Writer, hashValue Anonymisation) *rejectsWriter {
+	rw := &rejectsWriter{w: csv.NewWriter(w), hashValue: hashValue}
+	rw.w.Write([]string{"line", "column", "action", "reason", "value"})
+	return rw
+}
+
+// Builds the rejection for the error, logs it and writes it
+// to the rejects output if there is one
+func (rw *rejectsWriter) reject(line int, err error) rejection {
+	var hashValue Anonymisation
+	if rw != nil {
+		hashValue = rw.hashValue
+	}
+	rej := newRejection(line, err, hashValue)
+	// we just print the error and skip the record
+	log.Print(rej)
+	if rw == nil {
+		return rej
+	}
+	column := ""
+	if rej.column >= 0 {
+		column = strconv.Itoa(rej.column)
+	}
+	rw.w.Write([]string{strconv.Itoa(rej.line), column, rej.action, rej.reason, rej.value})
+	return rej
+}
+
+func (rw *rejectsWriter) flush() error {
+	if rw == nil {
+		return nil
+	}
+	rw.w.Flush()
+	return rw.w.Error()
+}
@@ -0,0 +1,54 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRejection(t *testing.T) {
+	t.Run("with a parse error", func(t *testing.T) {
+		err := &csv.ParseError{StartLine: 3, Line: 4, Column: 1, Err: csv.ErrFieldCount}
+		rej := newRejection(5, err, nil)
+		assert.Equal(t, rejection{line: 3, column: -1, reason: csv.ErrFieldCount.Error()}, rej, "should use the line of the parse error")
+	})
+	t.Run("with an error anonymising a column", func(t *testing.T) {
+		err := columnError{2, "secret", actionError{"year", errors.New(`can't parse "secret"`)}}
+		rej := newRejection(5, err, nil)
+		assert.Equal(t, rejection{line: 5, column: 2, action: "year", reason: "can't parse <value>"}, rej, "should redact the value from the reason")
+	})
+	t.Run("with a function to hash the values", func(t *testing.T) {
+		err := columnError{2, "secret", actionError{"year", errors.New("failed")}}
+		rej := newRejection(5, err, hash(""))
+		expected, _ := hash("")("secret")
+		assert.Equal(t, expected, rej.value, "should hash the value")
+	})
+	t.Run("with any other error", func(t *testing.T) {
+		rej := newRejection(5, errors.New("failed"), nil)
+		assert.Equal(t, rejection{line: 5, column: -1, reason: "failed"}, rej)
+	})
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "a <value> b <value>", redact(`a "s\"" b s"`, `s"`), "should redact the raw and quoted value")
+	assert.Equal(t, "a b", redact("a b", ""), "should leave the message unchanged if the value is empty")
+}
+
+func TestRejectsWriter(t *testing.T) {
+	t.Run("with a writer", func(t *testing.T) {
+		var out bytes.Buffer
+		rw := newRejectsWriter(&out, nil)
+		rw.reject(2, columnError{1, "secret", actionError{"ranges", errors.New("value is not a number")}})
+		rw.reject(3, errors.New("failed"))
+		assert.NoError(t, rw.flush())
+		assert.Equal(t, "line,column,action,reason,value\n2,1,ranges,value is not a number,\n3,,,failed,\n", out.String(), "should write the rejections")
+	})
+	t.Run("without a writer", func(t *testing.T) {
+		var rw *rejectsWriter
+		rw.reject(2, errors.New("failed"))
+		assert.NoError(t, rw.flush(), "should do nothing")
+	})
+}
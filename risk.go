@@ -0,0 +1,229 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// RiskConfig stores the columns the re-identification risk of a csv
+// is assessed on. The columns are referenced by name if the csv has a
+// header or by their position otherwise.
+type RiskConfig struct {
+	Csv CsvConfig
+	// Columns an attacker could know about and link to other data
+	QuasiIdentifiers []string
+	// Columns whose values are disclosed if the records of a
+	// class (or most of them) have the same one
+	Sensitive []string
+	// Fraction of the population the csv is a sample of, used to
+	// estimate the journalist risk. 1 (the whole population) if not set
+	SamplingFraction float64
+}
+
+// RiskReport is the re-identification risk of a csv. The records are grouped
+// into equivalence classes by their values of the quasi-identifiers.
+type RiskReport struct {
+	Records int64 `json:"records"`
+	Classes int64 `json:"classes"`
+	// Number of classes by their size (number of records)
+	ClassSizes map[int64]int64 `json:"classSizes"`
+	// Size of the smallest class, i.e. the csv is k-anonymous
+	K int64 `json:"k"`
+	// Records alone in their class and their percentage of the records
+	Unique           int64   `json:"uniqueRecords"`
+	UniquePercentage float64 `json:"uniquePercentage"`
+	// Risk when the attacker knows the person is in the csv
+	Prosecutor RiskEstimate `json:"prosecutorRisk"`
+	// Risk when the attacker doesn't know if the person is in the csv,
+	// estimated from the sizes of the classes in the population
+	Journalist RiskEstimate `json:"journalistRisk"`
+	LDiversity []LDiversity `json:"lDiversity,omitempty"`
+}
+
+// RiskEstimate stores the probability of re-identifying a record,
+// the highest one and the average of all the records
+type RiskEstimate struct {
+	Max     float64 `json:"max"`
+	Average float64 `json:"average"`
+}
+
+// LDiversity stores the diversity of the values of a sensitive column
+type LDiversity struct {
+	Column string `json:"column"`
+	// Lowest number of distinct values of the column in a class
+	L int `json:"l"`
+	// Number of classes with a single value of the column,
+	// whose value is disclosed for all their records
+	Homogeneous int64 `json:"homogeneousClasses"`
+}
+
+// equivalenceClass stores the records with the same values of the quasi-identifiers
+type equivalenceClass struct {
+	size int64
+	// Distinct values of each sensitive column
+	sensitive []map[string]bool
+}
+
+// AssessRisk reads a csv (usually already anonymised) and
+// reports its re-identification risk
+func AssessRisk(in io.Reader, conf RiskConfig) (*RiskReport, error) {
+	if len(conf.QuasiIdentifiers) == 0 {
+		return nil, errors.New("you need to specify at least one quasi-identifier")
+	} else if conf.SamplingFraction < 0 || conf.SamplingFraction > 1 {
+		return nil, errors.New("the sampling fraction must be between 0 and 1")
+	}
+	r := newReader(in, conf.Csv)
+	var columns map[string]int
+	if conf.Csv.Header {
+		header, err := r.Read()
+		if err == io.EOF {
+			return newRiskReport(nil, conf), nil
+		} else if err != nil {
+			return nil, err
+		}
+		if columns, err = headerIndices(header); err != nil {
+			return nil, err
+		}
+	}
+	quasi, err := riskColumns(conf.QuasiIdentifiers, columns)
+	if err != nil {
+		return nil, err
+	}
+	sensitive, err := riskColumns(conf.Sensitive, columns)
+	if err != nil {
+		return nil, err
+	}
+	used := append(append([]int{}, quasi...), sensitive...)
+	classes := map[string]*equivalenceClass{}
+	for n := 1; ; n++ {
+		values, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		for _, i := range used {
+			if i >= len(values) {
+				return nil, fmt.Errorf("record %d: column %d out of range", n, i)
+			}
+		}
+		key := groupKey(csvRecord(values), quasi)
+		class, ok := classes[key]
+		if !ok {
+			class = &equivalenceClass{sensitive: make([]map[string]bool, len(sensitive))}
+			for i := range class.sensitive {
+				class.sensitive[i] = map[string]bool{}
+			}
+			classes[key] = class
+		}
+		class.size++
+		for i, column := range sensitive {
+			class.sensitive[i][values[column]] = true
+		}
+	}
+	return newRiskReport(classes, conf), nil
+}
+
+// Returns the position of the columns, by their name in the header or by
+// their position if the csv doesn't have a header (columns is nil)
+func riskColumns(names []string, columns map[string]int) ([]int, error) {
+	res := make([]int, len(names))
+	for i, name := range names {
+		var ok bool
+		if columns != nil {
+			res[i], ok = columns[name]
+		} else if position, err := strconv.Atoi(name); err == nil && position >= 0 {
+			res[i], ok = position, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("column %s not found", name)
+		}
+	}
+	return res, nil
+}
+
+// Computes the risk from the equivalence classes
+func newRiskReport(classes map[string]*equivalenceClass, conf RiskConfig) *RiskReport {
+	fraction := conf.SamplingFraction
+	if fraction == 0 {
+		fraction = 1
+	}
+	report := &RiskReport{Classes: int64(len(classes)), ClassSizes: map[int64]int64{}}
+	for _, column := range conf.Sensitive {
+		report.LDiversity = append(report.LDiversity, LDiversity{Column: column})
+	}
+	for _, class := range classes {
+		report.Records += class.size
+		report.ClassSizes[class.size]++
+		if report.K == 0 || class.size < report.K {
+			report.K = class.size
+		}
+		if class.size == 1 {
+			report.Unique++
+		}
+		for i, values := range class.sensitive {
+			l := &report.LDiversity[i]
+			if l.L == 0 || len(values) < l.L {
+				l.L = len(values)
+			}
+			if len(values) == 1 {
+				l.Homogeneous++
+			}
+		}
+	}
+	if report.Records == 0 {
+		return report
+	}
+	// The risk of a record is 1 / the size of its class. In the population,
+	// the size of a class is estimated as its size in the sample / fraction.
+	report.UniquePercentage = 100 * float64(report.Unique) / float64(report.Records)
+	report.Prosecutor = RiskEstimate{
+		Max:     1 / float64(report.K),
+		Average: float64(report.Classes) / float64(report.Records),
+	}
+	report.Journalist = RiskEstimate{
+		Max:     fraction / float64(report.K),
+		Average: fraction * float64(report.Classes) / float64(report.Records),
+	}
+	return report
+}
+
+// WriteJSON writes the report as JSON
+func (r *RiskReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes the report in a human readable format
+func (r *RiskReport) WriteText(w io.Writer) error {
+	sizes := make([]int64, 0, len(r.ClassSizes))
+	for size := range r.ClassSizes {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Records: %d\n", r.Records)
+	fmt.Fprintf(&b, "Equivalence classes: %d (k = %d)\n", r.Classes, r.K)
+	fmt.Fprintf(&b, "Unique records: %d (%.2f%%)\n", r.Unique, r.UniquePercentage)
+	fmt.Fprintf(&b, "Class sizes (size: classes):\n")
+	for _, size := range sizes {
+		fmt.Fprintf(&b, "  %d: %d\n", size, r.ClassSizes[size])
+	}
+	fmt.Fprintf(&b, "Prosecutor risk: max %.4f, average %.4f\n", r.Prosecutor.Max, r.Prosecutor.Average)
+	fmt.Fprintf(&b, "Journalist risk: max %.4f, average %.4f\n", r.Journalist.Max, r.Journalist.Average)
+	if len(r.LDiversity) > 0 {
+		fmt.Fprintf(&b, "l-diversity:\n")
+	}
+	for _, l := range r.LDiversity {
+		fmt.Fprintf(&b, "  %s: l = %d, %d homogeneous classes\n", l.Column, l.L, l.Homogeneous)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
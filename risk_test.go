@@ -0,0 +1,82 @@
+package anon
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssessRisk(t *testing.T) {
+	input := "outcode,year,diagnosis\na1,2001,flu\na1,2001,cold\na1,2001,flu\nb2,2002,flu\nb2,2002,flu\nc3,2003,asthma\n"
+	conf := RiskConfig{Csv: CsvConfig{Header: true}, QuasiIdentifiers: []string{"outcode", "year"}, Sensitive: []string{"diagnosis"}}
+	t.Run("with a header", func(t *testing.T) {
+		report, err := AssessRisk(strings.NewReader(input), conf)
+		require.NoError(t, err)
+		assert.EqualValues(t, 6, report.Records)
+		assert.EqualValues(t, 3, report.Classes, "should group the records by the quasi-identifiers")
+		assert.Equal(t, map[int64]int64{1: 1, 2: 1, 3: 1}, report.ClassSizes, "should count the classes by size")
+		assert.EqualValues(t, 1, report.K, "should return the size of the smallest class")
+		assert.EqualValues(t, 1, report.Unique)
+		assert.InDelta(t, 16.67, report.UniquePercentage, 0.01)
+		assert.Equal(t, RiskEstimate{Max: 1, Average: 0.5}, report.Prosecutor, "should estimate the prosecutor risk")
+		assert.Equal(t, report.Prosecutor, report.Journalist, "should estimate the journalist risk as the prosecutor one for the whole population")
+		assert.Equal(t, []LDiversity{LDiversity{Column: "diagnosis", L: 1, Homogeneous: 2}}, report.LDiversity, "should compute the l-diversity")
+	})
+	t.Run("with a sample of the population", func(t *testing.T) {
+		conf := conf
+		conf.SamplingFraction = 0.1
+		report, err := AssessRisk(strings.NewReader(input), conf)
+		require.NoError(t, err)
+		assert.InDelta(t, 0.1, report.Journalist.Max, 1e-9, "should estimate the sizes of the classes in the population")
+		assert.InDelta(t, 0.05, report.Journalist.Average, 1e-9)
+	})
+	t.Run("without a header", func(t *testing.T) {
+		report, err := AssessRisk(strings.NewReader("a;1\na;2\nb;1\n"), RiskConfig{Csv: CsvConfig{Delimiter: ";"}, QuasiIdentifiers: []string{"0"}})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, report.Classes, "should take the columns by position")
+		assert.Empty(t, report.LDiversity)
+	})
+	t.Run("with an empty csv", func(t *testing.T) {
+		report, err := AssessRisk(strings.NewReader(""), conf)
+		require.NoError(t, err)
+		assert.EqualValues(t, 0, report.Records, "should return an empty report")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		for _, conf := range []RiskConfig{
+			RiskConfig{Csv: CsvConfig{Header: true}},
+			RiskConfig{Csv: CsvConfig{Header: true}, QuasiIdentifiers: []string{"postcode"}},
+			RiskConfig{Csv: CsvConfig{Header: true}, QuasiIdentifiers: []string{"year"}, Sensitive: []string{"gender"}},
+			RiskConfig{QuasiIdentifiers: []string{"year"}},
+			RiskConfig{Csv: CsvConfig{Header: true}, QuasiIdentifiers: []string{"year"}, SamplingFraction: 2},
+		} {
+			_, err := AssessRisk(strings.NewReader(input), conf)
+			assert.Error(t, err, "should return an error for %+v", conf)
+		}
+		_, err := AssessRisk(strings.NewReader("a,1\n"), RiskConfig{QuasiIdentifiers: []string{"3"}})
+		assert.Error(t, err, "should return an error if a column is out of range")
+	})
+}
+
+func TestRiskReportWrite(t *testing.T) {
+	report, err := AssessRisk(strings.NewReader("year,diagnosis\n2001,flu\n2001,cold\n2002,flu\n"), RiskConfig{Csv: CsvConfig{Header: true}, QuasiIdentifiers: []string{"year"}, Sensitive: []string{"diagnosis"}})
+	require.NoError(t, err)
+	var text, json bytes.Buffer
+	require.NoError(t, report.WriteText(&text))
+	assert.Equal(t, `Records: 3
+Equivalence classes: 2 (k = 1)
+Unique records: 1 (33.33%)
+Class sizes (size: classes):
+  1: 1
+  2: 1
+Prosecutor risk: max 1.0000, average 0.6667
+Journalist risk: max 1.0000, average 0.6667
+l-diversity:
+  diagnosis: l = 1, 1 homogeneous classes
+`, text.String(), "should write the report as text")
+	require.NoError(t, report.WriteJSON(&json))
+	assert.Contains(t, json.String(), `"classSizes": {`+"\n"+`    "1": 1,`, "should write the report as JSON")
+	assert.Contains(t, json.String(), `"homogeneousClasses": 1`)
+}
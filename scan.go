@@ -0,0 +1,291 @@
+package anon
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of records read by Scan if it's not configured
+const defaultScanRows = 1000
+
+// Lowest fraction of the values of a column that must look like
+// the same kind of personal data for the column to be reported
+const minScanConfidence = 0.5
+
+// ScanConfig stores how to read the csv scanned for personal data
+type ScanConfig struct {
+	// Delimiter and whether the csv has a header, or it's detected
+	Csv CsvConfig
+	// Number of records read from the start of the csv, 1000 if not set
+	Rows int
+}
+
+// ColumnScan stores the kind of personal data detected in a column
+type ColumnScan struct {
+	// Name of the column, or its position if the csv doesn't have a header
+	Column string
+	// email, phone, postcode, date, name, ip, card or id,
+	// empty if no personal data has been detected
+	Detected string
+	// Fraction of the values of the column that look like it
+	Confidence float64
+	// Format (Go layout) of the dates
+	DateFormat string
+}
+
+// ScanReport stores the personal data detected in each column of a csv
+type ScanReport struct {
+	Delimiter string
+	Header    bool
+	Columns   []ColumnScan
+}
+
+var (
+	emailRegexp    = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[A-Za-z]{2,}$`)
+	phoneRegexp    = regexp.MustCompile(`^\+?[0-9 ()-]{10,18}$`)
+	postcodeRegexp = regexp.MustCompile(`^(?i)[A-Z]{1,2}[0-9][A-Z0-9]? ?[0-9][A-Z]{2}$`)
+	nameRegexp     = regexp.MustCompile(`^[A-Z][a-z'-]+( [A-Z][a-z'-]+){0,3}$`)
+	digitsRegexp   = regexp.MustCompile(`^[0-9]+$`)
+	nameHeader     = regexp.MustCompile(`(?i)name`)
+)
+
+// Layouts of the dates tried by Scan, in order of preference
+var scanDateLayouts = []string{
+	"2006-01-02", "02/01/2006", "01/02/2006", "20060102", "2006/01/02", "02-01-2006",
+	"2006-01-02 15:04:05", time.RFC3339, "Jan 2, 2006", "2 Jan 2006",
+}
+
+// Returns the digits of the value, without spaces or dashes
+func digits(s string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(s)
+}
+
+// Returns if the digits pass the Luhn checksum of card numbers
+func luhn(s string) bool {
+	sum := 0
+	for i := range s {
+		d := int(s[len(s)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// Detectors of each kind of personal data in a value, in order of
+// preference if a value looks like more than one (e.g. card before phone)
+var detectors = []struct {
+	kind   string
+	detect func(s string) bool
+}{
+	{"email", emailRegexp.MatchString},
+	{"card", func(s string) bool {
+		d := digits(s)
+		return len(d) >= 13 && len(d) <= 19 && digitsRegexp.MatchString(d) && luhn(d)
+	}},
+	{"phone", func(s string) bool {
+		return phoneRegexp.MatchString(s) && (strings.HasPrefix(s, "0") || strings.HasPrefix(s, "+"))
+	}},
+	{"postcode", postcodeRegexp.MatchString},
+	{"ip", func(s string) bool { return net.ParseIP(s) != nil }},
+	{"name", nameRegexp.MatchString},
+}
+
+// Scan reads the first records of a csv and detects the kind of personal
+// data in each column, so a draft config can be written with WriteConfig
+func Scan(in io.Reader, conf ScanConfig) (*ScanReport, error) {
+	rows := conf.Rows
+	if rows <= 0 {
+		rows = defaultScanRows
+	}
+	cr := &csvReader{r: newReader(in, conf.Csv)}
+	cr.r.FieldsPerRecord = -1
+	hasHeader := conf.Csv.Header
+	if conf.Csv.DetectHeader {
+		var err error
+		if hasHeader, err = cr.detectHeader(); err == io.EOF {
+			return &ScanReport{Delimiter: conf.Csv.Delimiter}, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	var header []string
+	var records [][]string
+	for len(records) < rows {
+		row := cr.next()
+		if row.err == io.EOF {
+			break
+		} else if _, ok := row.err.(*csv.ParseError); ok {
+			continue
+		} else if row.err != nil {
+			return nil, row.err
+		}
+		if hasHeader && header == nil {
+			header = row.values
+			continue
+		}
+		records = append(records, row.values)
+	}
+	report := &ScanReport{Delimiter: conf.Csv.Delimiter, Header: hasHeader}
+	width := len(header)
+	for _, record := range records {
+		if len(record) > width {
+			width = len(record)
+		}
+	}
+	for i := 0; i < width; i++ {
+		var values []string
+		for _, record := range records {
+			if i < len(record) && strings.TrimSpace(record[i]) != "" {
+				values = append(values, strings.TrimSpace(record[i]))
+			}
+		}
+		column := ColumnScan{Column: strconv.Itoa(i)}
+		if i < len(header) {
+			column.Column = header[i]
+		}
+		column.scan(values, hasHeader)
+		report.Columns = append(report.Columns, column)
+	}
+	return report, nil
+}
+
+// Detects the kind of personal data most of the values look like
+func (c *ColumnScan) scan(values []string, hasHeader bool) {
+	if len(values) == 0 {
+		return
+	}
+	fraction := func(matches int) float64 {
+		return float64(matches) / float64(len(values))
+	}
+	for _, detector := range detectors {
+		matches := 0
+		for _, v := range values {
+			if detector.detect(v) {
+				matches++
+			}
+		}
+		confidence := fraction(matches)
+		// capitalised words could be names or any other proper noun
+		// (e.g. cities), so they are names if the header says so and
+		// have half the confidence if there isn't a header
+		if detector.kind == "name" && hasHeader && !nameHeader.MatchString(c.Column) {
+			confidence = 0
+		} else if detector.kind == "name" && !hasHeader {
+			confidence /= 2
+		}
+		if confidence > c.Confidence {
+			c.Detected, c.Confidence = detector.kind, confidence
+		}
+	}
+	for _, layout := range scanDateLayouts {
+		matches := 0
+		for _, v := range values {
+			if _, err := time.Parse(layout, v); err == nil {
+				matches++
+			}
+		}
+		if confidence := fraction(matches); confidence > c.Confidence {
+			c.Detected, c.Confidence, c.DateFormat = "date", confidence, layout
+		}
+	}
+	// numbers are ids if (almost) all of them are distinct
+	numbers, distinct := 0, map[string]bool{}
+	for _, v := range values {
+		if digitsRegexp.MatchString(v) {
+			numbers++
+			distinct[v] = true
+		}
+	}
+	if confidence := fraction(numbers); confidence > c.Confidence && float64(len(distinct)) >= 0.9*float64(numbers) && len(values) > 1 {
+		c.Detected, c.Confidence, c.DateFormat = "id", confidence, ""
+	}
+	if c.Confidence < minScanConfidence {
+		c.Detected, c.Confidence, c.DateFormat = "", 0, ""
+	}
+}
+
+// draftConfig is the config written by WriteConfig, with
+// the fields in the order they are usually written
+type draftConfig struct {
+	Csv     draftCsvConfig `json:"csv"`
+	Actions []draftAction  `json:"actions"`
+}
+
+type draftCsvConfig struct {
+	Delimiter string `json:"delimiter,omitempty"`
+	Header    bool   `json:"header"`
+}
+
+type draftAction struct {
+	Name       string           `json:"name"`
+	Column     string           `json:"column,omitempty"`
+	DateConfig *draftDateConfig `json:"dateConfig,omitempty"`
+	HmacConfig *draftHmacConfig `json:"hmacConfig,omitempty"`
+	Comment    string           `json:"comment"`
+}
+
+type draftHmacConfig struct {
+	KeyFile string `json:"keyFile"`
+}
+
+// Placeholder of the path of the key of the hmac actions suggested,
+// to be replaced with the file of a secret key
+const draftKeyFile = "/path/to/hmac.key"
+
+type draftDateConfig struct {
+	Format      string `json:"format"`
+	Granularity string `json:"granularity"`
+}
+
+// Returns the action suggested for the column
+func (c *ColumnScan) action() draftAction {
+	if c.Detected == "" {
+		return draftAction{Name: "nothing", Comment: "no personal data detected"}
+	}
+	// a keyed hash, as the values detected (e.g. emails or phone numbers)
+	// are few enough to be found by hashing all of them
+	action := draftAction{
+		Name:       "hmac",
+		HmacConfig: &draftHmacConfig{KeyFile: draftKeyFile},
+		Comment:    fmt.Sprintf("%s detected in %.0f%% of the values", c.Detected, 100*c.Confidence),
+	}
+	switch c.Detected {
+	case "postcode":
+		action.Name, action.HmacConfig = "outcode", nil
+	case "date":
+		action.Name, action.HmacConfig = "date", nil
+		action.DateConfig = &draftDateConfig{Format: c.DateFormat, Granularity: "month"}
+	}
+	return action
+}
+
+// WriteConfig writes a draft config with the actions suggested for the
+// columns with personal data, annotated with what has been detected. If
+// the csv doesn't have a header, it has an action for every column.
+func (r *ScanReport) WriteConfig(w io.Writer) error {
+	conf := draftConfig{Csv: draftCsvConfig{Delimiter: r.Delimiter, Header: r.Header}, Actions: []draftAction{}}
+	for _, column := range r.Columns {
+		if r.Header && column.Detected == "" {
+			continue
+		}
+		action := column.action()
+		if r.Header {
+			action.Column = column.Column
+		}
+		conf.Actions = append(conf.Actions, action)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(conf)
+}
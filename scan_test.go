@@ -0,0 +1,136 @@
+package anon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLuhn(t *testing.T) {
+	assert.True(t, luhn("4111111111111111"), "should accept a valid card number")
+	assert.False(t, luhn("4111111111111112"), "should reject an invalid card number")
+}
+
+func TestColumnScan(t *testing.T) {
+	scan := func(name string, hasHeader bool, values ...string) ColumnScan {
+		c := ColumnScan{Column: name}
+		c.scan(values, hasHeader)
+		return c
+	}
+	for expected, values := range map[string][]string{
+		"email":    {"a@b.com", "john.smith@example.co.uk"},
+		"phone":    {"07700 900123", "+44 20 7946 0958"},
+		"postcode": {"SW1A 1AA", "m1 1ae"},
+		"ip":       {"192.168.0.1", "2001:db8::1"},
+		"card":     {"4111 1111 1111 1111", "5500-0000-0000-0004"},
+		"id":       {"1001", "1002", "1003"},
+	} {
+		c := scan("column", true, values...)
+		assert.Equal(t, expected, c.Detected, "should detect %v", values)
+		assert.Equal(t, 1.0, c.Confidence)
+	}
+	t.Run("with dates", func(t *testing.T) {
+		c := scan("dob", true, "25/12/1980", "01/02/1990")
+		assert.Equal(t, "date", c.Detected)
+		assert.Equal(t, "02/01/2006", c.DateFormat, "should guess the format of the dates")
+		c = scan("dob", true, "12/25/1980", "01/02/1990")
+		assert.Equal(t, "01/02/2006", c.DateFormat, "should guess the format that parses most values")
+	})
+	t.Run("with names", func(t *testing.T) {
+		assert.Equal(t, "name", scan("first_name", true, "John", "Mary Jane").Detected, "should detect names if the header says so")
+		assert.Equal(t, "", scan("city", true, "London", "Leeds").Detected, "should not detect other proper nouns as names")
+		assert.Equal(t, 0.5, scan("1", false, "John", "Mary Jane").Confidence, "should halve the confidence without a header")
+	})
+	t.Run("without personal data", func(t *testing.T) {
+		assert.Equal(t, "", scan("count", true, "1", "1", "2").Detected, "should not detect repeated numbers as ids")
+		assert.Equal(t, "", scan("email", true, "a@b.com", "x", "y").Detected, "should not detect a kind of data in a few values")
+		assert.Equal(t, "", scan("empty", true).Detected)
+	})
+}
+
+func TestScan(t *testing.T) {
+	input := "id,email,postcode,dob,notes\n1,a@b.com,SW1A 1AA,1980-12-25,x\n2,c@d.com,M1 1AE,1990-01-02,y\n"
+	t.Run("with a header", func(t *testing.T) {
+		report, err := Scan(strings.NewReader(input), ScanConfig{Csv: CsvConfig{Delimiter: ",", DetectHeader: true}})
+		require.NoError(t, err)
+		assert.True(t, report.Header, "should detect the header")
+		var out bytes.Buffer
+		require.NoError(t, report.WriteConfig(&out))
+		assert.Equal(t, `{
+  "csv": {
+    "delimiter": ",",
+    "header": true
+  },
+  "actions": [
+    {
+      "name": "hmac",
+      "column": "id",
+      "hmacConfig": {
+        "keyFile": "/path/to/hmac.key"
+      },
+      "comment": "id detected in 100% of the values"
+    },
+    {
+      "name": "hmac",
+      "column": "email",
+      "hmacConfig": {
+        "keyFile": "/path/to/hmac.key"
+      },
+      "comment": "email detected in 100% of the values"
+    },
+    {
+      "name": "outcode",
+      "column": "postcode",
+      "comment": "postcode detected in 100% of the values"
+    },
+    {
+      "name": "date",
+      "column": "dob",
+      "dateConfig": {
+        "format": "2006-01-02",
+        "granularity": "month"
+      },
+      "comment": "date detected in 100% of the values"
+    }
+  ]
+}
+`, out.String(), "should write a draft config with the columns with personal data")
+
+		conf, err := ReadConfig(&out)
+		require.NoError(t, err)
+		_, err = NewProcessor(conf, Options{})
+		assert.Error(t, err, "should need the key to be set")
+		key := filepath.Join(t.TempDir(), "hmac.key")
+		require.NoError(t, ioutil.WriteFile(key, []byte("secret"), 0600))
+		for i := range conf.Actions {
+			if conf.Actions[i].Name == "hmac" {
+				conf.Actions[i].HmacConfig.KeyFile = key
+			}
+		}
+		_, err = NewProcessor(conf, Options{})
+		assert.NoError(t, err, "should write a valid config once the key is set")
+	})
+	t.Run("without a header", func(t *testing.T) {
+		report, err := Scan(strings.NewReader("a@b.com,x\nc@d.com,y\ne@f.com,z\n"), ScanConfig{Rows: 2})
+		require.NoError(t, err)
+		var out bytes.Buffer
+		require.NoError(t, report.WriteConfig(&out))
+		conf, err := ReadConfig(&out)
+		require.NoError(t, err)
+		assert.False(t, conf.Csv.Header)
+		assert.Equal(t, []ActionConfig{
+			ActionConfig{Name: "hmac", HmacConfig: HmacConfig{KeyConfig: KeyConfig{KeyFile: "/path/to/hmac.key"}}, Comment: "email detected in 100% of the values"},
+			ActionConfig{Name: "nothing", Comment: "no personal data detected"},
+		}, conf.Actions, "should write an action for every column")
+	})
+	t.Run("with an empty csv", func(t *testing.T) {
+		report, err := Scan(strings.NewReader(""), ScanConfig{Csv: CsvConfig{DetectHeader: true}})
+		require.NoError(t, err)
+		assert.Empty(t, report.Columns)
+	})
+}
@@ -0,0 +1,144 @@
+package anon
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// ErrAuthentication is returned when a value can't be decrypted
+// because it was encrypted with a different key or has been modified
+var ErrAuthentication = errors.New("authentication failed: the value was not encrypted with this key or has been modified")
+
+// siv implements the deterministic authenticated encryption
+// AES-SIV described in RFC 5297.
+type siv struct {
+	mac cipher.Block
+	ctr cipher.Block
+}
+
+// Creates a new AES-SIV cipher, the key must be 32, 48 or 64 bytes
+// long (AES-128, AES-192 or AES-256 respectively).
+func newSIV(key []byte) (*siv, error) {
+	if len(key) != 32 && len(key) != 48 && len(key) != 64 {
+		return nil, fmt.Errorf("invalid AES-SIV key size %d, it must be 32, 48 or 64 bytes", len(key))
+	}
+	mac, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &siv{mac: mac, ctr: ctr}, nil
+}
+
+// Returns the synthetic IV followed by the encrypted plaintext
+func (c *siv) seal(plaintext []byte, ad ...[]byte) []byte {
+	v := c.s2v(plaintext, ad...)
+	res := make([]byte, aes.BlockSize+len(plaintext))
+	copy(res, v)
+	c.xorKeyStream(res[aes.BlockSize:], plaintext, v)
+	return res
+}
+
+// Decrypts the ciphertext returned by seal, failing if
+// it can't be authenticated
+func (c *siv) open(ciphertext []byte, ad ...[]byte) ([]byte, error) {
+	if len(ciphertext) < aes.BlockSize {
+		return nil, ErrAuthentication
+	}
+	v := ciphertext[:aes.BlockSize]
+	res := make([]byte, len(ciphertext)-aes.BlockSize)
+	c.xorKeyStream(res, ciphertext[aes.BlockSize:], v)
+	if subtle.ConstantTimeCompare(v, c.s2v(res, ad...)) != 1 {
+		return nil, ErrAuthentication
+	}
+	return res, nil
+}
+
+func (c *siv) xorKeyStream(dst []byte, src []byte, v []byte) {
+	q := make([]byte, aes.BlockSize)
+	copy(q, v)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	cipher.NewCTR(c.ctr, q).XORKeyStream(dst, src)
+}
+
+// S2V as described in section 2.4 of RFC 5297
+func (c *siv) s2v(plaintext []byte, ad ...[]byte) []byte {
+	d := c.cmac(make([]byte, aes.BlockSize))
+	for _, s := range ad {
+		d = dbl(d)
+		xor(d, c.cmac(s))
+	}
+	var t []byte
+	if len(plaintext) >= aes.BlockSize {
+		t = append([]byte{}, plaintext...)
+		xor(t[len(t)-aes.BlockSize:], d)
+	} else {
+		t = dbl(d)
+		xor(t, pad(plaintext))
+	}
+	return c.cmac(t)
+}
+
+// AES-CMAC as described in RFC 4493
+func (c *siv) cmac(m []byte) []byte {
+	k1 := make([]byte, aes.BlockSize)
+	c.mac.Encrypt(k1, k1)
+	k1 = dbl(k1)
+	k2 := dbl(k1)
+
+	n := (len(m) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+	last := make([]byte, aes.BlockSize)
+	if len(m) > 0 && len(m)%aes.BlockSize == 0 {
+		copy(last, m[(n-1)*aes.BlockSize:])
+		xor(last, k1)
+	} else {
+		copy(last, pad(m[(n-1)*aes.BlockSize:]))
+		xor(last, k2)
+	}
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xor(x, m[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		c.mac.Encrypt(x, x)
+	}
+	xor(x, last)
+	c.mac.Encrypt(x, x)
+	return x
+}
+
+// Multiplies the block by x in GF(2^128)
+func dbl(b []byte) []byte {
+	res := make([]byte, len(b))
+	for i := 0; i < len(b)-1; i++ {
+		res[i] = b[i]<<1 | b[i+1]>>7
+	}
+	res[len(b)-1] = b[len(b)-1] << 1
+	if b[0]&0x80 != 0 {
+		res[len(b)-1] ^= 0x87
+	}
+	return res
+}
+
+// Pads the input (shorter than a block) with a 1 followed by 0s
+func pad(b []byte) []byte {
+	res := make([]byte, aes.BlockSize)
+	copy(res, b)
+	res[len(b)] = 0x80
+	return res
+}
+
+// Xors src into dst
+func xor(dst []byte, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
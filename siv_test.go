@@ -0,0 +1,73 @@
+package anon
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// Test vector from the appendix A.1 of RFC 5297
+func TestSIVSeal(t *testing.T) {
+	c, err := newSIV(decodeHex("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))
+	require.NoError(t, err)
+	ad := decodeHex("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext := decodeHex("112233445566778899aabbccddee")
+	ciphertext := decodeHex("85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c")
+
+	assert.Equal(t, ciphertext, c.seal(plaintext, ad), "should encrypt the plaintext")
+	res, err := c.open(ciphertext, ad)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, res, "should decrypt the ciphertext")
+}
+
+func TestSIVOpen(t *testing.T) {
+	key := decodeHex("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	c, _ := newSIV(key)
+	t.Run("with any plaintext", func(t *testing.T) {
+		properties := gopter.NewProperties(nil)
+
+		properties.Property("open(seal(s)) == s", prop.ForAll(
+			func(s string) bool {
+				res, err := c.open(c.seal([]byte(s)))
+				return assert.NoError(t, err) && assert.Equal(t, s, string(res))
+			},
+			gen.AnyString(),
+		))
+
+		properties.TestingRun(t)
+	})
+	t.Run("with a modified ciphertext", func(t *testing.T) {
+		ciphertext := c.seal([]byte("hasselhoff"))
+		ciphertext[len(ciphertext)-1] ^= 1
+		res, err := c.open(ciphertext)
+		assert.Equal(t, ErrAuthentication, err, "should fail to authenticate")
+		assert.Nil(t, res)
+	})
+	t.Run("with a different key", func(t *testing.T) {
+		other, _ := newSIV(decodeHex("00fefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))
+		res, err := other.open(c.seal([]byte("hasselhoff")))
+		assert.Equal(t, ErrAuthentication, err, "should fail to authenticate")
+		assert.Nil(t, res)
+	})
+	t.Run("with a ciphertext too short", func(t *testing.T) {
+		res, err := c.open([]byte("short"))
+		assert.Equal(t, ErrAuthentication, err, "should fail to authenticate")
+		assert.Nil(t, res)
+	})
+}
+
+func TestNewSIV(t *testing.T) {
+	c, err := newSIV(decodeHex("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0"))
+	assert.Error(t, err, "should fail with an invalid key size")
+	assert.Nil(t, c)
+}
@@ -0,0 +1,182 @@
+package anon
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Number of bits used to index the registers of the hyperLogLog,
+// it uses 2^14 registers (16KB) and has a standard error of ~0.8%
+const hllPrecision = 14
+
+// hyperLogLog estimates the number of distinct values added to it
+// using a bounded amount of memory
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(s string) {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	// FNV doesn't distribute the bits well enough, so it's
+	// mixed with the finalizer of splitmix64
+	x := f.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+func (h *hyperLogLog) count() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// ColumnStats stores the statistics of a column
+type ColumnStats struct {
+	Column int `json:"column"`
+	// Number of errors by action, including the ones
+	// handled by their error policy
+	Errors map[string]int64 `json:"errors,omitempty"`
+	// Approximate number of distinct values written
+	Distinct uint64 `json:"distinct"`
+	distinct *hyperLogLog
+}
+
+// Stats stores the statistics of a run
+type Stats struct {
+	Read       int64 `json:"read"`
+	SampledOut int64 `json:"sampledOut"`
+	Written    int64 `json:"written"`
+	Rejected   int64 `json:"rejected"`
+	// Records suppressed or generalised to enforce k-anonymity
+	Suppressed  int64          `json:"suppressed,omitempty"`
+	Generalised int64          `json:"generalised,omitempty"`
+	Columns     []*ColumnStats `json:"columns"`
+	// Privacy budget spent on the columns with noise
+	Privacy    []PrivacyBudget `json:"privacy,omitempty"`
+	WallTime   float64         `json:"wallTimeSeconds"`
+	Throughput float64         `json:"recordsPerSecond"`
+	start      time.Time
+}
+
+// NewStats returns empty statistics, starting to count the wall time
+func NewStats() *Stats {
+	return &Stats{Columns: []*ColumnStats{}, start: time.Now()}
+}
+
+// Returns the stats of the column, creating them if needed
+func (s *Stats) column(i int) *ColumnStats {
+	for len(s.Columns) <= i {
+		s.Columns = append(s.Columns, &ColumnStats{
+			Column:   len(s.Columns),
+			Errors:   map[string]int64{},
+			distinct: newHyperLogLog(),
+		})
+	}
+	return s.Columns[i]
+}
+
+func (s *Stats) read() {
+	if s != nil {
+		s.Read++
+	}
+}
+
+func (s *Stats) sampledOut() {
+	if s != nil {
+		s.SampledOut++
+	}
+}
+
+func (s *Stats) written(rec record) {
+	if s == nil {
+		return
+	}
+	s.Written++
+	rec.eachValue(func(i int, v string) {
+		s.column(i).distinct.add(v)
+	})
+}
+
+func (s *Stats) suppressed() {
+	if s != nil {
+		s.Suppressed++
+	}
+}
+
+func (s *Stats) generalised() {
+	if s != nil {
+		s.Generalised++
+	}
+}
+
+func (s *Stats) rejected(rej rejection) {
+	if s == nil {
+		return
+	}
+	s.Rejected++
+	s.failed(rej)
+}
+
+// Counts the error of the action, whether the record has been
+// rejected or the error has been handled by its error policy
+func (s *Stats) failed(rej rejection) {
+	if s != nil && rej.column >= 0 {
+		s.column(rej.column).Errors[rej.action]++
+	}
+}
+
+// Records the privacy budget spent by the run
+func (s *Stats) spent(budgets []PrivacyBudget) {
+	if s != nil {
+		s.Privacy = budgets
+	}
+}
+
+// Computes the statistics that depend on the whole run
+func (s *Stats) finish() {
+	if s == nil {
+		return
+	}
+	s.WallTime = time.Since(s.start).Seconds()
+	if s.WallTime > 0 {
+		s.Throughput = float64(s.Read) / s.WallTime
+	}
+	for _, c := range s.Columns {
+		c.Distinct = c.distinct.count()
+	}
+}
+
+// WriteJSON writes the statistics as JSON
+func (s *Stats) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
@@ -0,0 +1,66 @@
+package anon
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	t.Run("with a few values", func(t *testing.T) {
+		h := newHyperLogLog()
+		for _, v := range []string{"a", "b", "a", "c", "b"} {
+			h.add(v)
+		}
+		assert.Equal(t, uint64(3), h.count(), "should count the distinct values")
+	})
+	t.Run("with many values", func(t *testing.T) {
+		h := newHyperLogLog()
+		for i := 0; i < 100000; i++ {
+			h.add(strconv.Itoa(i % 50000))
+		}
+		assert.InEpsilon(t, 50000, h.count(), 0.03, "should estimate the distinct values")
+	})
+}
+
+func TestStats(t *testing.T) {
+	t.Run("collects the statistics", func(t *testing.T) {
+		st := NewStats()
+		st.read()
+		st.read()
+		st.read()
+		st.sampledOut()
+		st.written(csvRecord{"a", "b"})
+		st.rejected(rejection{column: 1, action: "year"})
+		st.finish()
+
+		assert.Equal(t, int64(3), st.Read)
+		assert.Equal(t, int64(1), st.SampledOut)
+		assert.Equal(t, int64(1), st.Written)
+		assert.Equal(t, int64(1), st.Rejected)
+		assert.Len(t, st.Columns, 2)
+		assert.Equal(t, uint64(1), st.Columns[0].Distinct)
+		assert.Equal(t, map[string]int64{"year": 1}, st.Columns[1].Errors)
+	})
+	t.Run("without stats", func(t *testing.T) {
+		var st *Stats
+		st.read()
+		st.sampledOut()
+		st.written(csvRecord{"a"})
+		st.rejected(rejection{column: 1, action: "year"})
+		st.finish()
+		assert.Nil(t, st, "should do nothing")
+	})
+	t.Run("writes the statistics as JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		st := &Stats{Read: 2, Written: 1, Columns: []*ColumnStats{&ColumnStats{Column: 0, Distinct: 1}}}
+		assert.NoError(t, st.WriteJSON(&out))
+		assert.JSONEq(t, `{
+			"read": 2, "sampledOut": 0, "written": 1, "rejected": 0,
+			"columns": [{"column": 0, "distinct": 1}],
+			"wallTimeSeconds": 0, "recordsPerSecond": 0
+		}`, out.String())
+	})
+}
@@ -0,0 +1,326 @@
+package anon
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Types of the thrift compact protocol
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftStruct    = 12
+)
+
+// Maximum depth of nested structs and lists, so a
+// corrupted input can't exhaust the stack
+const thriftMaxDepth = 64
+
+var errThriftEOF = errors.New("unexpected end of thrift data")
+
+// tField is a field of a thrift struct. Its value is a bool, an int64
+// (for any integer type), a float64, a []byte, a tList or a tStruct.
+type tField struct {
+	id    int16
+	typ   byte
+	value interface{}
+}
+
+// tStruct is a thrift struct decoded without its IDL, with
+// the fields in the same order they were encoded
+type tStruct []tField
+
+// tList is a thrift list (or set) with the type of its elements
+type tList struct {
+	typ    byte
+	values []interface{}
+}
+
+// Returns the value of the field, nil if it's not set
+func (s tStruct) get(id int16) interface{} {
+	for _, f := range s {
+		if f.id == id {
+			return f.value
+		}
+	}
+	return nil
+}
+
+func (s tStruct) has(id int16) bool {
+	return s.get(id) != nil
+}
+
+// Returns the integer value of the field, 0 if it's not set
+func (s tStruct) int(id int16) int64 {
+	v, _ := s.get(id).(int64)
+	return v
+}
+
+func (s tStruct) bool(id int16) bool {
+	v, _ := s.get(id).(bool)
+	return v
+}
+
+func (s tStruct) string(id int16) string {
+	v, _ := s.get(id).([]byte)
+	return string(v)
+}
+
+func (s tStruct) strct(id int16) tStruct {
+	v, _ := s.get(id).(tStruct)
+	return v
+}
+
+func (s tStruct) list(id int16) []interface{} {
+	v, _ := s.get(id).(tList)
+	return v.values
+}
+
+// Returns the elements of a list of structs, or an
+// error if any of them isn't a struct
+func (s tStruct) structs(id int16) ([]tStruct, error) {
+	values := s.list(id)
+	res := make([]tStruct, len(values))
+	for i, v := range values {
+		var ok bool
+		if res[i], ok = v.(tStruct); !ok {
+			return nil, fmt.Errorf("field %d is not a list of structs", id)
+		}
+	}
+	return res, nil
+}
+
+// thriftDecoder decodes the thrift compact protocol
+type thriftDecoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *thriftDecoder) byte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, errThriftEOF
+	}
+	d.pos++
+	return d.buf[d.pos-1], nil
+}
+
+func (d *thriftDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		return 0, errThriftEOF
+	}
+	d.pos += n
+	return v, nil
+}
+
+func (d *thriftDecoder) varint() (int64, error) {
+	v, err := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (d *thriftDecoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, errThriftEOF
+	}
+	d.pos += int(n)
+	return d.buf[d.pos-int(n) : d.pos], nil
+}
+
+// Decodes a struct, starting after its type
+func (d *thriftDecoder) strct(depth int) (tStruct, error) {
+	if depth > thriftMaxDepth {
+		return nil, errors.New("thrift data nested too deeply")
+	}
+	var s tStruct
+	var id int16
+	for {
+		b, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		typ := b & 0x0f
+		var value interface{}
+		switch typ {
+		case thriftBoolTrue, thriftBoolFalse:
+			value = typ == thriftBoolTrue
+		default:
+			if value, err = d.value(typ, depth); err != nil {
+				return nil, err
+			}
+		}
+		s = append(s, tField{id: id, typ: typ, value: value})
+	}
+}
+
+func (d *thriftDecoder) value(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		// elements of a list have the value in a byte
+		b, err := d.byte()
+		return b == thriftBoolTrue, err
+	case thriftByte:
+		b, err := d.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return d.varint()
+	case thriftDouble:
+		if len(d.buf)-d.pos < 8 {
+			return nil, errThriftEOF
+		}
+		d.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[d.pos-8:])), nil
+	case thriftBinary:
+		return d.bytes()
+	case thriftList, thriftSet:
+		return d.list(depth + 1)
+	case thriftStruct:
+		return d.strct(depth + 1)
+	}
+	return nil, fmt.Errorf("unsupported thrift type %d", typ)
+}
+
+func (d *thriftDecoder) list(depth int) (tList, error) {
+	b, err := d.byte()
+	if err != nil {
+		return tList{}, err
+	}
+	l := tList{typ: b & 0x0f}
+	n := uint64(b >> 4)
+	if n == 15 {
+		if n, err = d.uvarint(); err != nil {
+			return tList{}, err
+		}
+	}
+	// every element takes at least a byte
+	if n > uint64(len(d.buf)-d.pos) {
+		return tList{}, errThriftEOF
+	}
+	for i := uint64(0); i < n; i++ {
+		v, err := d.value(l.typ, depth)
+		if err != nil {
+			return tList{}, err
+		}
+		l.values = append(l.values, v)
+	}
+	return l, nil
+}
+
+// Decodes a struct from the beginning of the buffer,
+// returning the number of bytes it takes
+func decodeThrift(buf []byte) (tStruct, int, error) {
+	d := &thriftDecoder{buf: buf}
+	s, err := d.strct(0)
+	return s, d.pos, err
+}
+
+// Encodes a struct with the thrift compact protocol
+func encodeThrift(s tStruct) []byte {
+	return appendThriftStruct(nil, s)
+}
+
+func appendThriftStruct(buf []byte, s tStruct) []byte {
+	var last int16
+	for _, f := range s {
+		typ := f.typ
+		if b, ok := f.value.(bool); ok && (typ == thriftBoolTrue || typ == thriftBoolFalse) {
+			typ = thriftBoolFalse
+			if b {
+				typ = thriftBoolTrue
+			}
+		}
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			buf = append(buf, byte(delta)<<4|typ)
+		} else {
+			buf = append(buf, typ)
+			buf = binary.AppendVarint(buf, int64(f.id))
+		}
+		last = f.id
+		if typ != thriftBoolTrue && typ != thriftBoolFalse {
+			buf = appendThriftValue(buf, typ, f.value)
+		}
+	}
+	return append(buf, 0)
+}
+
+func appendThriftValue(buf []byte, typ byte, value interface{}) []byte {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		if value.(bool) {
+			return append(buf, thriftBoolTrue)
+		}
+		return append(buf, thriftBoolFalse)
+	case thriftByte:
+		return append(buf, byte(value.(int64)))
+	case thriftI16, thriftI32, thriftI64:
+		return binary.AppendVarint(buf, value.(int64))
+	case thriftDouble:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(value.(float64)))
+	case thriftBinary:
+		b := value.([]byte)
+		buf = binary.AppendUvarint(buf, uint64(len(b)))
+		return append(buf, b...)
+	case thriftList, thriftSet:
+		l := value.(tList)
+		if len(l.values) < 15 {
+			buf = append(buf, byte(len(l.values))<<4|l.typ)
+		} else {
+			buf = append(buf, 0xf0|l.typ)
+			buf = binary.AppendUvarint(buf, uint64(len(l.values)))
+		}
+		for _, v := range l.values {
+			buf = appendThriftValue(buf, l.typ, v)
+		}
+		return buf
+	case thriftStruct:
+		return appendThriftStruct(buf, value.(tStruct))
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", typ))
+}
+
+// Helpers to build the fields of a struct to encode
+
+func tI32(id int16, v int64) tField {
+	return tField{id: id, typ: thriftI32, value: v}
+}
+
+func tI64(id int16, v int64) tField {
+	return tField{id: id, typ: thriftI64, value: v}
+}
+
+func tBinary(id int16, v string) tField {
+	return tField{id: id, typ: thriftBinary, value: []byte(v)}
+}
+
+func tStructField(id int16, v tStruct) tField {
+	return tField{id: id, typ: thriftStruct, value: v}
+}
+
+func tListField(id int16, typ byte, values ...interface{}) tField {
+	return tField{id: id, typ: thriftList, value: tList{typ: typ, values: values}}
+}
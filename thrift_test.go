@@ -0,0 +1,62 @@
+package anon
+
+import (
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeThrift(t *testing.T) {
+	t.Run("with a known struct", func(t *testing.T) {
+		// {1: i32 3, 2: bool true, 20: binary "ab", 21: list<i32> [1, -1], 22: struct {1: i64 -2}}
+		data := []byte{0x15, 0x06, 0x11, 0x08, 0x28, 0x02, 'a', 'b', 0x19, 0x25, 0x02, 0x01, 0x1c, 0x16, 0x03, 0x00, 0x00}
+		s, n, err := decodeThrift(data)
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), n, "should return the number of bytes read")
+		assert.Equal(t, int64(3), s.int(1))
+		assert.True(t, s.bool(2))
+		assert.Equal(t, "ab", s.string(20))
+		assert.Equal(t, []interface{}{int64(1), int64(-1)}, s.list(21))
+		assert.Equal(t, int64(-2), s.strct(22).int(1))
+		assert.False(t, s.has(3), "should return if a field is not set")
+		assert.Equal(t, data, encodeThrift(s), "should encode it back to the same bytes")
+	})
+	t.Run("with truncated data", func(t *testing.T) {
+		for _, data := range [][]byte{{}, {0x15}, {0x18, 0x05, 'a'}, {0x19, 0x35, 0x02}} {
+			_, _, err := decodeThrift(data)
+			assert.Error(t, err, "should return an error for %v", data)
+		}
+	})
+}
+
+func TestThriftProperties(t *testing.T) {
+	properties := gopter.NewProperties(nil)
+	properties.Property("decode(encode(s)) == s", prop.ForAll(
+		func(id int16, i int64, s string, b bool, l []int32) bool {
+			values := []interface{}{}
+			for _, v := range l {
+				values = append(values, int64(v))
+			}
+			st := tStruct{
+				tI64(id, i),
+				tBinary(id+1, s),
+				tField{id: id + 20, typ: thriftBoolTrue, value: b},
+				tListField(id+21, thriftI32, values...),
+				tStructField(id+40, tStruct{tI32(1, i>>32)}),
+			}
+			res, n, err := decodeThrift(encodeThrift(st))
+			return err == nil && n == len(encodeThrift(st)) &&
+				res.int(id) == i && res.string(id+1) == s && res.bool(id+20) == b &&
+				len(res.list(id+21)) == len(l) && res.strct(id+40).int(1) == i>>32
+		},
+		gen.Int16Range(1, 1000),
+		gen.Int64(),
+		gen.AnyString(),
+		gen.Bool(),
+		gen.SliceOf(gen.Int32()),
+	))
+	properties.TestingRun(t)
+}
@@ -0,0 +1,394 @@
+package anon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is a problem found validating a config
+type Problem struct {
+	// JSON path of the value with the problem, e.g. actions[1].dateConfig.format,
+	// empty if the problem is about the config as a whole
+	Path string
+	// Position of the value in the JSON, 0 if it isn't known
+	Line   int
+	Column int
+	// If true, the config can be used, but it's likely a mistake
+	Warning bool
+	Message string
+}
+
+func (p Problem) String() string {
+	msg := p.Message
+	if p.Warning {
+		msg = "warning: " + msg
+	}
+	if p.Path != "" {
+		msg = p.Path + ": " + msg
+	}
+	if p.Line > 0 {
+		msg = fmt.Sprintf("%d:%d: %s", p.Line, p.Column, msg)
+	}
+	return msg
+}
+
+// ValidateConfig reads a config from JSON and returns the problems found
+// in it: syntax errors, unknown fields, values of the wrong type, unknown
+// actions, overlapping or gapped ranges, invalid date layouts and any other
+// error the config would fail with when creating a Processor.
+func ValidateConfig(r io.Reader) ([]Problem, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	v := &configValidator{data: data, positions: map[string]int{}}
+	v.dec = json.NewDecoder(bytes.NewReader(data))
+	v.dec.UseNumber()
+	if err := v.walk("", reflect.TypeOf(Config{})); err != nil {
+		offset := v.dec.InputOffset()
+		if syntax, ok := err.(*json.SyntaxError); ok {
+			offset = syntax.Offset
+		}
+		line, column := v.lineColumn(int(offset))
+		return []Problem{Problem{Line: line, Column: column, Message: err.Error()}}, nil
+	}
+	if len(v.problems) > 0 {
+		return v.problems, nil
+	}
+	conf, err := ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		return []Problem{Problem{Message: err.Error()}}, nil
+	}
+	v.checkConfig(conf)
+	return v.problems, nil
+}
+
+// configValidator walks the JSON of a config, keeping the position of
+// each path, and collects the problems found
+type configValidator struct {
+	data      []byte
+	dec       *json.Decoder
+	positions map[string]int
+	problems  []Problem
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// Returns the offset where the next token starts
+func (v *configValidator) next() int {
+	offset := int(v.dec.InputOffset())
+	for offset < len(v.data) && strings.IndexByte(" \t\r\n,:", v.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// Returns the line and column (both starting at 1) of the offset
+func (v *configValidator) lineColumn(offset int) (int, int) {
+	if offset > len(v.data) {
+		offset = len(v.data)
+	}
+	before := v.data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	return line, offset - bytes.LastIndexByte(before, '\n')
+}
+
+// Adds a problem with the value at the path
+func (v *configValidator) add(path string, warning bool, format string, args ...interface{}) {
+	p := Problem{Path: path, Warning: warning, Message: fmt.Sprintf(format, args...)}
+	if offset, ok := v.positions[path]; ok && path != "" {
+		p.Line, p.Column = v.lineColumn(offset)
+	}
+	v.problems = append(v.problems, p)
+}
+
+// Reads the next value, checking that it can be decoded into a value of
+// type t (if t is nil, any value can). Fields are kept by the position
+// of their key and the rest of values by the position of the value.
+func (v *configValidator) walk(path string, t reflect.Type) error {
+	if _, ok := v.positions[path]; !ok {
+		v.positions[path] = v.next()
+	}
+	tok, err := v.dec.Token()
+	if err != nil {
+		return err
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == rawMessageType || t != nil && t.Kind() == reflect.Interface {
+		t = nil
+	}
+	expected := func(kind string) {
+		if t != nil {
+			v.add(path, false, "expected %s, found %s", jsonKind(t), kind)
+		}
+	}
+	switch x := tok.(type) {
+	case json.Delim:
+		if x == '[' {
+			var elem reflect.Type
+			if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+				elem = t.Elem()
+			} else {
+				expected("an array")
+			}
+			for i := 0; v.dec.More(); i++ {
+				if err := v.walk(fmt.Sprintf("%s[%d]", path, i), elem); err != nil {
+					return err
+				}
+			}
+			_, err = v.dec.Token()
+			return err
+		}
+		if t != nil && t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+			expected("an object")
+			t = nil
+		}
+		for v.dec.More() {
+			offset := v.next()
+			key, err := v.dec.Token()
+			if err != nil {
+				return err
+			}
+			name := key.(string)
+			child := name
+			if path != "" {
+				child = path + "." + name
+			}
+			v.positions[child] = offset
+			var field reflect.Type
+			if t != nil && t.Kind() == reflect.Map {
+				field = t.Elem()
+			} else if t != nil {
+				f, ok := jsonField(t, name)
+				if !ok {
+					v.add(child, false, "unknown field %s", name)
+				}
+				field = f.Type
+			}
+			if err := v.walk(child, field); err != nil {
+				return err
+			}
+		}
+		_, err = v.dec.Token()
+		return err
+	case nil:
+	case string:
+		if t != nil && t.Kind() != reflect.String {
+			expected("a string")
+		}
+	case bool:
+		if t != nil && t.Kind() != reflect.Bool {
+			expected("a boolean")
+		}
+	case json.Number:
+		v.checkNumber(path, t, x)
+	}
+	return nil
+}
+
+// Checks that the number can be decoded into a value of type t
+func (v *configValidator) checkNumber(path string, t reflect.Type, n json.Number) {
+	if t == nil {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.ParseInt(n.String(), 10, t.Bits()); err != nil {
+			v.add(path, false, "%s is out of range, it must be an integer between %d and %d", n, int64(-1)<<(t.Bits()-1), int64(1)<<(t.Bits()-1)-1)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, err := strconv.ParseUint(n.String(), 10, t.Bits()); err != nil {
+			v.add(path, false, "%s is out of range, it must be an integer between 0 and %d", n, uint64(1)<<t.Bits()-1)
+		}
+	default:
+		v.add(path, false, "expected %s, found a number", jsonKind(t))
+	}
+}
+
+// Returns the field of the struct the JSON key is decoded into, following
+// the rules of encoding/json: the exact name first, or ignoring the case
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	var folded *reflect.StructField
+	var find func(t reflect.Type) (reflect.StructField, bool)
+	find = func(t reflect.Type) (reflect.StructField, bool) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				if res, ok := find(f.Type); ok {
+					return res, true
+				}
+				continue
+			}
+			key := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.PkgPath != "" || key == "-" {
+				continue
+			} else if key == "" {
+				key = f.Name
+			}
+			if key == name {
+				return f, true
+			} else if folded == nil && strings.EqualFold(key, name) {
+				folded = &f
+			}
+		}
+		return reflect.StructField{}, false
+	}
+	if f, ok := find(t); ok {
+		return f, true
+	} else if folded != nil {
+		return *folded, true
+	}
+	return reflect.StructField{}, false
+}
+
+// Returns the kind of JSON value a value of type t is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	}
+	return "a number"
+}
+
+// Checks the values of the config
+func (v *configValidator) checkConfig(conf *Config) {
+	for i, ac := range conf.Actions {
+		v.checkAction(fmt.Sprintf("actions[%d]", i), &ac)
+	}
+	for i, column := range conf.Output {
+		for j, ac := range column.Actions {
+			v.checkAction(fmt.Sprintf("output[%d].actions[%d]", i, j), &ac)
+		}
+	}
+	if len(v.problems) > 0 {
+		return
+	}
+	if _, err := formatSchema(conf); err != nil {
+		v.add("", false, "%v", err)
+	}
+	if conf.withoutHeader() {
+		if path, err := headerlessColumns(conf); err != nil {
+			v.add(path, false, "%v", err)
+		} else if len(conf.Output) == 0 && len(conf.Actions) > 0 && int(conf.Sampling.IDColumn) >= len(conf.Actions) {
+			v.add("sampling.idColumn", true, "idColumn %d is out of the %d columns with actions", conf.Sampling.IDColumn, len(conf.Actions))
+		}
+	}
+	if _, err := anonymisations(conf.Actions); err != nil {
+		v.add("actions", false, "%v", err)
+	} else if _, err := outputAnonymisations(conf.Output); err != nil {
+		v.add("output", false, "%v", err)
+	} else if _, err := configContextAnonymisations(conf); err != nil {
+		v.add("actions", false, "%v", err)
+	}
+}
+
+// Actions whose dates are parsed with the layouts in DateConfig
+var dateActions = map[string]bool{"year": true, "date": true, "dateShift": true, "age": true}
+
+// Checks the name, the ranges and the date layouts of the action,
+// and of the steps of its pipeline
+func (v *configValidator) checkAction(path string, ac *ActionConfig) {
+	if _, ok := lookupAction(ac.Name); !ok {
+		v.add(path+".name", false, "unknown action %q, it must be one of %s", ac.Name, strings.Join(Actions(), ", "))
+	}
+	if len(ac.RangeConfig) > 0 {
+		v.checkRanges(path+".rangeConfig", ac.RangeConfig)
+	}
+	if dateActions[ac.Name] {
+		v.checkLayouts(path+".dateConfig", ac.DateConfig)
+	}
+	for i, step := range ac.Pipeline {
+		v.checkAction(fmt.Sprintf("%s.pipeline[%d]", path, i), &step)
+	}
+}
+
+// Checks that the layouts are valid and have at least one element of a
+// date, otherwise they only parse themselves (e.g. YYYYmmdd)
+func (v *configValidator) checkLayouts(path string, dc DateConfig) {
+	if err := checkLayouts(dc.layouts()); err != nil {
+		v.add(path, false, "%v", err)
+		return
+	}
+	reference := time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC)
+	for i, layout := range dc.layouts() {
+		p := path + ".format"
+		if dc.Format == "" {
+			p = fmt.Sprintf("%s.formats[%d]", path, i)
+		} else if i > 0 {
+			p = fmt.Sprintf("%s.formats[%d]", path, i-1)
+		}
+		if reference.Format(layout) == layout {
+			v.add(p, false, "invalid layout %s, it must be written with the reference date 2006-01-02 15:04:05", layout)
+		}
+	}
+}
+
+// bound is a bound of a range
+type bound struct {
+	value  float64
+	closed bool
+}
+
+// Checks that the ranges are valid and that they don't overlap or have
+// gaps between them. The values below or above all of them aren't gaps.
+func (v *configValidator) checkRanges(path string, configs []RangeConfig) {
+	for i, rc := range configs {
+		if _, err := ranges([]RangeConfig{rc}); err != nil {
+			v.add(fmt.Sprintf("%s[%d]", path, i), false, "%v", err)
+			return
+		}
+	}
+	type interval struct {
+		i            int
+		lower, upper bound
+	}
+	intervals := make([]interval, len(configs))
+	for i, rc := range configs {
+		in := interval{i: i, lower: bound{math.Inf(-1), false}, upper: bound{math.Inf(1), false}}
+		if rc.Gt != nil {
+			in.lower = bound{*rc.Gt, false}
+		} else if rc.Gte != nil {
+			in.lower = bound{*rc.Gte, true}
+		}
+		if rc.Lt != nil {
+			in.upper = bound{*rc.Lt, false}
+		} else if rc.Lte != nil {
+			in.upper = bound{*rc.Lte, true}
+		}
+		intervals[i] = in
+	}
+	sort.SliceStable(intervals, func(i, j int) bool {
+		a, b := intervals[i].lower, intervals[j].lower
+		return a.value < b.value || a.value == b.value && a.closed && !b.closed
+	})
+	covered := intervals[0]
+	for _, in := range intervals[1:] {
+		p := fmt.Sprintf("%s[%d]", path, in.i)
+		if covered.upper.value > in.lower.value || covered.upper.value == in.lower.value && covered.upper.closed && in.lower.closed {
+			v.add(p, false, "range %d overlaps range %d", in.i, covered.i)
+		} else if covered.upper.value < in.lower.value || !covered.upper.closed && !in.lower.closed {
+			v.add(p, false, "there is a gap between range %d and range %d", covered.i, in.i)
+		}
+		if in.upper.value > covered.upper.value || in.upper.value == covered.upper.value && in.upper.closed {
+			covered = in
+		}
+	}
+}
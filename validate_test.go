@@ -0,0 +1,114 @@
+package anon
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validate(t *testing.T, config string) []Problem {
+	problems, err := ValidateConfig(strings.NewReader(config))
+	require.NoError(t, err)
+	return problems
+}
+
+func TestValidateConfig(t *testing.T) {
+	t.Run("with a valid config", func(t *testing.T) {
+		os.Setenv("ANON_TEST_KEY", sivKey)
+		defer os.Unsetenv("ANON_TEST_KEY")
+		assert.Empty(t, validate(t, `{
+  "csv": {"delimiter": ";", "header": true, "rename": {"email": "email_hash"}},
+  "actions": [
+    {"Name": "hmac", "column": "email", "hmacConfig": {"keyEnv": "ANON_TEST_KEY", "algorithm": "sha512"}},
+    {"name": "year", "column": "dob", "dateConfig": {"format": "20060102"}, "comment": "generalised"},
+    {"name": "pipeline", "column": "postcode", "pipeline": [{"name": "outcode"}, {"name": "hash", "salt": "salt"}]},
+    {"name": "ranges", "column": "age", "rangeConfig": [{"lt": 18, "output": "<18"}, {"gte": 18, "lte": 65, "output": "18-65"}, {"gt": 65, "output": ">65"}]}
+  ]
+}`))
+	})
+	t.Run("with actions that depend on the context", func(t *testing.T) {
+		assert.Empty(t, validate(t, `{
+  "csv": {"header": true},
+  "actions": [
+    {"name": "bin", "column": "income", "binConfig": {"quantiles": 4}},
+    {"name": "age", "column": "dob", "dateConfig": {"format": "2006-01-02"}, "ageConfig": {"referenceColumn": "admission"}}
+  ]
+}`))
+		problems := validate(t, `{"csv": {"header": true}, "actions": [{"name": "bin", "column": "income", "binConfig": {"quantiles": -1}}]}`)
+		require.Len(t, problems, 1)
+		assert.Equal(t, "actions", problems[0].Path, "should check their config")
+	})
+	t.Run("with the config of the tests", func(t *testing.T) {
+		f, err := os.Open("config_test.json")
+		require.NoError(t, err)
+		defer f.Close()
+		problems, err := ValidateConfig(f)
+		require.NoError(t, err)
+		assert.Equal(t, []Problem{Problem{Path: "sampling.idColumn", Line: 7, Column: 5, Warning: true, Message: "idColumn 84 is out of the 5 columns with actions"}}, problems)
+	})
+	t.Run("with invalid JSON", func(t *testing.T) {
+		problems := validate(t, "{\n  \"actions\": [\n    {\"name\": \"hash\",}\n  ]\n}")
+		require.Len(t, problems, 1)
+		assert.Equal(t, 3, problems[0].Line, "should return the line of the syntax error")
+		assert.Equal(t, 21, problems[0].Column, "should return the column of the syntax error")
+	})
+	t.Run("with unknown fields and values of the wrong type", func(t *testing.T) {
+		problems := validate(t, `{
+  "sampling": {"mod": 10, "idColumn": -1},
+  "actions": [
+    {"name": "range", "rangeConfig": {"ranges": [{"gte": 0, "lt": 100, "output": "0-100"}]}},
+    {"name": "hash", "salt": 1, "hmacConfig": {"keyFiles": "key"}}
+  ]
+}`)
+		assert.Equal(t, []Problem{
+			Problem{Path: "sampling.idColumn", Line: 2, Column: 27, Message: "-1 is out of range, it must be an integer between 0 and 4294967295"},
+			Problem{Path: "actions[0].rangeConfig", Line: 4, Column: 23, Message: "expected an array, found an object"},
+			Problem{Path: "actions[1].salt", Line: 5, Column: 22, Message: "expected a string, found a number"},
+			Problem{Path: "actions[1].hmacConfig.keyFiles", Line: 5, Column: 48, Message: "unknown field keyFiles"},
+		}, problems)
+	})
+	t.Run("with invalid actions", func(t *testing.T) {
+		problems := validate(t, `{
+  "actions": [
+    {"name": "range"},
+    {"name": "date", "dateConfig": {"format": "YYYYmmdd", "formats": ["2006", "dd/mm"]}},
+    {"name": "pipeline", "pipeline": [{"name": "hashh"}]}
+  ]
+}`)
+		require.Len(t, problems, 4)
+		assert.Equal(t, "3:6: actions[0].name: unknown action \"range\", it must be one of "+strings.Join(Actions(), ", "), problems[0].String())
+		assert.Equal(t, "4:37: actions[1].dateConfig.format: invalid layout YYYYmmdd, it must be written with the reference date 2006-01-02 15:04:05", problems[1].String())
+		assert.Equal(t, "actions[1].dateConfig.formats[1]", problems[2].Path)
+		assert.Equal(t, "actions[2].pipeline[0].name", problems[3].Path, "should validate the steps of the pipelines")
+	})
+	t.Run("with an invalid config", func(t *testing.T) {
+		problems := validate(t, `{"csv": {"header": true, "detectHeader": true}}`)
+		assert.Equal(t, []Problem{Problem{Message: "only one of header and detectHeader can be set"}}, problems, "should return the errors of the config as a whole")
+		problems = validate(t, `{"actions": [{"name": "hmac"}]}`)
+		require.Len(t, problems, 1)
+		assert.Equal(t, "actions", problems[0].Path, "should return the errors creating the actions")
+		problems = validate(t, `{"sampling": {"idColumnName": "id"}}`)
+		assert.Equal(t, []Problem{Problem{Path: "sampling.idColumnName", Line: 1, Column: 15, Message: "the id column id is referenced by name, but the csv doesn't have a header"}}, problems)
+		problems = validate(t, `{"actions": [{"name": "outcode", "column": "postcode"}, {"name": "nothing", "column": "id"}]}`)
+		assert.Equal(t, []Problem{Problem{Path: "actions[0].column", Line: 1, Column: 34, Message: "action 0 (outcode) references column postcode by name, but the csv doesn't have a header"}}, problems, "should reject the columns referenced by name without a header")
+	})
+}
+
+func TestValidateRanges(t *testing.T) {
+	check := func(ranges string) []string {
+		var messages []string
+		for _, p := range validate(t, `{"actions": [{"name": "ranges", "rangeConfig": `+ranges+`}]}`) {
+			messages = append(messages, p.Path+": "+p.Message)
+		}
+		return messages
+	}
+	assert.Empty(t, check(`[{"gte": 10, "lt": 20, "output": "b"}, {"lt": 10, "output": "a"}, {"gte": 20, "output": "c"}]`), "should accept contiguous ranges in any order")
+	assert.Empty(t, check(`[{"gt": 0, "lte": 10, "output": "a"}, {"gt": 10, "lte": 20, "output": "b"}]`))
+	assert.Equal(t, []string{"actions[0].rangeConfig[1]: range 1 overlaps range 0"}, check(`[{"gte": 0, "lte": 10, "output": "a"}, {"gte": 10, "lt": 20, "output": "b"}]`))
+	assert.Equal(t, []string{"actions[0].rangeConfig[1]: there is a gap between range 0 and range 1"}, check(`[{"gte": 0, "lt": 10, "output": "a"}, {"gt": 10, "lt": 20, "output": "b"}]`))
+	assert.Equal(t, []string{"actions[0].rangeConfig[0]: there is a gap between range 1 and range 0"}, check(`[{"gte": 50, "output": "b"}, {"lt": 10, "output": "a"}]`))
+	assert.Equal(t, []string{"actions[0].rangeConfig[1]: you can only specify one of (gt, gte) and (lt, lte)"}, check(`[{"gte": 0, "output": "a"}, {"gt": 0, "gte": 0, "output": "b"}]`))
+}